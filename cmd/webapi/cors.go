@@ -13,7 +13,8 @@ func applyCORSHandler(h http.Handler) http.Handler {
 	return handlers.CORS(
 		// ! WE NEED TO SPECIFY THE HEADERS WE WANT TO ALLOW
 		handlers.AllowedHeaders([]string{"Authorization", "Access-Control-Allow-Origin", "content-type", "multipart/form-data", "blob"}),
-		handlers.AllowedMethods([]string{"GET", "POST", "OPTIONS", "DELETE", "PUT"}),
+		handlers.AllowedMethods([]string{"GET", "POST", "OPTIONS", "DELETE", "PUT", "PATCH"}),
+		handlers.ExposedHeaders([]string{"Retry-After"}),
 		// Do not modify the CORS origin and max age, they are used in the evaluation.
 		handlers.AllowedOrigins([]string{"*"}),
 		handlers.MaxAge(1),
@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"github.com/ardanlabs/conf"
+	"gitlab.com/mycompany8201046/myProject/service/api"
+	"gitlab.com/mycompany8201046/myProject/service/api/ratelimit"
 	"gopkg.in/yaml.v2"
 )
 
@@ -24,6 +26,38 @@ type WebAPIConfiguration struct {
 		WriteTimeout    time.Duration `conf:"default:5s"`
 		ShutdownTimeout time.Duration `conf:"default:5s"`
 	}
+	// Auth.ResetPassword is the name of a user to issue a password reset token for: the token is printed and the
+	// server exits without starting. It lasts Auth.ResetTTL.
+	Auth struct {
+		SessionTTL    time.Duration `conf:"default:168h"`
+		ResetPassword string
+		ResetTTL      time.Duration `conf:"default:24h"`
+	}
+	Messages struct {
+		EditWindow       time.Duration `conf:"default:15m"`
+		ScheduleInterval time.Duration `conf:"default:1s"`
+		// LinkPreviews enables fetching the previews of the links sent, which makes the server connect to them
+		LinkPreviews bool `conf:"default:true"`
+	}
+	// Attachments.Types are the MIME types of the files accepted, separated by ";". The uploads and downloads of files
+	// get Attachments.TransferTimeout instead of Web.ReadTimeout and Web.WriteTimeout, and a file of MaxSize must fit
+	// in it: raise both together.
+	Attachments struct {
+		MaxSize         int64         `conf:"default:26214400"`
+		TransferTimeout time.Duration `conf:"default:10m"`
+		Types           []string      `conf:"default:application/pdf;text/plain;application/zip;application/x-gzip;application/x-rar-compressed;application/ogg;audio/mpeg;audio/wave;video/mp4;video/webm;image/png;image/jpeg;image/gif;image/webp"`
+		Dir             string        `conf:"default:./files"`
+	}
+	Sync struct {
+		Retention time.Duration `conf:"default:720h"`
+	}
+	// RateLimit budgets are in the "N/duration" form, e.g. "10/1m"; "0" disables the limit. Routes are
+	// "METHOD /path=N/duration" entries separated by ";", with the path as registered in the API router.
+	RateLimit struct {
+		PerIP   string   `conf:"default:600/1m"`
+		PerUser string   `conf:"default:300/1m"`
+		Routes  []string `conf:"default:POST /session=10/1m;POST /password-reset=10/1m;POST /users=5/1h;GET /users/:userId/search=30/1m;GET /users/:userId/conversations/:conversationId/search=30/1m"`
+	}
 	Debug bool
 	DB    struct {
 		Filename string `conf:"default:/tmp/decaf.db"`
@@ -69,3 +103,17 @@ func loadConfiguration() (WebAPIConfiguration, error) {
 
 	return cfg, nil
 }
+
+// parseRateLimit converts the rate limit section of the configuration into the budgets used by the API router.
+func parseRateLimit(cfg WebAPIConfiguration) (api.RateLimitConfig, error) {
+	var rl api.RateLimitConfig
+	var err error
+	if rl.PerIP, err = ratelimit.ParseLimit(cfg.RateLimit.PerIP); err != nil {
+		return rl, err
+	}
+	if rl.PerUser, err = ratelimit.ParseLimit(cfg.RateLimit.PerUser); err != nil {
+		return rl, err
+	}
+	rl.Routes, err = ratelimit.ParseRoutes(cfg.RateLimit.Routes)
+	return rl, err
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"gitlab.com/mycompany8201046/myProject/service/api"
 	"gitlab.com/mycompany8201046/myProject/service/api/globaltime"
+	"gitlab.com/mycompany8201046/myProject/service/api/linkpreview"
 	"gitlab.com/mycompany8201046/myProject/service/database"
 )
 
@@ -68,13 +69,22 @@ func run() error {
 	validations := map[string][]string{
 		"User":    {"userName"},
 		"Message": {"content"},
-		"Comment": {"content"},
 		"GroupTB": {"Name", "Description"},
 	}
 
 	// Add the hook
 	db.AddPreCommitHook(db.CreateSanitizeHook(validations))
 
+	if cfg.Auth.ResetPassword != "" {
+		token, err := api.IssuePasswordReset(db, cfg.Auth.ResetPassword, cfg.Auth.ResetTTL)
+		if err != nil {
+			logger.WithError(err).Error("error issuing the password reset token")
+			return fmt.Errorf("issuing the password reset token: %w", err)
+		}
+		fmt.Printf("password reset token for %s, valid for %s: %s\n", cfg.Auth.ResetPassword, cfg.Auth.ResetTTL, token) //nolint:forbidigo
+		return nil
+	}
+
 	// Start (main) API server
 	logger.Info("initializing API server")
 
@@ -87,10 +97,33 @@ func run() error {
 	// buffered channel so the goroutine can exit if we don't collect this error.
 	serverErrors := make(chan error, 1)
 
+	rateLimit, err := parseRateLimit(cfg)
+	if err != nil {
+		logger.WithError(err).Error("error parsing the rate limit configuration")
+		return fmt.Errorf("parsing the rate limit configuration: %w", err)
+	}
+
+	var previews *linkpreview.Fetcher
+	if cfg.Messages.LinkPreviews {
+		previews = linkpreview.New(linkpreview.Options{})
+	}
+
 	// Create the API router
 	apirouter, err := api.New(api.Config{
-		Logger:   logger,
-		Database: db,
+		Logger:            logger,
+		Database:          db,
+		SessionTTL:        cfg.Auth.SessionTTL,
+		MessageEditWindow: cfg.Messages.EditWindow,
+		SyncRetention:     cfg.Sync.Retention,
+		RateLimit:         rateLimit,
+		ScheduleInterval:  cfg.Messages.ScheduleInterval,
+		LinkPreviews:      previews,
+		Attachments: api.AttachmentConfig{
+			MaxSize:         cfg.Attachments.MaxSize,
+			AllowedTypes:    cfg.Attachments.Types,
+			Dir:             cfg.Attachments.Dir,
+			TransferTimeout: cfg.Attachments.TransferTimeout,
+		},
 	})
 	if err != nil {
 		logger.WithError(err).Error("error creating the API server instance")
@@ -114,6 +147,8 @@ func run() error {
 		ReadTimeout:       cfg.Web.ReadTimeout,
 		ReadHeaderTimeout: cfg.Web.ReadTimeout,
 		WriteTimeout:      cfg.Web.WriteTimeout,
+		// The event streams and the file transfers outlive the timeouts by moving the deadlines of their connection
+		ConnContext: api.ConnContext,
 	}
 
 	// Start the service listening for requests in a separate goroutine
@@ -13,8 +13,10 @@ import (
 // required by the httprouter package.
 type httpRouterHandler func(http.ResponseWriter, *http.Request, httprouter.Params, reqcontext.RequestContext)
 
-// wrap parses the request and adds a reqcontext.RequestContext instance related to the request.
-func (rt *_router) wrap(fn httpRouterHandler) func(http.ResponseWriter, *http.Request, httprouter.Params) {
+// wrap parses the request and adds a reqcontext.RequestContext instance related to the request. It also throttles the
+// requests by remote IP; authenticated requests are throttled by user too, in isAuthed, and count as activity for the
+// presence of the user.
+func (rt *_router) wrap(route string, fn httpRouterHandler) func(http.ResponseWriter, *http.Request, httprouter.Params) {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		reqUUID, err := uuid.NewV4()
 		if err != nil {
@@ -22,8 +24,11 @@ func (rt *_router) wrap(fn httpRouterHandler) func(http.ResponseWriter, *http.Re
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		var activeUser int64
 		var ctx = reqcontext.RequestContext{
-			ReqUUID: reqUUID,
+			ReqUUID:    reqUUID,
+			Route:      route,
+			ActiveUser: &activeUser,
 		}
 
 		// Create a request-specific logger
@@ -32,7 +37,17 @@ func (rt *_router) wrap(fn httpRouterHandler) func(http.ResponseWriter, *http.Re
 			"remote-ip": r.RemoteAddr,
 		})
 
+		if !rt.allowRequest(w, r, route, "ip:"+clientIP(r), rt.rateLimit.PerIP) {
+			ctx.Logger.Info("rate limit exceeded for ", route)
+			return
+		}
+
 		// Call the next handler in chain (usually, the handler function for the path)
 		fn(w, r, ps, ctx)
+
+		// Authenticated requests keep the user online
+		if activeUser != 0 {
+			rt.markSeen(activeUser)
+		}
 	}
 }
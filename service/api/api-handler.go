@@ -15,40 +15,82 @@ func (rt *_router) Handler() http.Handler {
 	})
 
 	// GET methods
-	// 	rt.router.GET("/", rt.wrap(rt.home_test))
-	rt.router.GET("/users", rt.wrap(rt.getUsers))
-	rt.router.GET("/users/:userId", rt.wrap(rt.getUsersNotInConversation))
-	rt.router.GET("/users/:userId/conversations", rt.wrap(rt.getMyConversations))
-	rt.router.GET("/users/:userId/conversations/:conversationId", rt.wrap(rt.getConversation))
-	rt.router.GET("/users/:userId/photo", rt.wrap(rt.getUserPicture))
-	rt.router.GET("/groups/:groupId", rt.wrap(rt.getGroupInfo))
-	rt.router.GET("/groups/:groupId/users", rt.wrap(rt.getGroupUsers))
-	rt.router.GET("/groups/:groupId/photo", rt.wrap(rt.getGroupPicture))
-	rt.router.GET("/photos/:photoId", rt.wrap(rt.getPhoto))
-	rt.router.GET("/users/:userId/conversations/:conversationId/messages/:messageId/status", rt.wrap(rt.getMessageStatus))
-	rt.router.GET("/users/:userId/conversations/:conversationId/messages/:messageId/comments", rt.wrap(rt.getComments))
-	rt.router.GET("/conversations/:conversationId/messages/:messageId", rt.wrap(rt.getMessage))
+	// 	rt.handle(http.MethodGet, "/", rt.home_test)
+	rt.handle(http.MethodGet, "/users", rt.getUsers)
+	rt.handle(http.MethodGet, "/users/:userId", rt.getUsersNotInConversation)
+	rt.handle(http.MethodGet, "/users/:userId/conversations", rt.getMyConversations)
+	rt.handle(http.MethodGet, "/users/:userId/conversations/:conversationId", rt.getConversation)
+	rt.handle(http.MethodGet, "/users/:userId/photo", rt.getUserPicture)
+	rt.handle(http.MethodGet, "/users/:userId/sessions", rt.getMySessions)
+	rt.handle(http.MethodGet, "/users/:userId/search", rt.searchMessages)
+	rt.handle(http.MethodGet, "/users/:userId/starred", rt.getStarred)
+	rt.handle(http.MethodGet, "/users/:userId/mentions", rt.getMentions)
+	rt.handle(http.MethodGet, "/users/:userId/scheduled", rt.getScheduled)
+	rt.handle(http.MethodGet, "/users/:userId/events", rt.getEvents)
+	rt.handle(http.MethodGet, "/users/:userId/sync", rt.sync)
+	rt.handle(http.MethodGet, "/users/:userId/conversations/:conversationId/search", rt.searchConversation)
+	rt.handle(http.MethodGet, "/users/:userId/conversations/:conversationId/pins", rt.getPins)
+	rt.handle(http.MethodGet, "/users/:userId/conversations/:conversationId/presence", rt.getPresence)
+	rt.handle(http.MethodGet, "/users/:userId/privacy", rt.getPrivacy)
+	rt.handle(http.MethodGet, "/groups/:groupId", rt.getGroupInfo)
+	rt.handle(http.MethodGet, "/groups/:groupId/users", rt.getGroupUsers)
+	rt.handle(http.MethodGet, "/groups/:groupId/photo", rt.getGroupPicture)
+	rt.handle(http.MethodGet, "/photos/:photoId", rt.getPhoto)
+	rt.handle(http.MethodGet, "/files/:fileId", rt.getFile)
+	rt.handle(http.MethodGet, "/users/:userId/conversations/:conversationId/messages/:messageId/status", rt.getMessageStatus)
+	rt.handle(http.MethodGet, "/users/:userId/conversations/:conversationId/messages/:messageId/reactions", rt.getReactions)
+	rt.handle(http.MethodGet, "/users/:userId/conversations/:conversationId/messages/:messageId/history", rt.getMessageHistory)
+	rt.handle(http.MethodGet, "/users/:userId/conversations/:conversationId/messages/:messageId", rt.getMessage)
 
 	// POST methods
-	rt.router.POST("/session", rt.wrap(rt.doLogin))
-	rt.router.POST("/groups", rt.wrap(rt.createGroup))
-	rt.router.POST("/groups/:groupId/users/:userId", rt.wrap(rt.addToGroup))
-	rt.router.POST("/groups/:groupId/name", rt.wrap(rt.setGroupName))
-	rt.router.POST("/groups/:groupId/desc", rt.wrap(rt.setGroupDesc))
-	rt.router.POST("/conversations/create", rt.wrap(rt.createConversation))
-	rt.router.POST("/users/:userId/conversations/:conversationId/messages", rt.wrap(rt.sendMessage))
-	rt.router.POST("/users/:userId/conversations/:conversationId/messages/photo", rt.wrap(rt.sendPhotoMessage))
-	rt.router.POST("/users/:userId/conversations/:conversationId/messages/read/:messageId", rt.wrap(rt.readMessage))
-	rt.router.POST("/users/:userId/conversations/:conversationId/messages/forward/:messageId", rt.wrap(rt.forwardMessage))
-	rt.router.POST("/users/:userId/conversations/:conversationId/messages/comments/:messageId", rt.wrap(rt.commentMessage))
-	rt.router.POST("/users/:userId/username", rt.wrap(rt.setMyUserName))
-	rt.router.POST("/photos/:photoId/users/:userId/photo", rt.wrap(rt.setMyPhoto))
-	rt.router.POST("/photos/:photoId/groups/:groupId/photo", rt.wrap(rt.setGroupPhoto))
+	rt.handle(http.MethodPost, "/session", rt.doLogin)
+	rt.handle(http.MethodPost, "/password-reset", rt.resetPassword)
+	rt.handle(http.MethodPost, "/users", rt.registerUser)
+	rt.handle(http.MethodPost, "/groups", rt.createGroup)
+	rt.handle(http.MethodPost, "/groups/:groupId/users/:userId", rt.addToGroup)
+	rt.handle(http.MethodPost, "/groups/:groupId/name", rt.setGroupName)
+	rt.handle(http.MethodPost, "/groups/:groupId/desc", rt.setGroupDesc)
+	rt.handle(http.MethodPost, "/conversations/create", rt.createConversation)
+	rt.handle(http.MethodPost, "/users/:userId/conversations/:conversationId/messages", rt.sendMessage)
+	rt.handle(http.MethodPost, "/users/:userId/conversations/:conversationId/scheduled", rt.scheduleMessage)
+	rt.handle(http.MethodPost, "/users/:userId/conversations/:conversationId/lifetime", rt.setMessageLifetime)
+	rt.handle(http.MethodPost, "/users/:userId/conversations/:conversationId/typing", rt.startTyping)
+	rt.handle(http.MethodPost, "/users/:userId/conversations/:conversationId/messages/photo", rt.sendPhotoMessage)
+	rt.handle(http.MethodPost, "/users/:userId/conversations/:conversationId/messages/file", rt.sendFileMessage)
+	rt.handle(http.MethodPost, "/users/:userId/conversations/:conversationId/messages/read/:messageId", rt.readMessage)
+	rt.handle(http.MethodPost, "/users/:userId/conversations/:conversationId/read/:messageId", rt.markConversationRead)
+	rt.handle(http.MethodPost, "/users/:userId/conversations/:conversationId/messages/forward/:messageId", rt.forwardMessage)
+	rt.handle(http.MethodPost, "/users/:userId/conversations/:conversationId/messages/reactions/:messageId", rt.addReaction)
+	rt.handle(http.MethodPost, "/users/:userId/conversations/:conversationId/messages/votes/:messageId", rt.votePoll)
+	rt.handle(http.MethodPost, "/users/:userId/conversations/:conversationId/messages/close/:messageId", rt.closePoll)
+	rt.handle(http.MethodPost, "/users/:userId/conversations/:conversationId/messages/pin/:messageId", rt.pinMessage)
+	rt.handle(http.MethodPost, "/users/:userId/conversations/:conversationId/messages/star/:messageId", rt.starMessage)
+	rt.handle(http.MethodPost, "/users/:userId/username", rt.setMyUserName)
+	rt.handle(http.MethodPost, "/users/:userId/password", rt.setMyPassword)
+	rt.handle(http.MethodPost, "/users/:userId/privacy", rt.setPrivacy)
+	rt.handle(http.MethodPost, "/photos/:photoId/users/:userId/photo", rt.setMyPhoto)
+	rt.handle(http.MethodPost, "/photos/:photoId/groups/:groupId/photo", rt.setGroupPhoto)
+
+	// PATCH methods
+	rt.handle(http.MethodPatch, "/users/:userId/conversations/:conversationId/messages/:messageId", rt.editMessage)
+	rt.handle(http.MethodPatch, "/users/:userId/scheduled/:scheduledId", rt.rescheduleMessage)
 
 	// DELETE methods
-	rt.router.DELETE("/users/:userId/conversations/:conversationId/messages/:messageId/comments/:commentId", rt.wrap(rt.uncommentMessage))
-	rt.router.DELETE("/users/:userId/conversations/:conversationId/messages/:messageId", rt.wrap(rt.deleteMessage))
-	rt.router.DELETE("/groups/:groupId/users/:userId", rt.wrap(rt.leaveGroup))
+	rt.handle(http.MethodDelete, "/session", rt.doLogout)
+	rt.handle(http.MethodDelete, "/users/:userId/sessions", rt.deleteMyOtherSessions)
+	rt.handle(http.MethodDelete, "/users/:userId/sessions/:sessionId", rt.deleteMySession)
+	rt.handle(http.MethodDelete, "/users/:userId/conversations/:conversationId/messages/:messageId/reactions/:emoji", rt.removeReaction)
+	rt.handle(http.MethodDelete, "/users/:userId/conversations/:conversationId/messages/:messageId/pin", rt.unpinMessage)
+	rt.handle(http.MethodDelete, "/users/:userId/conversations/:conversationId/messages/:messageId/star", rt.unstarMessage)
+	rt.handle(http.MethodDelete, "/users/:userId/conversations/:conversationId/messages/:messageId", rt.deleteMessage)
+	rt.handle(http.MethodDelete, "/groups/:groupId/users/:userId", rt.leaveGroup)
+	rt.handle(http.MethodDelete, "/users/:userId/scheduled/:scheduledId", rt.cancelScheduled)
+	rt.handle(http.MethodDelete, "/users/:userId/conversations/:conversationId/typing", rt.stopTyping)
 
 	return rt.router
 }
+
+// handle registers fn as the handler of the route. The route is passed to wrap, so that it can apply the route budget.
+func (rt *_router) handle(method string, path string, fn httpRouterHandler) {
+	rt.router.Handle(method, path, rt.wrap(method+" "+path, fn))
+}
@@ -37,11 +37,18 @@ See the `main.go` file inside the `cmd/webapi` for a full usage example.
 package api
 
 import (
+	"context"
 	"errors"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
+	"gitlab.com/mycompany8201046/myProject/service/api/events"
+	"gitlab.com/mycompany8201046/myProject/service/api/linkpreview"
+	"gitlab.com/mycompany8201046/myProject/service/api/presence"
+	"gitlab.com/mycompany8201046/myProject/service/api/ratelimit"
 	"gitlab.com/mycompany8201046/myProject/service/database"
 )
 
@@ -52,6 +59,29 @@ type Config struct {
 
 	// Database is the instance of database.AppDatabase where data are saved
 	Database database.AppDatabase
+
+	// SessionTTL is how long a session token stays valid after login. Defaults to one week.
+	SessionTTL time.Duration
+
+	// MessageEditWindow is how long after sending a message its sender can still edit it. Defaults to 15 minutes.
+	MessageEditWindow time.Duration
+
+	// SyncRetention is how long the changes are kept for delta sync: clients offline for longer have to reload
+	// everything. Defaults to 30 days.
+	SyncRetention time.Duration
+
+	// RateLimit holds the request budgets. The zero value disables rate limiting.
+	RateLimit RateLimitConfig
+
+	// ScheduleInterval is how often the scheduled messages that became due are sent, and the expired messages deleted.
+	// Defaults to 1 second.
+	ScheduleInterval time.Duration
+
+	// Attachments holds the limits on the files sent in messages.
+	Attachments AttachmentConfig
+
+	// LinkPreviews fetches the previews of the links sent in messages. Nil disables link previews.
+	LinkPreviews *linkpreview.Fetcher
 }
 
 // Router is the package API interface representing an API handler builder
@@ -79,11 +109,56 @@ func New(cfg Config) (Router, error) {
 	router.RedirectTrailingSlash = false
 	router.RedirectFixedPath = false
 
-	return &_router{
-		router:     router,
-		baseLogger: cfg.Logger,
-		db:         cfg.Database,
-	}, nil
+	if cfg.SessionTTL <= 0 {
+		cfg.SessionTTL = 7 * 24 * time.Hour
+	}
+	if cfg.MessageEditWindow <= 0 {
+		cfg.MessageEditWindow = 15 * time.Minute
+	}
+	if cfg.SyncRetention <= 0 {
+		cfg.SyncRetention = 30 * 24 * time.Hour
+	}
+	if cfg.ScheduleInterval <= 0 {
+		cfg.ScheduleInterval = time.Second
+	}
+	if cfg.Attachments.MaxSize <= 0 {
+		cfg.Attachments.MaxSize = 25 << 20
+	}
+	if len(cfg.Attachments.AllowedTypes) == 0 {
+		cfg.Attachments.AllowedTypes = DefaultAttachmentTypes
+	}
+	if cfg.Attachments.Dir == "" {
+		cfg.Attachments.Dir = "./files"
+	}
+	if cfg.Attachments.TransferTimeout <= 0 {
+		cfg.Attachments.TransferTimeout = 10 * time.Minute
+	}
+
+	previewCtx, stopPreviews := context.WithCancel(context.Background())
+	rt := &_router{
+		router:        router,
+		baseLogger:    cfg.Logger,
+		db:            cfg.Database,
+		sessionTTL:    cfg.SessionTTL,
+		editWindow:    cfg.MessageEditWindow,
+		syncRetention: cfg.SyncRetention,
+		rateLimit:     cfg.RateLimit,
+		attachments:   cfg.Attachments,
+		limiter:       ratelimit.New(),
+		hub:           events.NewHub(),
+		presence:      presence.New(),
+
+		previewer:    cfg.LinkPreviews,
+		fetching:     make(map[string][]messageKey),
+		previewSlots: make(chan struct{}, maxPreviewFetches),
+		previewCtx:   previewCtx,
+		stopPreviews: stopPreviews,
+
+		stopWorker: make(chan struct{}),
+		workerDone: make(chan struct{}),
+	}
+	go rt.runWorker(cfg.ScheduleInterval)
+	return rt, nil
 }
 
 type _router struct {
@@ -94,4 +169,41 @@ type _router struct {
 	baseLogger logrus.FieldLogger
 
 	db database.AppDatabase
+
+	sessionTTL    time.Duration
+	editWindow    time.Duration
+	syncRetention time.Duration
+
+	// lastPrune is the last time the change log was pruned, only used by the background worker
+	lastPrune time.Time
+
+	rateLimit RateLimitConfig
+	limiter   *ratelimit.Limiter
+
+	attachments AttachmentConfig
+	// lastAttachmentPrune is the last time the unused files were deleted, only used by the background worker
+	lastAttachmentPrune time.Time
+
+	// hub feeds the event streams of the connected clients
+	hub *events.Hub
+
+	// presence tracks who is online and who is typing
+	presence *presence.Tracker
+
+	// previewer fetches the previews of the links, nil if they are disabled. fetching holds the links being fetched,
+	// with the messages waiting for them, guarded by previewMu; previewSlots bounds the fetches running at once.
+	// Close cancels previewCtx and waits for the fetches tracked by previews.
+	previewer    *linkpreview.Fetcher
+	previewMu    sync.Mutex
+	fetching     map[string][]messageKey
+	previewSlots chan struct{}
+	previewCtx   context.Context
+	stopPreviews context.CancelFunc
+	previews     sync.WaitGroup
+
+	// stopWorker is closed by Close to stop the background worker sending the scheduled messages and deleting the
+	// expired ones, which closes workerDone once stopped
+	stopWorker chan struct{}
+	workerDone chan struct{}
+	closeOnce  sync.Once
 }
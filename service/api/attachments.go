@@ -0,0 +1,387 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gofrs/uuid"
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/mycompany8201046/myProject/service/api/globaltime"
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+	"gitlab.com/mycompany8201046/myProject/service/api/reqcontext"
+)
+
+const (
+	// maxFileNameLength bounds the original name of a file, in characters
+	maxFileNameLength = 255
+
+	// attachmentPruneInterval is how often the files no message references anymore are deleted
+	attachmentPruneInterval = time.Hour
+
+	// attachmentPruneBatch bounds the files deleted at once
+	attachmentPruneBatch = 100
+
+	// attachmentSweepGrace is how old a file no attachment references must be to be swept: a younger one may belong to
+	// an upload still in progress
+	attachmentSweepGrace = 24 * time.Hour
+)
+
+// DefaultAttachmentTypes are the types of files accepted unless configured otherwise: documents, archives, audio,
+// video and images. Web pages and scripts are not.
+var DefaultAttachmentTypes = []string{
+	"application/pdf",
+	"text/plain",
+	"application/zip",
+	"application/x-gzip",
+	"application/x-rar-compressed",
+	"application/ogg",
+	"audio/mpeg",
+	"audio/wave",
+	"video/mp4",
+	"video/webm",
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+}
+
+// AttachmentConfig holds the limits on the files sent in messages.
+type AttachmentConfig struct {
+	// MaxSize is the size of the largest file accepted, in bytes. Defaults to 25 MiB.
+	MaxSize int64
+
+	// AllowedTypes are the MIME types accepted, e.g. "application/pdf". The type of a file is sniffed from its
+	// content, whatever the client says. Defaults to DefaultAttachmentTypes.
+	AllowedTypes []string
+
+	// Dir is the directory where the files are stored. Defaults to ./files.
+	Dir string
+
+	// TransferTimeout bounds the upload and the download of a file, in place of the ReadTimeout and WriteTimeout of the
+	// server: they are meant for small requests, and would cut off most files of MaxSize. It must leave time for a file
+	// of MaxSize at the slowest speed the clients are expected to have. Defaults to 10 minutes.
+	TransferTimeout time.Duration
+}
+
+// sendFileMessage sends a file, with an optional caption, as a multipart form with the fields file, content,
+// repliedId and repliedConvId. The file is streamed to disk: it is never held in memory as a whole.
+func (rt *_router) sendFileMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Sending file message")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	convId, err := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if rt.malformedConvIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) {
+		return
+	}
+
+	rt.extendDeadlines(r, true)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		rt.internalError(400, err, r, w)
+		return
+	}
+	var input model.MessageInput
+	var file model.Attachment
+	sent := false
+	defer func() {
+		// The file of a message that was not sent is of no use
+		if file.Path != "" && !sent {
+			if err := os.Remove(file.Path); err != nil {
+				rt.baseLogger.WithError(err).Error("can't remove the file ", file.Path)
+			}
+		}
+	}()
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			rt.internalError(400, err, r, w)
+			return
+		}
+		code, err := rt.readFilePart(part, &input, &file)
+		_ = part.Close()
+		if err != nil {
+			rt.internalError(code, err, r, w)
+			return
+		}
+	}
+	if file.Path == "" {
+		rt.internalError(400, model.ErrMissingFile, r, w)
+		return
+	}
+	if input.RepliedId > 0 && input.RepliedConvId > 0 && !rt.activeMessage(w, r, input.RepliedId, input.RepliedConvId) {
+		return
+	}
+
+	var msgId model.MessageId
+	msgId.Value, err = rt.db.FileMessage(file, input, usrId, convId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	sent = true
+	rt.typingDone(convId, usrId)
+	rt.previewLink(msgId.Value, convId)
+	rt.publishMessage(model.EventMessageCreated, msgId.Value, convId)
+
+	w.WriteHeader(201)
+	if err = json.NewEncoder(w).Encode(msgId); err != nil {
+		rt.baseLogger.Error("sendFileMessage error:", err)
+	}
+}
+
+// readFilePart reads a part of the form of sendFileMessage into the message or the file, returning the status code
+// of the error if it is not valid. Unknown parts are skipped.
+func (rt *_router) readFilePart(part *multipart.Part, input *model.MessageInput, file *model.Attachment) (int32, error) {
+	switch part.FormName() {
+	case "file":
+		if file.Path != "" {
+			return 400, model.ErrTooManyFiles
+		}
+		return rt.storeAttachment(part, file)
+	case "content":
+		value, err := io.ReadAll(io.LimitReader(part, maxContentLength+1))
+		if err != nil {
+			return 400, err
+		}
+		if len(value) > maxContentLength {
+			return 400, model.ErrMessageTooLong
+		}
+		input.Content = string(value)
+	case "repliedId", "repliedConvId":
+		value, err := io.ReadAll(io.LimitReader(part, 20))
+		if err != nil {
+			return 400, err
+		}
+		id, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return 400, model.AddError(model.ErrMalformedMessageId, err)
+		}
+		if part.FormName() == "repliedId" {
+			input.RepliedId = id
+		} else {
+			input.RepliedConvId = id
+		}
+	}
+	return 0, nil
+}
+
+// storeAttachment checks the type of the file from its first bytes and writes it to disk, filling the attachment.
+// Once Path is set the file exists, even if an error is returned, and it is up to the caller to remove it.
+func (rt *_router) storeAttachment(part *multipart.Part, file *model.Attachment) (int32, error) {
+	// DetectContentType looks at most at the first 512 bytes
+	head := make([]byte, 512)
+	n, err := io.ReadFull(part, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return 400, err
+	}
+	if n == 0 {
+		return 400, model.ErrMissingFile
+	}
+	head = head[:n]
+	mimeType := http.DetectContentType(head)
+	if !rt.allowedAttachment(mimeType) {
+		return 415, model.ErrFileTypeNotAllowed
+	}
+
+	if err = os.MkdirAll(rt.attachments.Dir, 0o750); err != nil {
+		return 500, err
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		return 500, err
+	}
+	file.Path = filepath.Join(rt.attachments.Dir, id.String())
+	out, err := os.OpenFile(file.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		file.Path = ""
+		return 500, err
+	}
+	size, err := io.Copy(out, io.MultiReader(bytes.NewReader(head), io.LimitReader(part, rt.attachments.MaxSize+1-int64(n))))
+	if e := out.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return 400, err
+	}
+	if size > rt.attachments.MaxSize {
+		return 413, model.ErrFileTooLarge
+	}
+
+	file.Name = attachmentName(part.FileName())
+	file.MimeType = mimeType
+	file.Size = size
+	return 0, nil
+}
+
+// allowedAttachment tells if files of the MIME type can be sent.
+func (rt *_router) allowedAttachment(mimeType string) bool {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range rt.attachments.AllowedTypes {
+		if strings.EqualFold(mediaType, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// attachmentName cleans the name the client gave to the file: the directories and the control characters are dropped,
+// and it is cut to maxFileNameLength characters.
+func attachmentName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(name, ""))
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." || name == "/" {
+		return "file"
+	}
+	if utf8.RuneCountInString(name) > maxFileNameLength {
+		name = string([]rune(name)[:maxFileNameLength])
+	}
+	return name
+}
+
+// getFile downloads a file sent in one of the conversations of the user, under its original name.
+func (rt *_router) getFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	fileId, err := strconv.ParseInt(ps.ByName("fileId"), 10, 64)
+	if err != nil {
+		rt.internalError(400, model.AddError(model.ErrMalformedFileId, err), r, w)
+		return
+	}
+
+	file, err := rt.db.GetAttachment(fileId, ctx.UserId)
+	if errors.Is(err, sql.ErrNoRows) {
+		rt.internalError(404, model.ErrFileNotFound, r, w)
+		return
+	} else if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	content, err := os.Open(file.Path)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	defer content.Close()
+	rt.extendDeadlines(r, false)
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": file.Name})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Type", file.MimeType)
+	w.Header().Set("Content-Disposition", disposition)
+	// Browsers must neither guess another type nor run anything the file contains
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("Cache-Control", "private")
+	http.ServeContent(w, r, "", time.Unix(file.CreatedAt, 0), content)
+}
+
+// pruneAttachments deletes, at most every attachmentPruneInterval, the files no message references anymore.
+func (rt *_router) pruneAttachments() {
+	now := globaltime.Now()
+	if now.Sub(rt.lastAttachmentPrune) < attachmentPruneInterval {
+		return
+	}
+	rt.lastAttachmentPrune = now
+	paths, err := rt.db.DeleteOrphanAttachments(now.Unix(), attachmentPruneBatch)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("can't delete the files no longer used")
+		return
+	}
+	for _, p := range paths {
+		if err = os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			rt.baseLogger.WithError(err).Error("can't remove the file ", p)
+		}
+	}
+	if len(paths) == attachmentPruneBatch {
+		// There may be more: they go on the next run
+		rt.lastAttachmentPrune = time.Time{}
+		return
+	}
+	rt.sweepAttachments()
+}
+
+// sweepAttachments removes the files of the attachment directory that no attachment references and that are older
+// than attachmentSweepGrace. They are left by the uploads the server could not clean up after, e.g. as it stopped.
+func (rt *_router) sweepAttachments() {
+	entries, err := os.ReadDir(rt.attachments.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	} else if err != nil {
+		rt.baseLogger.WithError(err).Error("can't list the files")
+		return
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < attachmentSweepGrace {
+			continue
+		}
+		p := filepath.Join(rt.attachments.Dir, entry.Name())
+		stored, err := rt.db.HasAttachment(p)
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("can't look up the file ", p)
+			return
+		}
+		if stored {
+			continue
+		}
+		if err = os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			rt.baseLogger.WithError(err).Error("can't remove the file ", p)
+		}
+	}
+}
+
+// extendDeadlines gives the file transfer of the request TransferTimeout to write the response and, if read is set,
+// to read the body, in place of the server timeouts. It does nothing if the connection is not known.
+func (rt *_router) extendDeadlines(r *http.Request, read bool) {
+	conn := requestConn(r)
+	if conn == nil {
+		return
+	}
+	deadline := time.Now().Add(rt.attachments.TransferTimeout)
+	err := conn.SetWriteDeadline(deadline)
+	if err == nil && read {
+		err = conn.SetReadDeadline(deadline)
+	}
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("can't extend the deadlines of the connection")
+	}
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+	"gitlab.com/mycompany8201046/myProject/service/api/reqcontext"
+)
+
+// The authorize* functions must be called after isAuthed, as they rely on ctx.UserId. Each of them sends the error
+// back to the client and returns false when the authenticated user is not allowed to go on.
+
+// authorizeSelf checks that the user the request acts as (usually the :userId path parameter) is the authenticated
+// user.
+func (rt *_router) authorizeSelf(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext, usrId int64) bool {
+	if usrId != ctx.UserId {
+		rt.internalError(403, model.ErrNotYourself, r, w)
+		return false
+	}
+	return true
+}
+
+// authorizeConvMember checks that the authenticated user is a participant of the conversation.
+func (rt *_router) authorizeConvMember(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext, convId int64) bool {
+	isMember, err := rt.db.IsConvMember(convId, ctx.UserId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return false
+	}
+	if !isMember {
+		rt.internalError(403, model.ErrNotConvMember, r, w)
+		return false
+	}
+	return true
+}
+
+// authorizeContact checks that the authenticated user is the user or shares a conversation with them.
+func (rt *_router) authorizeContact(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext, usrId int64) bool {
+	if usrId == ctx.UserId {
+		return true
+	}
+	shares, err := rt.db.SharesConversation(ctx.UserId, usrId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return false
+	}
+	if !shares {
+		rt.internalError(403, model.ErrNotContact, r, w)
+		return false
+	}
+	return true
+}
+
+// authorizePhoto checks that the photo was sent in one of the conversations of the authenticated user. Photos the
+// user can't see are reported as not found.
+func (rt *_router) authorizePhoto(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext, photoId int64) bool {
+	allowed, err := rt.db.CanSeePhoto(photoId, ctx.UserId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return false
+	}
+	if !allowed {
+		rt.internalError(404, model.ErrPhotoNotFound, r, w)
+		return false
+	}
+	return true
+}
+
+// authorizeGroupMember checks that the authenticated user is a member of the group.
+func (rt *_router) authorizeGroupMember(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext, groupId int64) bool {
+	isMember, err := rt.db.IsGroupMember(groupId, ctx.UserId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return false
+	}
+	if !isMember {
+		rt.internalError(403, model.ErrNotGroupMember, r, w)
+		return false
+	}
+	return true
+}
+
+// authorizeGroupCreator checks that the authenticated user is the one who created the group.
+func (rt *_router) authorizeGroupCreator(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext, groupId int64) bool {
+	creatorId, err := rt.db.GetGroupCreator(groupId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return false
+	}
+	if creatorId != ctx.UserId {
+		rt.internalError(403, model.ErrNotGroupCreator, r, w)
+		return false
+	}
+	return true
+}
+
+// authorizePin checks that the authenticated user can pin messages in the conversation: in a group its creator
+// can, in a chat both users. It must be called after authorizeConvMember.
+func (rt *_router) authorizePin(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext, convId int64) bool {
+	groupId, err := rt.db.GetGroupByConv(convId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true
+	} else if err != nil {
+		rt.internalError(500, err, r, w)
+		return false
+	}
+	return rt.authorizeGroupCreator(w, r, ctx, groupId)
+}
+
+// authorizeSender checks that the authenticated user is the one who sent the message.
+func (rt *_router) authorizeSender(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext, msgId int64, convId int64) bool {
+	senderId, err := rt.db.GetMessageSender(msgId, convId)
+	if errors.Is(err, sql.ErrNoRows) {
+		rt.internalError(404, model.ErrMessageNotFound, r, w)
+		return false
+	} else if err != nil {
+		rt.internalError(500, err, r, w)
+		return false
+	}
+	if senderId != ctx.UserId {
+		rt.internalError(403, model.ErrNotSender, r, w)
+		return false
+	}
+	return true
+}
+
+// authorizeInList checks that the authenticated user is part of the users a conversation or group is created with.
+func (rt *_router) authorizeInList(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext, usrIds []int64) bool {
+	for _, id := range usrIds {
+		if id == ctx.UserId {
+			return true
+		}
+	}
+	rt.internalError(403, model.ErrNotInUserList, r, w)
+	return false
+}
@@ -1,5 +1,15 @@
 package api
 
 func (rt *_router) Close() error {
+	rt.closeOnce.Do(func() {
+		// Ends the event streams, which are not closed by the server shutdown
+		rt.hub.Close()
+		// Waits for the message being sent or deleted, if any
+		close(rt.stopWorker)
+		<-rt.workerDone
+		// Abandons the link previews being fetched
+		rt.stopPreviews()
+		rt.previews.Wait()
+	})
 	return nil
 }
@@ -31,7 +31,7 @@ func (rt *_router) internalError(errcode int32, e error, r *http.Request, w http
 // If a conversation already exists between the users, it returns a 401 status.
 // On successful creation, it returns the new conversation ID with a 201 status.
 func (rt *_router) createConversation(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	if !rt.isAuthed(w, r, ps, ctx) {
+	if !rt.isAuthed(w, r, ps, &ctx) {
 		return
 	}
 
@@ -42,6 +42,9 @@ func (rt *_router) createConversation(w http.ResponseWriter, r *http.Request, ps
 		rt.internalError(400, err, r, w)
 		return
 	}
+	if !rt.authorizeInList(w, r, ctx, userList.UserId) {
+		return
+	}
 
 	rt.baseLogger.Info("Creating Conversation: \nUserIds:", userList.UserId)
 	convId.Value, err = rt.db.CreateConversation(userList.UserId)
@@ -56,6 +59,7 @@ func (rt *_router) createConversation(w http.ResponseWriter, r *http.Request, ps
 		rt.internalError(500, err, r, w)
 		return
 	}
+	rt.publishToConv(model.Event{Type: model.EventConversationCreated, ConversationId: convId.Value})
 
 	w.WriteHeader(201)
 	err = json.NewEncoder(w).Encode(convId)
@@ -66,7 +70,7 @@ func (rt *_router) createConversation(w http.ResponseWriter, r *http.Request, ps
 }
 func (rt *_router) getMyConversations(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	rt.baseLogger.Info("Getting conversations:")
-	if !rt.isAuthed(w, r, ps, ctx) {
+	if !rt.isAuthed(w, r, ps, &ctx) {
 		return
 	}
 	var sql_rows *sql.Rows
@@ -74,6 +78,8 @@ func (rt *_router) getMyConversations(w http.ResponseWriter, r *http.Request, ps
 	var usrId int64
 	var convs []model.ConversationPw
 	var tmp_conv model.ConversationPw
+	var lastMsgType string
+	var lastMsgPayload []byte
 
 	usrId, err = strconv.ParseInt(ps.ByName("userId"), 10, 64)
 	if err != nil {
@@ -81,6 +87,9 @@ func (rt *_router) getMyConversations(w http.ResponseWriter, r *http.Request, ps
 		rt.baseLogger.Error(err)
 		return
 	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) {
+		return
+	}
 
 	rt.baseLogger.Info("Getting Conversations:")
 	sql_rows, err = rt.db.GetConversations(usrId)
@@ -93,13 +102,18 @@ func (rt *_router) getMyConversations(w http.ResponseWriter, r *http.Request, ps
 			&tmp_conv.ConversationId,
 			&tmp_conv.LastMsgContent,
 			&tmp_conv.LastMsgTimeStamp,
+			&lastMsgType,
+			&lastMsgPayload,
 			&tmp_conv.GroupId,
 			&tmp_conv.UserId,
+			&tmp_conv.UnreadCount,
+			&tmp_conv.FirstUnreadMessageId,
 		)
 		if err != nil {
 			rt.internalError(500, err, r, w)
 			return
 		}
+		tmp_conv.LastMsgContent = messagePreview(lastMsgType, tmp_conv.LastMsgContent, lastMsgPayload)
 
 		rt.baseLogger.Info("Getting Name Of conversation")
 		tmp_conv.Name, err = rt.db.GetConvName(tmp_conv.ConversationId, usrId)
@@ -137,7 +151,7 @@ func (rt *_router) getMyConversations(w http.ResponseWriter, r *http.Request, ps
 
 func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	rt.baseLogger.Info("Getting SINGLE conversation:")
-	if !rt.isAuthed(w, r, ps, ctx) {
+	if !rt.isAuthed(w, r, ps, &ctx) {
 		return
 	}
 	var conv model.Conversation
@@ -148,31 +162,44 @@ func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps ht
 		rt.baseLogger.Error(err)
 		return
 	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, conv.Id) {
+		return
+	}
 
-	rows, err := rt.db.GetConversation(conv.Id)
-
+	page, err := parsePageQuery(r)
 	if err != nil {
+		rt.internalError(400, err, r, w)
+		return
+	}
+
+	messages, nextCursor, newerCursor, err := rt.loadConversationPage(conv.Id, usrId, page)
+	if errors.Is(err, sql.ErrNoRows) {
+		rt.internalError(404, model.ErrMessageNotFound, r, w)
+		return
+	} else if err != nil {
 		rt.internalError(500, err, r, w)
 		rt.baseLogger.Error(err)
 		return
 	}
+	conv.NextCursor = nextCursor
+	conv.NewerCursor = newerCursor
+	conv.PinnedIds, err = rt.pinnedIds(conv.Id, usrId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	conv.MessageLifetime, err = rt.db.GetMessageLifetime(conv.Id)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	rt.markDelivered(usrId, messages...)
 
-	for rows.Next() {
-		var message model.Message
-		err = rows.Scan(&message.Id,
-			&message.Content,
-			&message.Timestamp,
-			&message.Sender.UserId,
-			&message.ConvId,
-			&message.PictureId,
-			&message.RepliedId,
-			&message.RepliedConvId)
-
-		if err != nil {
-			rt.internalError(500, err, r, w)
-			return
-		}
-
+	for _, message := range messages {
 		rt.PrintNumberOfOpenConnections()
 		message.Sender.Name, err = rt.db.GetUserName(message.Sender.UserId)
 		rt.PrintNumberOfOpenConnections()
@@ -182,20 +209,19 @@ func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps ht
 		}
 
 		rt.PrintNumberOfOpenConnections()
-		message.CommentList, err = rt.db.GetFinalComment(message.Id, message.ConvId)
+		message.Reactions, err = rt.getReactionCounts(message.Id, message.ConvId, ctx.UserId)
 		rt.PrintNumberOfOpenConnections()
 
 		if err != nil {
 			rt.internalError(500, err, r, w)
 			return
 		}
+		if err = rt.loadPoll(&message, ctx.UserId); err != nil {
+			rt.internalError(500, err, r, w)
+			return
+		}
 		conv.Messages = append(conv.Messages, message)
 	}
-	defer rows.Close()
-	if rows.Err() != nil {
-		rt.internalError(500, err, r, w)
-		return
-	}
 	rt.PrintNumberOfOpenConnections()
 	user_rows, err := rt.db.GetUsersByConv(conv.Id)
 	rt.PrintNumberOfOpenConnections()
@@ -230,5 +256,4 @@ func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps ht
 		rt.internalError(500, err, r, w)
 		return
 	}
-	// row, err := rt.db.GetConversation(id)
 }
@@ -0,0 +1,246 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/mycompany8201046/myProject/service/api/events"
+	"gitlab.com/mycompany8201046/myProject/service/api/globaltime"
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+	"gitlab.com/mycompany8201046/myProject/service/api/reqcontext"
+)
+
+const (
+	// heartbeatInterval is how often a comment is sent on the event streams, so that proxies keep them open and
+	// revoked sessions are noticed
+	heartbeatInterval = 25 * time.Second
+
+	// streamWriteTimeout bounds each write on an event stream
+	streamWriteTimeout = 10 * time.Second
+
+	// reconnectDelay is the delay, in milliseconds, the clients wait before reconnecting to a closed stream
+	reconnectDelay = 3000
+)
+
+// getEvents streams the events of the user as Server-Sent Events. A client reconnecting with the Last-Event-ID header
+// (or the lastEventId query parameter) first receives what it missed; if that is no longer available it gets a
+// resync event instead, and has to reload its conversations.
+func (rt *_router) getEvents(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Opening event stream")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) {
+		return
+	}
+	// isAuthed already checked it, the heartbeat checks it again
+	token, _ := bearerToken(r)
+
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = r.URL.Query().Get("lastEventId")
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		rt.internalError(500, errors.New("streaming is not supported"), r, w)
+		return
+	}
+	stream := eventStream{w: w, flusher: flusher, conn: requestConn(r)}
+
+	sub, missed, complete := rt.hub.Subscribe(usrId, lastEventId)
+	defer sub.Close()
+	// An open stream keeps the user online
+	if rt.presence.Connect(usrId, globaltime.Now()) {
+		rt.publishPresence(usrId, true)
+	}
+	defer func() { rt.presence.Disconnect(usrId, globaltime.Now()) }()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	stream.extendDeadline()
+	w.WriteHeader(200)
+	stream.printf("retry: %d\n\n", reconnectDelay)
+
+	if !complete {
+		stream.writeEvent(events.Event{Id: sub.StartId, Type: "resync", Data: []byte(`{"type":"resync"}`)})
+	}
+	for _, event := range missed {
+		stream.writeEvent(event)
+	}
+	if err = stream.flush(); err != nil {
+		ctx.Logger.WithError(err).Info("event stream closed")
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			ctx.Logger.Info("event stream closed by the client")
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				// Either the server is shutting down or the client is too slow: it will reconnect and resume
+				return
+			}
+			stream.writeEvent(event)
+		case <-heartbeat.C:
+			_, err = rt.db.GetSessionByToken(hashSessionToken(token), globaltime.Now().Unix())
+			if err != nil {
+				ctx.Logger.WithError(err).Info("event stream session is no longer valid")
+				return
+			}
+			stream.printf(": ping\n\n")
+		}
+		if err = stream.flush(); err != nil {
+			ctx.Logger.WithError(err).Info("event stream closed")
+			return
+		}
+	}
+}
+
+// connKey is the context key of the connection a request came on.
+type connKey struct{}
+
+// ConnContext is meant to be the ConnContext of the http.Server. It lets the event streams and the file transfers move
+// the deadlines of their connection forward, as the server ReadTimeout and WriteTimeout would close them otherwise.
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, conn)
+}
+
+// requestConn returns the connection of the request, or nil if the server was not set up with ConnContext.
+func requestConn(r *http.Request) net.Conn {
+	conn, _ := r.Context().Value(connKey{}).(net.Conn)
+	return conn
+}
+
+// eventStream writes Server-Sent Events on the response. Each write gets its own deadline, instead of the server
+// WriteTimeout, when the connection is known. The first write error is kept and returned by flush.
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	conn    net.Conn
+	err     error
+}
+
+func (s *eventStream) extendDeadline() {
+	if s.conn != nil && s.err == nil {
+		s.err = s.conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	}
+}
+
+func (s *eventStream) printf(format string, a ...interface{}) {
+	if s.err != nil {
+		return
+	}
+	// The response is buffered, and the buffer may be written out by any write
+	s.extendDeadline()
+	_, s.err = fmt.Fprintf(s.w, format, a...)
+}
+
+// writeEvent buffers the event, flush sends it. The data is JSON, so it never spans more than one line.
+func (s *eventStream) writeEvent(event events.Event) {
+	if event.Id != "" {
+		s.printf("id: %s\n", event.Id)
+	}
+	s.printf("event: %s\ndata: %s\n\n", event.Type, event.Data)
+}
+
+func (s *eventStream) flush() error {
+	s.extendDeadline()
+	if s.err != nil {
+		return s.err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// publish sends the event to the streams of the recipients. Failures only concern the real-time delivery, which
+// clients can recover from by reloading, so they are logged and not returned.
+func (rt *_router) publish(event model.Event, recipients []int64) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("can't encode the event ", event.Type)
+		return
+	}
+	rt.hub.Publish(event.Type, data, recipients)
+}
+
+// signal sends the event to the streams of the recipients connected now, see events.Hub.Signal.
+func (rt *_router) signal(event model.Event, recipients []int64) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("can't encode the event ", event.Type)
+		return
+	}
+	rt.hub.Signal(event.Type, data, recipients)
+}
+
+// publishToConv sends the event to the members of its conversation and to the extra users.
+func (rt *_router) publishToConv(event model.Event, extra ...int64) {
+	rows, err := rt.db.GetUsersByConv(event.ConversationId)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("can't get the recipients of the event ", event.Type)
+		return
+	}
+	recipients, err := scanUserIds(rows)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("can't get the recipients of the event ", event.Type)
+		return
+	}
+	rt.publish(event, append(recipients, extra...))
+}
+
+// publishToGroup sends the event to the members of its group and to the extra users.
+func (rt *_router) publishToGroup(event model.Event, extra ...int64) {
+	rows, err := rt.db.GetUsersByGroup(event.GroupId)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("can't get the recipients of the event ", event.Type)
+		return
+	}
+	recipients, err := scanUserIds(rows)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("can't get the recipients of the event ", event.Type)
+		return
+	}
+	rt.publish(event, append(recipients, extra...))
+}
+
+// publishMessage sends a message.created or message.edited event, with the message as it is now.
+func (rt *_router) publishMessage(eventType string, msgId int64, convId int64) {
+	message, err := rt.loadMessage(msgId, convId)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("can't load the message of the event ", eventType)
+		return
+	}
+	rt.publishToConv(model.Event{Type: eventType, ConversationId: convId, MessageId: msgId, Message: &message})
+}
+
+// scanUserIds reads the ids from rows selected by GetUsersByConv or GetUsersByGroup, and closes them.
+func scanUserIds(rows *sql.Rows) ([]int64, error) {
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var user model.User
+		if err := rows.Scan(&user.UserId, &user.Name, &user.UserPhoto); err != nil {
+			return nil, err
+		}
+		ids = append(ids, user.UserId)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,213 @@
+/*
+Package events implements the in-process hub feeding the real-time event streams of the clients.
+
+Every published event gets an id made of the hub epoch (the time the hub was created) and a sequence number. The most
+recent events are kept in memory, so that a client reconnecting with the id of the last event it received can be sent
+what it missed. When that is not possible (the server restarted, or too many events happened meanwhile) Subscribe says
+so, and the client has to reload its state.
+*/
+package events
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// historySize is how many events are kept for resuming streams.
+const historySize = 1024
+
+// bufferSize is how many events can wait for a subscriber: a slower subscriber is disconnected.
+const bufferSize = 64
+
+// Event is a message for a set of users.
+type Event struct {
+	// Id identifies the event for resuming, e.g. with the Last-Event-ID header of Server-Sent Events
+	Id string
+
+	// Type is the kind of event, e.g. "message.created"
+	Type string
+
+	// Data is the JSON encoded payload
+	Data []byte
+
+	seq        uint64
+	recipients []int64
+}
+
+func (e Event) isFor(userId int64) bool {
+	for _, id := range e.recipients {
+		if id == userId {
+			return true
+		}
+	}
+	return false
+}
+
+// Hub dispatches the published events to the subscribers. It is safe for concurrent use.
+type Hub struct {
+	mu      sync.Mutex
+	epoch   string
+	seq     uint64
+	history []Event
+	subs    map[int64]map[*Subscription]struct{}
+	closed  bool
+}
+
+// Subscription receives the events of a user until it is closed.
+type Subscription struct {
+	// StartId is the id of the last event published before the subscription started, empty if there is none
+	StartId string
+
+	userId int64
+	hub    *Hub
+	c      chan Event
+	done   bool
+}
+
+// NewHub returns a hub with no subscribers.
+func NewHub() *Hub {
+	return &Hub{
+		epoch: strconv.FormatInt(time.Now().UnixNano(), 36),
+		subs:  make(map[int64]map[*Subscription]struct{}),
+	}
+}
+
+// Publish sends the event to the subscribers among the recipients, and keeps it for resuming.
+func (h *Hub) Publish(eventType string, data []byte, recipients []int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+
+	h.seq++
+	event := Event{
+		Id:         fmt.Sprintf("%s-%d", h.epoch, h.seq),
+		Type:       eventType,
+		Data:       data,
+		seq:        h.seq,
+		recipients: recipients,
+	}
+	if len(h.history) == historySize {
+		copy(h.history, h.history[1:])
+		h.history = h.history[:historySize-1]
+	}
+	h.history = append(h.history, event)
+	h.deliver(event, recipients)
+}
+
+// Signal sends the event to the subscribers among the recipients, without an id and without keeping it: it is lost
+// for those not connected. It suits transient states, like typing, that are stale by the time a client reconnects.
+func (h *Hub) Signal(eventType string, data []byte, recipients []int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.deliver(Event{Type: eventType, Data: data}, recipients)
+}
+
+// deliver must be called with the lock held.
+func (h *Hub) deliver(event Event, recipients []int64) {
+	for _, userId := range recipients {
+		for sub := range h.subs[userId] {
+			select {
+			case sub.c <- event:
+			default:
+				// The subscriber is not keeping up: it can reconnect and resume
+				h.unsubscribe(sub)
+			}
+		}
+	}
+}
+
+// Subscribe registers a subscriber for the events of the user. If lastEventId is set, the events for the user
+// published after it are returned, to be sent before the new ones; complete is false if some of them are no longer
+// available.
+func (h *Hub) Subscribe(userId int64, lastEventId string) (sub *Subscription, missed []Event, complete bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub = &Subscription{userId: userId, hub: h, c: make(chan Event, bufferSize)}
+	if h.seq > 0 {
+		sub.StartId = fmt.Sprintf("%s-%d", h.epoch, h.seq)
+	}
+	if h.closed {
+		sub.done = true
+		close(sub.c)
+		return sub, nil, true
+	}
+	if h.subs[userId] == nil {
+		h.subs[userId] = make(map[*Subscription]struct{})
+	}
+	h.subs[userId][sub] = struct{}{}
+
+	if lastEventId == "" {
+		return sub, nil, true
+	}
+	last, ok := h.parseId(lastEventId)
+	if !ok {
+		return sub, nil, false
+	}
+	// The next event after last must still be in the history
+	complete = last == h.seq || (len(h.history) > 0 && h.history[0].seq <= last+1)
+	for _, event := range h.history {
+		if event.seq > last && event.isFor(userId) {
+			missed = append(missed, event)
+		}
+	}
+	return sub, missed, complete
+}
+
+// parseId returns the sequence number of an event id of this hub.
+func (h *Hub) parseId(id string) (uint64, bool) {
+	prefix := h.epoch + "-"
+	if len(id) <= len(prefix) || id[:len(prefix)] != prefix {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(id[len(prefix):], 10, 64)
+	if err != nil || seq > h.seq {
+		return 0, false
+	}
+	return seq, true
+}
+
+// Close disconnects every subscriber. Nothing is published after Close.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, subs := range h.subs {
+		for sub := range subs {
+			h.unsubscribe(sub)
+		}
+	}
+}
+
+// unsubscribe must be called with the lock held.
+func (h *Hub) unsubscribe(sub *Subscription) {
+	if sub.done {
+		return
+	}
+	sub.done = true
+	close(sub.c)
+	delete(h.subs[sub.userId], sub)
+	if len(h.subs[sub.userId]) == 0 {
+		delete(h.subs, sub.userId)
+	}
+}
+
+// Events returns the channel the events are delivered on. It is closed when the subscription ends, either because
+// of Close or because the subscriber was too slow.
+func (s *Subscription) Events() <-chan Event {
+	return s.c
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.unsubscribe(s)
+}
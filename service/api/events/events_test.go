@@ -0,0 +1,149 @@
+package events
+
+import (
+	"fmt"
+	"testing"
+)
+
+// publishN publishes n events for the users, numbered from the current sequence number of the hub.
+func publishN(h *Hub, n int, recipients ...int64) {
+	for i := 0; i < n; i++ {
+		h.Publish("test", []byte(fmt.Sprintf(`{"n":%d}`, h.seq+1)), recipients)
+	}
+}
+
+func seqs(events []Event) []uint64 {
+	s := make([]uint64, 0, len(events))
+	for _, e := range events {
+		s = append(s, e.seq)
+	}
+	return s
+}
+
+func equalSeqs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSubscribeResume(t *testing.T) {
+	h := NewHub()
+	defer h.Close()
+
+	publishN(h, 1, 1, 2)
+	first := h.history[0].Id
+	publishN(h, 1, 2)
+	publishN(h, 2, 1)
+
+	sub, missed, complete := h.Subscribe(1, first)
+	defer sub.Close()
+	if !complete {
+		t.Error("resume within the history is not complete")
+	}
+	// The event for user 2 only is left out
+	if got := seqs(missed); !equalSeqs(got, []uint64{3, 4}) {
+		t.Errorf("missed events %v, want [3 4]", got)
+	}
+	if sub.StartId != h.history[3].Id {
+		t.Errorf("StartId = %q, want %q", sub.StartId, h.history[3].Id)
+	}
+
+	// Up to date
+	sub2, missed, complete := h.Subscribe(1, h.history[3].Id)
+	defer sub2.Close()
+	if !complete || len(missed) != 0 {
+		t.Errorf("resume from the last event = %v, %v, want nothing missed and complete", seqs(missed), complete)
+	}
+}
+
+func TestSubscribeResumeTrimmed(t *testing.T) {
+	h := NewHub()
+	defer h.Close()
+
+	first := fmt.Sprintf("%s-%d", h.epoch, 1)
+	second := fmt.Sprintf("%s-%d", h.epoch, 2)
+	publishN(h, historySize+2, 1)
+	if h.history[0].seq != 3 {
+		t.Fatalf("oldest event in the history is %d, want 3", h.history[0].seq)
+	}
+
+	// The event after the first one is gone: the client must resync, but gets what is left
+	sub, missed, complete := h.Subscribe(1, first)
+	defer sub.Close()
+	if complete {
+		t.Error("resume past the history is complete")
+	}
+	if len(missed) != historySize || missed[0].seq != 3 {
+		t.Errorf("missed %d events, want the %d from 3", len(missed), historySize)
+	}
+
+	// The event after the second one is the oldest kept
+	sub2, missed, complete := h.Subscribe(1, second)
+	defer sub2.Close()
+	if !complete || len(missed) != historySize {
+		t.Errorf("resume from the event before the history = %d events, %v, want %d, complete",
+			len(missed), complete, historySize)
+	}
+}
+
+func TestSubscribeForeignId(t *testing.T) {
+	h := NewHub()
+	defer h.Close()
+	publishN(h, 3, 1)
+
+	for _, id := range []string{
+		// Another epoch, e.g. before a restart
+		"0-2",
+		"x" + h.epoch + "-2",
+		// Not published yet
+		fmt.Sprintf("%s-%d", h.epoch, 4),
+		h.epoch + "-",
+		h.epoch + "-two",
+		"garbage",
+	} {
+		sub, missed, complete := h.Subscribe(1, id)
+		if complete || len(missed) != 0 {
+			t.Errorf("Subscribe(%q) = %v, %v, want nothing missed and not complete", id, seqs(missed), complete)
+		}
+		sub.Close()
+	}
+}
+
+func TestPublish(t *testing.T) {
+	h := NewHub()
+	defer h.Close()
+
+	sub, _, _ := h.Subscribe(1, "")
+	other, _, _ := h.Subscribe(2, "")
+	defer other.Close()
+
+	publishN(h, 1, 1)
+	h.Signal("typing", nil, []int64{1})
+	if e := <-sub.Events(); e.seq != 1 || e.Id == "" {
+		t.Errorf("first event %+v, want the published one", e)
+	}
+	if e := <-sub.Events(); e.Type != "typing" || e.Id != "" {
+		t.Errorf("second event %+v, want the signal without an id", e)
+	}
+	select {
+	case e := <-other.Events():
+		t.Errorf("event %+v delivered to a user not among the recipients", e)
+	default:
+	}
+
+	// A subscriber not keeping up is disconnected
+	publishN(h, bufferSize+1, 1)
+	n := 0
+	for range sub.Events() {
+		n++
+	}
+	if n != bufferSize {
+		t.Errorf("slow subscriber received %d events, want %d before being disconnected", n, bufferSize)
+	}
+}
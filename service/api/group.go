@@ -14,7 +14,7 @@ import (
 
 func (rt *_router) createGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 
-	if !rt.isAuthed(w, r, ps, ctx) {
+	if !rt.isAuthed(w, r, ps, &ctx) {
 		return
 	}
 	var group model.Group
@@ -25,15 +25,19 @@ func (rt *_router) createGroup(w http.ResponseWriter, r *http.Request, ps httpro
 		rt.internalError(500, err, r, w)
 		return
 	}
-	_, err = rt.db.CreateGroup(group.Name, group.UserId)
+	if !rt.authorizeInList(w, r, ctx, group.UserId) {
+		return
+	}
+	groupId, convId, err := rt.db.CreateGroup(group.Name, group.UserId, ctx.UserId)
 	if err != nil && !errors.Is(err, sql.ErrTxDone) {
 		rt.internalError(500, err, r, w)
 		return
 	}
+	rt.publishToGroup(model.Event{Type: model.EventGroupCreated, GroupId: groupId, ConversationId: convId})
 	w.WriteHeader(204)
 }
 func (rt *_router) getGroupInfo(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	if !rt.isAuthed(w, r, ps, ctx) {
+	if !rt.isAuthed(w, r, ps, &ctx) {
 		return
 	}
 	var groupPw model.GroupPw
@@ -43,8 +47,11 @@ func (rt *_router) getGroupInfo(w http.ResponseWriter, r *http.Request, ps httpr
 		rt.internalError(400, err, r, w)
 		return
 	}
+	if !rt.authorizeGroupMember(w, r, ctx, groupId) {
+		return
+	}
 	row := rt.db.GetGroupInfo(groupId)
-	err = row.Scan(&groupPw.Name, &groupPw.Desc, &groupPw.Pic)
+	err = row.Scan(&groupPw.Name, &groupPw.Desc, &groupPw.Pic, &groupPw.CreatorId)
 	if err != nil {
 		rt.internalError(500, err, r, w)
 		return
@@ -59,7 +66,7 @@ func (rt *_router) getGroupInfo(w http.ResponseWriter, r *http.Request, ps httpr
 }
 
 func (rt *_router) leaveGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	if !rt.isAuthed(w, r, ps, ctx) {
+	if !rt.isAuthed(w, r, ps, &ctx) {
 		return
 	}
 	groupId, err := strconv.ParseInt(ps.ByName("groupId"), 10, 64)
@@ -72,16 +79,22 @@ func (rt *_router) leaveGroup(w http.ResponseWriter, r *http.Request, ps httprou
 		rt.internalError(400, err, r, w)
 		return
 	}
-	_, err = rt.db.LeaveGroup(userId, groupId)
+	if !rt.authorizeSelf(w, r, ctx, userId) || !rt.authorizeGroupMember(w, r, ctx, groupId) {
+		return
+	}
+	convId, msgId, err := rt.db.LeaveGroup(userId, groupId)
 	if err != nil {
 		rt.internalError(500, err, r, w)
 		return
 	}
+	// The user who left is told too, so that their other devices drop the group
+	rt.publishToGroup(model.Event{Type: model.EventGroupMemberLeft, GroupId: groupId, UserId: userId}, userId)
+	rt.publishMessage(model.EventMessageCreated, msgId, convId)
 	w.WriteHeader(204)
 }
 
 func (rt *_router) getGroupUsers(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	if !rt.isAuthed(w, r, ps, ctx) {
+	if !rt.isAuthed(w, r, ps, &ctx) {
 		return
 	}
 	var userList []model.User
@@ -92,6 +105,9 @@ func (rt *_router) getGroupUsers(w http.ResponseWriter, r *http.Request, ps http
 		rt.internalError(400, err, r, w)
 		return
 	}
+	if !rt.authorizeGroupMember(w, r, ctx, groupId) {
+		return
+	}
 	rows, err := rt.db.GetUsersByGroup(groupId)
 	if err != nil {
 		rt.internalError(500, err, r, w)
@@ -127,6 +143,9 @@ func (rt *_router) getGroupUsers(w http.ResponseWriter, r *http.Request, ps http
 
 }
 func (rt *_router) addToGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
 	userId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
 	if err != nil && !errors.Is(err, sql.ErrTxDone) {
 		rt.internalError(400, err, r, w)
@@ -137,18 +156,23 @@ func (rt *_router) addToGroup(w http.ResponseWriter, r *http.Request, ps httprou
 		rt.internalError(400, err, r, w)
 		return
 	}
+	if !rt.authorizeGroupMember(w, r, ctx, groupId) {
+		return
+	}
 	var userList []int64
 	userList = append(userList, userId)
-	_, err = rt.db.AddGroup(userList, groupId)
+	convId, msgId, err := rt.db.AddGroup(userList, groupId, ctx.UserId)
 	if err != nil && !errors.Is(err, sql.ErrTxDone) {
 		rt.internalError(500, err, r, w)
 		return
 	}
+	rt.publishToGroup(model.Event{Type: model.EventGroupMemberAdded, GroupId: groupId, UserId: userId})
+	rt.publishMessage(model.EventMessageCreated, msgId, convId)
 	w.WriteHeader(204)
 }
 
 func (rt *_router) getGroupPicture(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	if !rt.isAuthed(w, r, ps, ctx) {
+	if !rt.isAuthed(w, r, ps, &ctx) {
 		return
 	}
 	var pathContainer model.Path
@@ -157,6 +181,9 @@ func (rt *_router) getGroupPicture(w http.ResponseWriter, r *http.Request, ps ht
 		rt.internalError(400, err, r, w)
 		return
 	}
+	if !rt.authorizeGroupMember(w, r, ctx, groupId) {
+		return
+	}
 	pathContainer.Path, err = rt.db.GetGroupPhoto(groupId)
 	if err != nil && !errors.Is(err, sql.ErrTxDone) {
 		rt.internalError(500, err, r, w)
@@ -169,7 +196,7 @@ func (rt *_router) getGroupPicture(w http.ResponseWriter, r *http.Request, ps ht
 	}
 }
 func (rt *_router) setGroupDesc(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	if !rt.isAuthed(w, r, ps, ctx) {
+	if !rt.isAuthed(w, r, ps, &ctx) {
 		return
 	}
 	var grp model.GroupPw
@@ -183,18 +210,26 @@ func (rt *_router) setGroupDesc(w http.ResponseWriter, r *http.Request, ps httpr
 		rt.internalError(500, err, r, w)
 		return
 	}
+	if !rt.authorizeGroupMember(w, r, ctx, groupId) {
+		return
+	}
 	rt.baseLogger.Infof("Id:%d", grp.Id)
 	rt.baseLogger.Infof("Description:%s\n", grp.Desc)
-	_, err = rt.db.SetGroupDesc(grp.Desc, groupId)
+	convId, msgId, err := rt.db.SetGroupDesc(grp.Desc, groupId, ctx.UserId)
 
 	if err != nil && !errors.Is(err, sql.ErrTxDone) {
 		rt.internalError(500, err, r, w)
 		return
 	}
+	// Nothing happened if the description is the same
+	if msgId != 0 {
+		rt.publishToGroup(model.Event{Type: model.EventGroupUpdated, GroupId: groupId})
+		rt.publishMessage(model.EventMessageCreated, msgId, convId)
+	}
 	w.WriteHeader(204)
 }
 func (rt *_router) setGroupName(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	if !rt.isAuthed(w, r, ps, ctx) {
+	if !rt.isAuthed(w, r, ps, &ctx) {
 		return
 	}
 	var grp model.GroupPw
@@ -208,12 +243,20 @@ func (rt *_router) setGroupName(w http.ResponseWriter, r *http.Request, ps httpr
 		rt.internalError(500, err, r, w)
 		return
 	}
-	_, err = rt.db.SetGroupName(grp.Name, groupId)
+	if !rt.authorizeGroupMember(w, r, ctx, groupId) {
+		return
+	}
+	convId, msgId, err := rt.db.SetGroupName(grp.Name, groupId, ctx.UserId)
 
 	if err != nil && !errors.Is(err, sql.ErrTxDone) {
 		rt.internalError(500, err, r, w)
 		return
 	}
+	// Nothing happened if the name is the same
+	if msgId != 0 {
+		rt.publishToGroup(model.Event{Type: model.EventGroupUpdated, GroupId: groupId})
+		rt.publishMessage(model.EventMessageCreated, msgId, convId)
+	}
 	w.WriteHeader(204)
 }
 func (rt *_router) setGroupPhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/mycompany8201046/myProject/service/api/globaltime"
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+	"gitlab.com/mycompany8201046/myProject/service/api/reqcontext"
+)
+
+// expiredBatch is how many expired messages the janitor deletes at most on every tick
+const expiredBatch = 100
+
+// validLifetime tells if the lifetime is one of those the users can choose.
+func validLifetime(lifetime int64) bool {
+	switch lifetime {
+	case model.LifetimeOff, model.LifetimeHour, model.LifetimeDay, model.LifetimeWeek:
+		return true
+	}
+	return false
+}
+
+// setMessageLifetime turns disappearing messages on or off for the conversation. Any participant can, and the
+// conversation gets a system message telling who did.
+func (rt *_router) setMessageLifetime(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Setting message lifetime")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	convId, err := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if rt.malformedConvIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) {
+		return
+	}
+
+	var input model.LifetimeInput
+	if err = json.NewDecoder(r.Body).Decode(&input); err != nil {
+		rt.internalError(400, err, r, w)
+		return
+	}
+	if !validLifetime(input.Lifetime) {
+		rt.internalError(400, model.ErrMalformedLifetime, r, w)
+		return
+	}
+
+	msgId, err := rt.db.SetMessageLifetime(convId, input.Lifetime, usrId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	if msgId != 0 {
+		rt.publishMessage(model.EventMessageCreated, msgId, convId)
+	}
+	w.WriteHeader(204)
+}
+
+// deleteExpired deletes the disappearing messages expired by globaltime.Now() for good: unlike the messages deleted
+// for everyone, they leave no tombstone.
+// Errors are only logged: the messages are tried again on the next run.
+func (rt *_router) deleteExpired() {
+	rows, err := rt.db.GetExpiredMessages(globaltime.Now().Unix(), expiredBatch)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("can't get the expired messages")
+		return
+	}
+	var expired []model.Message
+	for rows.Next() {
+		var message model.Message
+		if err = rows.Scan(&message.ConvId, &message.Id); err != nil {
+			break
+		}
+		expired = append(expired, message)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	_ = rows.Close()
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("can't get the expired messages")
+		return
+	}
+
+	for _, message := range expired {
+		deleted, err := rt.db.DeleteExpiredMessage(message.Id, message.ConvId, globaltime.Now().Unix())
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("can't delete the expired message ", message.Id)
+			continue
+		}
+		if deleted {
+			rt.publishToConv(model.Event{Type: model.EventMessageDeleted, ConversationId: message.ConvId, MessageId: message.Id})
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"gitlab.com/mycompany8201046/myProject/service/api/globaltime"
+	"gitlab.com/mycompany8201046/myProject/service/api/linkpreview"
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+)
+
+const (
+	// previewTTL is how long a preview is shown before the page is fetched again for the next message linking it
+	previewTTL = 24 * time.Hour
+
+	// previewRetry is how long after a failed fetch the link can be tried again
+	previewRetry = time.Hour
+
+	// maxPreviewFetches bounds the fetches running at the same time, the others wait for their turn
+	maxPreviewFetches = 4
+)
+
+// messageKey identifies a message.
+type messageKey struct{ convId, msgId int64 }
+
+// previewLink sets the first link in the message as the one to preview, and fetches its preview in the background
+// unless it is cached. The message is announced with a message.link_preview event once the preview is there: if it
+// was cached, the caller publishing the message afterwards is enough.
+func (rt *_router) previewLink(msgId int64, convId int64) {
+	if rt.previewer == nil {
+		return
+	}
+	var message model.Message
+	if err := scanMessage(rt.db.GetMessage(msgId, convId), &message); err != nil {
+		rt.baseLogger.WithError(err).Error("can't load the message to preview ", msgId)
+		return
+	}
+	url := ""
+	if message.Type != model.MessageSystem && message.Type != model.MessageDeleted {
+		url = linkpreview.FindURL(message.Content)
+	}
+	if err := rt.db.SetMessageLink(msgId, convId, url); err != nil {
+		rt.baseLogger.WithError(err).Error("can't set the link of the message ", msgId)
+		return
+	}
+	if url == "" {
+		return
+	}
+
+	fetchedAt, ok, err := rt.db.GetLinkPreviewFetchedAt(url)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		rt.baseLogger.WithError(err).Error("can't get the preview of the link ", url)
+		return
+	}
+	if err == nil {
+		age := globaltime.Now().Sub(time.Unix(fetchedAt, 0))
+		if (ok && age < previewTTL) || (!ok && age < previewRetry) {
+			return
+		}
+	}
+
+	rt.previewMu.Lock()
+	waiting, fetching := rt.fetching[url]
+	rt.fetching[url] = append(waiting, messageKey{convId: convId, msgId: msgId})
+	rt.previewMu.Unlock()
+	if !fetching {
+		rt.previews.Add(1)
+		go rt.fetchPreview(url)
+	}
+}
+
+// fetchPreview fetches the preview of the link and announces the messages waiting for it.
+func (rt *_router) fetchPreview(url string) {
+	defer rt.previews.Done()
+
+	ok := false
+	defer func() {
+		rt.previewMu.Lock()
+		waiting := rt.fetching[url]
+		delete(rt.fetching, url)
+		rt.previewMu.Unlock()
+		if ok {
+			for _, key := range waiting {
+				rt.publishMessage(model.EventLinkPreview, key.msgId, key.convId)
+			}
+		}
+	}()
+
+	select {
+	case rt.previewSlots <- struct{}{}:
+		defer func() { <-rt.previewSlots }()
+	case <-rt.previewCtx.Done():
+		return
+	}
+
+	preview, err := rt.previewer.Fetch(rt.previewCtx, url)
+	if errors.Is(rt.previewCtx.Err(), context.Canceled) {
+		// Shutting down: the link is tried again next time it is sent
+		return
+	}
+	if err != nil {
+		rt.baseLogger.WithError(err).Debug("can't preview the link ", url)
+	}
+	ok = err == nil
+	saved := model.LinkPreview{
+		Url:         url,
+		Title:       preview.Title,
+		Description: preview.Description,
+		Image:       preview.Image,
+		SiteName:    preview.SiteName,
+	}
+	if err = rt.db.SaveLinkPreview(saved, ok, globaltime.Now().Unix()); err != nil {
+		rt.baseLogger.WithError(err).Error("can't save the preview of the link ", url)
+		ok = false
+	}
+}
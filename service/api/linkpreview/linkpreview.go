@@ -0,0 +1,304 @@
+/*
+Package linkpreview fetches the title, description and image of web pages, from their Open Graph and HTML meta tags,
+to show a preview of the links sent in messages.
+
+Links come from users, so fetching them must not reach the private network of the server: the Fetcher refuses to
+connect to loopback, private, link-local and otherwise reserved addresses. The check is made on the address actually
+dialed, after name resolution and on every redirect, so neither DNS tricks nor redirects get around it. Fetches are
+bounded in time and in size.
+*/
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultTimeout  = 5 * time.Second
+	defaultMaxBytes = 512 << 10
+
+	// maxRedirects is how many redirects are followed
+	maxRedirects = 3
+
+	// maxURLLength bounds the links previewed
+	maxURLLength = 2048
+
+	maxTitleLength       = 200
+	maxDescriptionLength = 500
+	maxSiteNameLength    = 100
+)
+
+var (
+	// ErrBlockedAddress is returned for links to addresses the server must not connect to
+	ErrBlockedAddress = errors.New("linkpreview: the address is not public")
+
+	// ErrNotHTML is returned for links to something else than a web page
+	ErrNotHTML = errors.New("linkpreview: not an HTML page")
+
+	// ErrNoPreview is returned for pages with neither a title nor a description
+	ErrNoPreview = errors.New("linkpreview: nothing to preview")
+)
+
+// Preview is what a page says about itself. Image is an absolute URL, empty if the page has no image.
+type Preview struct {
+	URL         string
+	Title       string
+	Description string
+	Image       string
+	SiteName    string
+}
+
+// Options configures a Fetcher. The zero value is fine for production.
+type Options struct {
+	// Timeout bounds a whole fetch, redirects included. Defaults to 5 seconds.
+	Timeout time.Duration
+
+	// MaxBytes bounds how much of a page is read. Defaults to 512 KiB.
+	MaxBytes int64
+
+	// AllowPrivate lets the fetcher connect to any address. It is meant for tests, with a local server standing in
+	// for the remote site: never set it in production.
+	AllowPrivate bool
+}
+
+// Fetcher fetches previews. It is safe for concurrent use.
+type Fetcher struct {
+	client   *http.Client
+	maxBytes int64
+}
+
+// New returns a Fetcher configured by opts.
+func New(opts Options) *Fetcher {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = defaultMaxBytes
+	}
+
+	dialer := &net.Dialer{Timeout: opts.Timeout}
+	if !opts.AllowPrivate {
+		dialer.Control = refusePrivate
+	}
+	transport := &http.Transport{
+		// A proxy would dial in our place, defeating the address check
+		Proxy:                  nil,
+		DialContext:            dialer.DialContext,
+		TLSHandshakeTimeout:    opts.Timeout,
+		ResponseHeaderTimeout:  opts.Timeout,
+		MaxResponseHeaderBytes: 64 << 10,
+		MaxIdleConns:           10,
+		IdleConnTimeout:        30 * time.Second,
+	}
+	return &Fetcher{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   opts.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) > maxRedirects {
+					return errors.New("linkpreview: too many redirects")
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return fmt.Errorf("linkpreview: redirect to a %s link", req.URL.Scheme)
+				}
+				return nil
+			},
+		},
+		maxBytes: opts.MaxBytes,
+	}
+}
+
+// Fetch reads the preview of the page at rawURL.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (Preview, error) {
+	preview := Preview{URL: rawURL}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return preview, err
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	req.Header.Set("User-Agent", "WASAText-LinkPreview/1.0")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return preview, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return preview, fmt.Errorf("linkpreview: %s", resp.Status)
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "text/html" && mediaType != "application/xhtml+xml") {
+		return preview, ErrNotHTML
+	}
+
+	page, err := io.ReadAll(io.LimitReader(resp.Body, f.maxBytes))
+	if err != nil {
+		return preview, err
+	}
+	parsePage(&preview, string(page), resp.Request.URL)
+	if preview.Title == "" && preview.Description == "" {
+		return preview, ErrNoPreview
+	}
+	return preview, nil
+}
+
+var (
+	urlPattern   = regexp.MustCompile(`https?://[^\s<>"]+`)
+	metaPattern  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attrPattern  = regexp.MustCompile(`(?is)([a-z_:.-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	headEnd      = regexp.MustCompile(`(?i)</head\s*>`)
+)
+
+// FindURL returns the first http or https link in the content, empty if there is none. Punctuation ending a sentence
+// is not taken as part of the link.
+func FindURL(content string) string {
+	for _, match := range urlPattern.FindAllString(content, -1) {
+		match = strings.TrimRight(match, ".,;:!?'")
+		if strings.HasSuffix(match, ")") && !strings.Contains(match, "(") {
+			match = strings.TrimRight(match, ")")
+		}
+		if len(match) > maxURLLength {
+			continue
+		}
+		if u, err := url.Parse(match); err == nil && u.Hostname() != "" {
+			return match
+		}
+	}
+	return ""
+}
+
+// parsePage fills the preview from the meta tags of the page, preferring Open Graph to the plain HTML ones. Only the
+// head is looked at, when its end can be found.
+func parsePage(preview *Preview, page string, base *url.URL) {
+	if loc := headEnd.FindStringIndex(page); loc != nil {
+		page = page[:loc[0]]
+	}
+	page = strings.ToValidUTF8(page, "")
+
+	meta := make(map[string]string)
+	for _, tag := range metaPattern.FindAllString(page, -1) {
+		attrs := make(map[string]string)
+		for _, attr := range attrPattern.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(attr[1])] = attr[2] + attr[3] + attr[4]
+		}
+		key := attrs["property"]
+		if key == "" {
+			key = attrs["name"]
+		}
+		key = strings.ToLower(key)
+		if _, ok := meta[key]; key != "" && !ok {
+			meta[key] = attrs["content"]
+		}
+	}
+
+	preview.Title = clean(first(meta["og:title"], meta["twitter:title"]), maxTitleLength)
+	if preview.Title == "" {
+		if match := titlePattern.FindStringSubmatch(page); match != nil {
+			preview.Title = clean(match[1], maxTitleLength)
+		}
+	}
+	preview.Description = clean(first(meta["og:description"], meta["twitter:description"], meta["description"]), maxDescriptionLength)
+	preview.SiteName = clean(meta["og:site_name"], maxSiteNameLength)
+
+	image := strings.TrimSpace(html.UnescapeString(first(meta["og:image"], meta["og:image:url"], meta["twitter:image"])))
+	if image != "" && len(image) <= maxURLLength {
+		if u, err := base.Parse(image); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			preview.Image = u.String()
+		}
+	}
+}
+
+// first returns the first of the values that is not empty.
+func first(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// clean unescapes the text, collapses its spaces and cuts it to at most max characters.
+func clean(s string, max int) string {
+	s = strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+	if utf8.RuneCountInString(s) > max {
+		s = string([]rune(s)[:max-1]) + "…"
+	}
+	return s
+}
+
+// blockedNets are the reserved ranges not covered by the net.IP predicates used by isPublic.
+var blockedNets = parseCIDRs(
+	"0.0.0.0/8",       // "this" network
+	"100.64.0.0/10",   // carrier-grade NAT
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation
+	"192.88.99.0/24",  // 6to4 relay anycast
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"240.0.0.0/4",     // reserved, and broadcast
+	"64:ff9b::/96",    // NAT64, reaching IPv4 addresses
+	"100::/64",        // discard
+	"2001::/32",       // Teredo
+	"2001:db8::/32",   // documentation
+	"2002::/16",       // 6to4, reaching IPv4 addresses
+	"fec0::/10",       // site-local, deprecated
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+// isPublic tells if the address can be reached from the Internet.
+func isPublic(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, ipNet := range blockedNets {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// refusePrivate is the net.Dialer Control function refusing to connect to addresses that are not public. It is
+// called with the resolved address of every connection.
+func refusePrivate(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublic(ip) {
+		return ErrBlockedAddress
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testPage = `<!DOCTYPE html>
+<html><head>
+<title>Fallback title</title>
+<meta property="og:title" content="Gophers &amp; friends">
+<meta name="description" content="Everything about gophers">
+<meta property="og:image" content="/images/gopher.png">
+</head><body><meta property="og:title" content="Not in the head"></body></html>`
+
+func newTestServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/articles/gophers", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/articles/gophers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(testPage))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestFetch(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	f := New(Options{AllowPrivate: true})
+	preview, err := f.Fetch(context.Background(), server.URL+"/old")
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	want := Preview{
+		URL:         server.URL + "/old",
+		Title:       "Gophers & friends",
+		Description: "Everything about gophers",
+		// Relative to the page the redirect led to
+		Image: server.URL + "/images/gopher.png",
+	}
+	if preview != want {
+		t.Errorf("Fetch = %+v, want %+v", preview, want)
+	}
+}
+
+func TestFetchRefusesLoopback(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	_, err := New(Options{}).Fetch(context.Background(), server.URL+"/articles/gophers")
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("Fetch of %s: %v, want ErrBlockedAddress", server.URL, err)
+	}
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+	"gitlab.com/mycompany8201046/myProject/service/api/reqcontext"
+)
+
+// getMentions returns the unread messages mentioning the user in every group, the most recent first. Reading the
+// message clears the mention. The before query parameter takes the nextCursor of the previous page; the cursor of a
+// mention is its (createdAt, mentionId).
+func (rt *_router) getMentions(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Getting mentions")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) {
+		return
+	}
+	c, limit, err := parseListQuery(r)
+	if err != nil {
+		rt.internalError(400, err, r, w)
+		return
+	}
+
+	page, err := rt.loadMentions(usrId, c, limit)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	w.WriteHeader(200)
+	if err = json.NewEncoder(w).Encode(page); err != nil {
+		rt.baseLogger.Error("getMentions error:", err)
+	}
+}
+
+// loadMentions reads up to limit unread mentions of the user before the cursor.
+func (rt *_router) loadMentions(usrId int64, c cursor, limit int) (model.MentionsPage, error) {
+	page := model.MentionsPage{Messages: []model.MentionedMessage{}}
+	// One more row than needed tells if there is another page
+	rows, err := rt.db.GetMentions(usrId, c.mtime, c.msgId, limit+1)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	var mentions []cursor
+	for rows.Next() {
+		var mention cursor
+		var mentioned model.MentionedMessage
+		if err = rows.Scan(&mention.msgId, &mention.mtime, &mentioned.Message.ConvId, &mentioned.Message.Id); err != nil {
+			return page, err
+		}
+		mentioned.MentionedAt = mention.mtime
+		mentions = append(mentions, mention)
+		page.Messages = append(page.Messages, mentioned)
+	}
+	if err = rows.Err(); err != nil {
+		return page, err
+	}
+	if len(page.Messages) > limit {
+		page.Messages = page.Messages[:limit]
+		page.NextCursor = mentions[limit-1].encode()
+	}
+
+	convs := make(map[int64]convLabel)
+	for i := range page.Messages {
+		mentioned := &page.Messages[i]
+		convId := mentioned.Message.ConvId
+		mentioned.Message, err = rt.loadMessageFor(mentioned.Message.Id, convId, usrId)
+		if err != nil {
+			return page, err
+		}
+		label, err := rt.convLabelOf(convs, convId, usrId)
+		if err != nil {
+			return page, err
+		}
+		mentioned.ConversationName = label.name
+		mentioned.ConversationPhoto = label.photo
+	}
+	return page, nil
+}
@@ -6,22 +6,94 @@ import (
 	"errors"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"gitlab.com/mycompany8201046/myProject/service/api/globaltime"
 	"gitlab.com/mycompany8201046/myProject/service/api/model"
 	"gitlab.com/mycompany8201046/myProject/service/api/reqcontext"
 )
 
+// scanMessage reads a row selected by GetMessage or GetConversation into message.
+func scanMessage(row interface{ Scan(...interface{}) error }, message *model.Message) error {
+	var payload, mentions, preview []byte
+	err := row.Scan(&message.Id,
+		&message.Content,
+		&message.Timestamp,
+		&message.Sender.UserId,
+		&message.ConvId,
+		&message.PictureId,
+		&message.RepliedId,
+		&message.RepliedConvId,
+		&message.EditedAt,
+		&message.Type,
+		&payload,
+		&message.ExpiresAt,
+		&message.DeletedAt,
+		&mentions,
+		&preview)
+	message.Edited = message.EditedAt > 0
+	if message.ExpiresAt > 0 {
+		// At least a second, until the janitor deletes it
+		message.ExpiresIn = message.ExpiresAt - globaltime.Now().Unix()
+		if message.ExpiresIn < 1 {
+			message.ExpiresIn = 1
+		}
+	}
+	if len(payload) > 0 {
+		message.Payload = payload
+	}
+	message.Mentions = nil
+	if err == nil && len(mentions) > 0 {
+		err = json.Unmarshal(mentions, &message.Mentions)
+	}
+	message.LinkPreview = nil
+	if err == nil && len(preview) > 0 {
+		err = json.Unmarshal(preview, &message.LinkPreview)
+	}
+	return err
+}
+
+// loadMessage reads a message along with the name of its sender. Reactions are not loaded, as they depend on who is
+// asking.
+func (rt *_router) loadMessage(msgId int64, convId int64) (model.Message, error) {
+	var message model.Message
+	err := scanMessage(rt.db.GetMessage(msgId, convId), &message)
+	if err != nil {
+		return message, err
+	}
+	message.Sender.Name, err = rt.db.GetUserName(message.Sender.UserId)
+	return message, err
+}
+
+// loadMessageFor reads a message as seen by the user, with the reactions and the poll tallies.
+func (rt *_router) loadMessageFor(msgId int64, convId int64, usrId int64) (model.Message, error) {
+	message, err := rt.loadMessage(msgId, convId)
+	if err != nil {
+		return message, err
+	}
+	message.Reactions, err = rt.getReactionCounts(msgId, convId, usrId)
+	if err != nil {
+		return message, err
+	}
+	return message, rt.loadPoll(&message, usrId)
+}
+
 func (rt *_router) getMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	if !rt.isAuthed(w, r, ps, ctx) {
+	if !rt.isAuthed(w, r, ps, &ctx) {
 		return
 	}
 
 	var err error
-	var convId int64
+	var usrId, convId int64
 	var message model.Message
 
 	rt.baseLogger.Info("Getting Message")
+	usrId, err = strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
 	convId, err = strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
 	if err != nil {
 		rt.internalError(400, model.AddError(model.ErrMalformedConvId, err), r, w)
@@ -33,28 +105,30 @@ func (rt *_router) getMessage(w http.ResponseWriter, r *http.Request, ps httprou
 		rt.internalError(400, model.AddError(model.ErrMalformedMessageId, err), r, w)
 		return
 	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) {
+		return
+	}
 
-	row := rt.db.GetMessage(message.Id, convId)
-	err = row.Scan(&message.Id,
-		&message.Content,
-		&message.Timestamp,
-		&message.Sender.UserId,
-		&message.ConvId,
-		&message.PictureId,
-		&message.RepliedId,
-		&message.RepliedConvId)
-	if errors.Is(err, sql.ErrNoRows) {
-		w.WriteHeader(200)
-		_, e := w.Write(nil)
-		if e != nil {
-			rt.baseLogger.Error(e)
-			return
+	err = scanMessage(rt.db.GetMessage(message.Id, convId), &message)
+	if err == nil {
+		// A message hidden by the user is gone for them
+		var hidden bool
+		if hidden, err = rt.db.IsMessageHidden(ctx.UserId, convId, message.Id); hidden {
+			err = sql.ErrNoRows
 		}
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		rt.internalError(404, model.ErrMessageNotFound, r, w)
 		return
 	} else if err != nil {
 		rt.internalError(500, err, r, w)
 		return
 	}
+	if err = rt.loadPoll(&message, ctx.UserId); err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	rt.markDelivered(ctx.UserId, message)
 	w.WriteHeader(200)
 	err = json.NewEncoder(w).Encode(message)
 	if err != nil {
@@ -63,11 +137,15 @@ func (rt *_router) getMessage(w http.ResponseWriter, r *http.Request, ps httprou
 	}
 }
 
+// getMessageStatus returns when each recipient got and read the message.
 func (rt *_router) getMessageStatus(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	var err error
 	var msgId, usrId, convId int64
 	var messageReadStatus model.MessageReadStatus
 	rt.baseLogger.Info("Getting Message Status")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
 	usrId, err = strconv.ParseInt(ps.ByName("userId"), 10, 64)
 	if err != nil {
 		rt.internalError(400, model.AddError(model.ErrMalformedUserId, err), r, w)
@@ -85,22 +163,30 @@ func (rt *_router) getMessageStatus(w http.ResponseWriter, r *http.Request, ps h
 		rt.internalError(400, model.AddError(model.ErrMalformedMessageId, err), r, w)
 		return
 	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) {
+		return
+	}
 
-	messageReadStatus.HasBeenRead, err = rt.db.HasMessageBeenRead(msgId, convId, usrId)
+	// Tombstones and the messages the user hid have no status to show
+	msgType, ok := rt.messageType(w, r, msgId, convId)
+	if !ok {
+		return
+	}
+	hidden, err := rt.db.IsMessageHidden(usrId, convId, msgId)
 	if err != nil {
 		rt.internalError(500, err, r, w)
 		return
 	}
+	if hidden || msgType == model.MessageDeleted {
+		rt.internalError(404, model.ErrMessageNotFound, r, w)
+		return
+	}
 
-	/* // TODO Extra implement this to see who has read the message
-	rows, err = rt.db.WhoHasReadMessage(msgId, convId, usrId)
-	for rows.Next() {
-		err = rows.Scan()
-		if err != nil {
-			rt.internalError(500, err, r, w)
-			return
-		}
-	}*/
+	messageReadStatus, err = rt.loadReceipts(msgId, convId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
 
 	w.WriteHeader(200)
 	err = json.NewEncoder(w).Encode(messageReadStatus)
@@ -116,6 +202,9 @@ func (rt *_router) readMessage(w http.ResponseWriter, r *http.Request, ps httpro
 	var msgId, usrId, convId int64
 
 	rt.baseLogger.Info("Reading Message")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
 	usrId, err = strconv.ParseInt(ps.ByName("userId"), 10, 64)
 	if rt.malformedUserIdReq(err, w, r) {
 		return
@@ -129,11 +218,52 @@ func (rt *_router) readMessage(w http.ResponseWriter, r *http.Request, ps httpro
 	if rt.malformedMessageIdReq(err, w, r) {
 		return
 	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) {
+		return
+	}
 	err = rt.db.ReadMessage(msgId, convId, usrId)
 	if err != nil {
 		rt.internalError(500, err, r, w)
 		return
 	}
+	rt.publishToConv(model.Event{Type: model.EventMessageRead, ConversationId: convId, MessageId: msgId, UserId: usrId})
+	w.WriteHeader(204)
+}
+
+// markConversationRead marks every message of the conversation up to the given one as read, and moves the read marker
+// of the user there. Moving the marker backwards does nothing.
+func (rt *_router) markConversationRead(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Reading Conversation")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	convId, err := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if rt.malformedConvIdReq(err, w, r) {
+		return
+	}
+	msgId, err := strconv.ParseInt(ps.ByName("messageId"), 10, 64)
+	if rt.malformedMessageIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) {
+		return
+	}
+	moved, err := rt.db.MarkConversationRead(convId, usrId, msgId, globaltime.Now().Unix())
+	if errors.Is(err, sql.ErrNoRows) {
+		rt.internalError(404, model.ErrMessageNotFound, r, w)
+		return
+	}
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	if moved {
+		rt.publishToConv(model.Event{Type: model.EventConversationRead, ConversationId: convId, MessageId: msgId, UserId: usrId})
+	}
 	w.WriteHeader(204)
 }
 
@@ -144,6 +274,9 @@ func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httpro
 	var msgId model.MessageId
 
 	rt.baseLogger.Info("Sending Message")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
 	usrId, err = strconv.ParseInt(ps.ByName("userId"), 10, 64)
 	if rt.malformedUserIdReq(err, w, r) {
 		return
@@ -153,17 +286,17 @@ func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httpro
 	if rt.malformedConvIdReq(err, w, r) {
 		return
 	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) {
+		return
+	}
 
 	err = json.NewDecoder(r.Body).Decode(&msgInput)
 	if err != nil {
 		rt.internalError(500, err, r, w)
 		return
 	}
-	if len(msgInput.Content) > 1000 {
-		e := errors.New("the message cannot be bigger than a 1000 chars")
-		rt.internalError(400, e, r, w)
+	if !rt.validMessage(w, r, convId, &msgInput) {
 		return
-
 	}
 
 	msgId.Value, err = rt.db.CreateMessage(msgInput, 0, usrId, convId)
@@ -171,6 +304,9 @@ func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httpro
 		rt.internalError(500, err, r, w)
 		return
 	}
+	rt.typingDone(convId, usrId)
+	rt.previewLink(msgId.Value, convId)
+	rt.publishMessage(model.EventMessageCreated, msgId.Value, convId)
 	err = json.NewEncoder(w).Encode(msgId)
 	if err != nil {
 		rt.internalError(500, err, r, w)
@@ -180,53 +316,74 @@ func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httpro
 
 }
 
-func (rt *_router) commentMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+func (rt *_router) deleteMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	var err error
-	var usrId, convId, messageId int64
-	var comment model.Comment
+	var usrId, convId, msgId int64
 
-	rt.baseLogger.Info("Commenting Message")
+	rt.baseLogger.Info("Deleting Message")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
 	usrId, err = strconv.ParseInt(ps.ByName("userId"), 10, 64)
 	if rt.malformedUserIdReq(err, w, r) {
 		return
 	}
-
 	convId, err = strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
 	if rt.malformedConvIdReq(err, w, r) {
 		return
 	}
-	messageId, err = strconv.ParseInt(ps.ByName("messageId"), 10, 64)
+	msgId, err = strconv.ParseInt(ps.ByName("messageId"), 10, 64)
 	if rt.malformedMessageIdReq(err, w, r) {
 		return
 	}
-	err = json.NewDecoder(r.Body).Decode(&comment)
-	if err != nil {
-		rt.internalError(500, err, r, w)
-		return
-	}
-	if len(comment.Content) > 20 {
-		e := errors.New("the message cannot be bigger then  20 chars")
-		rt.internalError(400, e, r, w)
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) {
 		return
 	}
 
-	comment.Id, err = rt.db.CommentMessage(messageId, convId, usrId, comment.Content)
-	if err != nil {
-		rt.internalError(500, err, r, w)
-		return
-	}
-	if err != nil {
-		rt.internalError(500, err, r, w)
+	switch r.URL.Query().Get("for") {
+	case "", "everyone":
+		// Only the sender can, and the message is left as a tombstone so that the history keeps its shape
+		if !rt.authorizeSender(w, r, ctx, msgId, convId) || !rt.activeMessage(w, r, msgId, convId) {
+			return
+		}
+		deleted, err := rt.db.DeleteMessage(msgId, convId, globaltime.Now().Unix())
+		if err != nil {
+			rt.internalError(500, err, r, w)
+			return
+		}
+		if deleted {
+			rt.publishToConv(model.Event{Type: model.EventMessageDeleted, ConversationId: convId, MessageId: msgId})
+		}
+	case "me":
+		// Any participant can hide any message from themselves, the others do not know
+		if _, ok := rt.messageType(w, r, msgId, convId); !ok {
+			return
+		}
+		hidden, err := rt.db.HideMessage(usrId, convId, msgId, globaltime.Now().Unix())
+		if err != nil {
+			rt.internalError(500, err, r, w)
+			return
+		}
+		if hidden {
+			rt.publish(model.Event{Type: model.EventMessageHidden, ConversationId: convId, MessageId: msgId, UserId: usrId}, []int64{usrId})
+		}
+	default:
+		rt.internalError(400, model.ErrMalformedDeleteMode, r, w)
 		return
 	}
 	w.WriteHeader(204)
 }
 
-func (rt *_router) uncommentMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+
 	var err error
-	var usrId, convId, messageId int64
+	var usrId, convId, msgId int64
+	var body model.MsgForward
 
-	rt.baseLogger.Info("Uncommenting Message")
+	rt.baseLogger.Info("Forwarding message")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
 	usrId, err = strconv.ParseInt(ps.ByName("userId"), 10, 64)
 	if rt.malformedUserIdReq(err, w, r) {
 		return
@@ -236,55 +393,157 @@ func (rt *_router) uncommentMessage(w http.ResponseWriter, r *http.Request, ps h
 	if rt.malformedConvIdReq(err, w, r) {
 		return
 	}
-	messageId, err = strconv.ParseInt(ps.ByName("messageId"), 10, 64)
+	msgId, err = strconv.ParseInt(ps.ByName("messageId"), 10, 64)
 	if rt.malformedMessageIdReq(err, w, r) {
 		return
 	}
-	err = rt.db.RemoveComment(usrId, convId, messageId)
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) {
+		return
+	}
+	// A negative id means "start a new chat with user -forwardTo", otherwise the target must be one of our chats
+	if body.ConvId > 0 && !rt.authorizeConvMember(w, r, ctx, body.ConvId) {
+		return
+	}
+	// A forwarded poll would start from scratch, and may end up outside of a group
+	msgType, ok := rt.messageType(w, r, msgId, convId)
+	if !ok {
+		return
+	} else if msgType == model.MessageSystem {
+		rt.internalError(400, model.ErrSystemMessage, r, w)
+		return
+	} else if msgType == model.MessageDeleted {
+		rt.internalError(400, model.ErrMessageDeleted, r, w)
+		return
+	} else if msgType == model.MessagePoll {
+		rt.internalError(400, model.ErrPollNotForwardable, r, w)
+		return
+	}
+	newConvId, newMsgId, err := rt.db.ForwardMessage(msgId, convId, usrId, body.ConvId)
 	if err != nil {
 		rt.internalError(500, err, r, w)
 		return
 	}
-
+	if body.ConvId < 0 {
+		rt.publishToConv(model.Event{Type: model.EventConversationCreated, ConversationId: newConvId})
+	}
+	rt.previewLink(newMsgId, newConvId)
+	rt.publishMessage(model.EventMessageCreated, newMsgId, newConvId)
 	w.WriteHeader(204)
+
 }
 
-func (rt *_router) deleteMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+func (rt *_router) sendPhotoMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Sending photo message")
+	rt.uploadPhotoHandler(w, r, ps, ctx, 2)
+
+}
+
+// editMessage replaces the content of a message. Only the sender can edit it, and only within the edit window after
+// it was sent. The previous content is kept in the message history.
+func (rt *_router) editMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	var err error
-	var convId, msgId int64
+	var usrId, convId, msgId int64
+	var input model.MessageEditInput
+	var message model.Message
 
-	rt.baseLogger.Info("Deleting Message")
+	rt.baseLogger.Info("Editing Message")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err = strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
 	convId, err = strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if rt.malformedConvIdReq(err, w, r) {
+		return
+	}
+	msgId, err = strconv.ParseInt(ps.ByName("messageId"), 10, 64)
+	if rt.malformedMessageIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) || !rt.authorizeSender(w, r, ctx, msgId, convId) {
+		return
+	}
 
+	err = json.NewDecoder(r.Body).Decode(&input)
 	if err != nil {
+		rt.internalError(400, err, r, w)
+		return
+	}
+	if len(input.Content) > maxContentLength {
+		rt.internalError(400, model.ErrMessageTooLong, r, w)
+		return
+	}
+
+	err = scanMessage(rt.db.GetMessage(msgId, convId), &message)
+	if errors.Is(err, sql.ErrNoRows) {
+		rt.internalError(404, model.ErrMessageNotFound, r, w)
+		return
+	} else if err != nil {
 		rt.internalError(500, err, r, w)
 		return
 	}
-	msgId, err = strconv.ParseInt(ps.ByName("messageId"), 10, 64)
-	if rt.malformedMessageIdReq(err, w, r) {
+	// The caption of a photo or a file can be removed, the text of a message cannot
+	if message.Type != model.MessageText && message.Type != model.MessagePhoto && message.Type != model.MessageFile {
+		rt.internalError(400, model.ErrNotEditable, r, w)
+		return
+	}
+	if message.Type == model.MessageText && strings.TrimSpace(input.Content) == "" {
+		rt.internalError(400, model.ErrEmptyMessage, r, w)
 		return
 	}
-	_, err = rt.db.DeleteMessage(msgId, convId)
+
+	now := globaltime.Now()
+	if now.Sub(time.Unix(message.Timestamp, 0)) > rt.editWindow {
+		rt.internalError(403, model.ErrEditWindowExpired, r, w)
+		return
+	}
+
+	// Saving the same content again would only add a useless revision
+	if input.Content != message.Content {
+		err = rt.db.EditMessage(msgId, convId, input.Content, now.Unix())
+		if err != nil {
+			rt.internalError(500, err, r, w)
+			return
+		}
+		rt.previewLink(msgId, convId)
+		rt.publishMessage(model.EventMessageEdited, msgId, convId)
+	}
+
+	// The message as the conversation shows it, with the mentions and the link of the new content
+	message, err = rt.loadMessageFor(msgId, convId, ctx.UserId)
 	if err != nil {
 		rt.internalError(500, err, r, w)
 		return
 	}
-	w.WriteHeader(204)
 
+	w.WriteHeader(200)
+	err = json.NewEncoder(w).Encode(message)
+	if err != nil {
+		rt.baseLogger.Error("editMessage error:", err)
+		return
+	}
 }
 
-func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-
+// getMessageHistory returns the previous versions of a message, oldest first. The current content is not included.
+func (rt *_router) getMessageHistory(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	var err error
 	var usrId, convId, msgId int64
-	var body model.MsgForward
 
-	rt.baseLogger.Info("Forwarding message")
+	rt.baseLogger.Info("Getting Message history")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
 	usrId, err = strconv.ParseInt(ps.ByName("userId"), 10, 64)
 	if rt.malformedUserIdReq(err, w, r) {
 		return
 	}
-
 	convId, err = strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
 	if rt.malformedConvIdReq(err, w, r) {
 		return
@@ -293,22 +552,45 @@ func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps htt
 	if rt.malformedMessageIdReq(err, w, r) {
 		return
 	}
-	err = json.NewDecoder(r.Body).Decode(&body)
-	if err != nil {
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) {
+		return
+	}
+
+	_, err = rt.db.GetMessageSender(msgId, convId)
+	if errors.Is(err, sql.ErrNoRows) {
+		rt.internalError(404, model.ErrMessageNotFound, r, w)
+		return
+	} else if err != nil {
 		rt.internalError(500, err, r, w)
 		return
 	}
-	_, err = rt.db.ForwardMessage(msgId, convId, usrId, body.ConvId)
+
+	rows, err := rt.db.GetMessageEdits(msgId, convId)
 	if err != nil {
 		rt.internalError(500, err, r, w)
 		return
 	}
-	w.WriteHeader(204)
+	defer rows.Close()
 
-}
-
-func (rt *_router) sendPhotoMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	rt.baseLogger.Info("Sending photo message")
-	rt.uploadPhotoHandler(w, r, ps, ctx, 2)
+	edits := []model.MessageEdit{}
+	for rows.Next() {
+		var edit model.MessageEdit
+		err = rows.Scan(&edit.Content, &edit.WrittenAt, &edit.ReplacedAt)
+		if err != nil {
+			rt.internalError(500, err, r, w)
+			return
+		}
+		edits = append(edits, edit)
+	}
+	if err = rows.Err(); err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
 
+	w.WriteHeader(200)
+	err = json.NewEncoder(w).Encode(edits)
+	if err != nil {
+		rt.baseLogger.Error("getMessageHistory error:", err)
+		return
+	}
 }
@@ -0,0 +1,95 @@
+package model
+
+// Message types. Every type but text has a payload, with the structure of the matching *Payload type.
+const (
+	MessageText     = "text"
+	MessagePhoto    = "photo"
+	MessageFile     = "file"
+	MessageLocation = "location"
+	MessageContact  = "contact"
+	MessagePoll     = "poll"
+	MessageSystem   = "system"
+	// MessageDeleted is the tombstone of a message deleted for everyone, with no content nor payload
+	MessageDeleted = "deleted"
+)
+
+type PhotoPayload struct {
+	PhotoId int64 `json:"photoId"`
+}
+
+// FilePayload describes the attachment of a file message, downloaded from /files/{fileId}.
+type FilePayload struct {
+	FileId   int64  `json:"fileId"`
+	Name     string `json:"name"`
+	MimeType string `json:"mimeType"`
+	Size     int64  `json:"size"`
+}
+
+type LocationPayload struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	// Label is an optional name for the place, e.g. "Home"
+	Label string `json:"label,omitempty"`
+}
+
+// ContactPayload shares a user. Name is the name of the user when the card was sent.
+type ContactPayload struct {
+	UserId int64  `json:"userId"`
+	Name   string `json:"name"`
+}
+
+// PollPayload is a poll as it was created. What changes afterwards is in PollResults.
+type PollPayload struct {
+	Question       string   `json:"question"`
+	Options        []string `json:"options"`
+	MultipleChoice bool     `json:"multipleChoice"`
+	// Anonymous polls do not tell who voted for what
+	Anonymous bool `json:"anonymous"`
+	// ClosesAt is when the poll stops accepting votes, zero if it stays open until it is closed
+	ClosesAt int64 `json:"closesAt,omitempty"`
+}
+
+// PollResults are the live tallies of a poll, as seen by the user asking.
+type PollResults struct {
+	Options []PollOptionResult `json:"options"`
+	// Voters is how many users voted
+	Voters int `json:"voters"`
+	// MyVotes are the options the user asking voted for
+	MyVotes []int `json:"myVotes"`
+	Closed  bool  `json:"closed"`
+	// ClosedAt is when the poll was closed, or its close time once passed; zero while it is open
+	ClosedAt int64 `json:"closedAt"`
+}
+
+type PollOptionResult struct {
+	Text  string `json:"text"`
+	Votes int    `json:"votes"`
+	// VoterIds are who voted for the option, in the order they voted; anonymous polls do not have them
+	VoterIds []int64 `json:"voterIds,omitempty"`
+}
+
+// PollVoteInput replaces the vote of the user; no options withdraws it.
+type PollVoteInput struct {
+	Options []int `json:"options"`
+}
+
+// Actions of the system messages
+const (
+	SystemMemberAdded        = "member_added"
+	SystemMemberLeft         = "member_left"
+	SystemGroupRenamed       = "group_renamed"
+	SystemDescriptionChanged = "description_changed"
+	SystemPhotoChanged       = "photo_changed"
+	SystemLifetimeChanged    = "lifetime_changed"
+)
+
+// SystemPayload describes something that happened in the conversation, e.g. a user joining a group. ActorId is who
+// did it and UserIds who it concerns; OldValue and NewValue are set when a property of the group other than its photo
+// changed. The content of the message holds the text to show.
+type SystemPayload struct {
+	Action   string  `json:"action"`
+	ActorId  int64   `json:"actorId"`
+	UserIds  []int64 `json:"userIds,omitempty"`
+	OldValue string  `json:"oldValue,omitempty"`
+	NewValue string  `json:"newValue,omitempty"`
+}
@@ -1,12 +1,68 @@
 package model
 
-import "errors"
+import (
+	"encoding/json"
+	"errors"
+)
 
 var ErrMalformedUserId = errors.New("userId is not correct")
 var ErrMalformedConvId = errors.New("conversationId is not correct")
 var ErrMalformedMessageId = errors.New("messageId is not correct")
 var ErrMalformedGroupId = errors.New("groupId is not correct")
 var ErrMalformedPhotoId = errors.New("photoId is not correct")
+var ErrInvalidSession = errors.New("the session is invalid or expired")
+var ErrNotYourself = errors.New("you can only act as yourself")
+var ErrNotConvMember = errors.New("you are not a participant of this conversation")
+var ErrNotGroupMember = errors.New("you are not a member of this group")
+var ErrNotSender = errors.New("only the sender can do this on the message")
+var ErrNotInUserList = errors.New("you must be one of the users")
+var ErrNotContact = errors.New("you share no conversation with this user")
+var ErrPhotoNotFound = errors.New("photo not found")
+var ErrMessageNotFound = errors.New("message not found")
+var ErrInvalidCredentials = errors.New("invalid username or password")
+var ErrWrongPassword = errors.New("the old password is wrong")
+var ErrPasswordNotSet = errors.New("this account has no password yet: log in from a device where you are still logged in, or ask for a reset token")
+var ErrInvalidResetToken = errors.New("the reset token is invalid or expired")
+var ErrUsernameTaken = errors.New("the username is already taken")
+var ErrMalformedUserName = errors.New("the username must be between 1 and 20 characters")
+var ErrMalformedSessionId = errors.New("sessionId is not correct")
+var ErrSessionNotFound = errors.New("session not found")
+var ErrEditWindowExpired = errors.New("the message can no longer be edited")
+var ErrMessageTooLong = errors.New("the message cannot be bigger than a 1000 chars")
+var ErrEmptyMessage = errors.New("the message cannot be empty")
+var ErrInvalidEmoji = errors.New("a reaction must be a single emoji")
+var ErrTooManyReactions = errors.New("too many different reactions on the message")
+var ErrReactionNotFound = errors.New("reaction not found")
+var ErrMalformedCursor = errors.New("the cursor is not correct")
+var ErrMalformedLimit = errors.New("limit is not correct")
+var ErrConflictingCursors = errors.New("only one of before, after and around can be used")
+var ErrMalformedSearch = errors.New("the search text must be between 1 and 100 characters")
+var ErrTooManyRequests = errors.New("too many requests, retry later")
+var ErrMalformedSince = errors.New("since is not correct")
+var ErrUnknownMessageType = errors.New("unknown message type")
+var ErrTypeNotSendable = errors.New("messages of this type cannot be sent directly")
+var ErrMalformedPayload = errors.New("the payload does not match the message type")
+var ErrContactNotFound = errors.New("the shared contact does not exist")
+var ErrNotEditable = errors.New("only text, photo and file messages can be edited")
+var ErrPollNotFound = errors.New("poll not found")
+var ErrNotGroupConv = errors.New("polls can only be sent in groups")
+var ErrPollNotForwardable = errors.New("polls cannot be forwarded")
+var ErrNotGroupCreator = errors.New("only the creator of the group can do this")
+var ErrPinNotFound = errors.New("the message is not pinned")
+var ErrStarNotFound = errors.New("the message is not starred")
+var ErrMalformedScheduledId = errors.New("scheduledId is not correct")
+var ErrMalformedSendAt = errors.New("sendAt must be in the future, and at most a year from now")
+var ErrScheduledNotFound = errors.New("scheduled message not found")
+var ErrMalformedLifetime = errors.New("the lifetime must be 0, 3600, 86400 or 604800 seconds")
+var ErrSystemMessage = errors.New("system messages cannot be deleted, forwarded, pinned, replied or reacted to")
+var ErrMessageDeleted = errors.New("the message was deleted")
+var ErrMalformedDeleteMode = errors.New("for must be me or everyone")
+var ErrMalformedFileId = errors.New("fileId is not correct")
+var ErrFileNotFound = errors.New("file not found")
+var ErrMissingFile = errors.New("the file is missing")
+var ErrFileTooLarge = errors.New("the file is too large")
+var ErrTooManyFiles = errors.New("only one file can be sent per message")
+var ErrFileTypeNotAllowed = errors.New("files of this type cannot be sent")
 
 type ConversationPw struct {
 	Name string `json:"name"`
@@ -17,6 +73,10 @@ type ConversationPw struct {
 	GroupId          int64  `json:"groupId"`
 	UserId           int64  `json:"userId"`
 	Photo            string `json:"photo"`
+	// UnreadCount is the number of messages the user has not read yet
+	UnreadCount int64 `json:"unreadCount"`
+	// FirstUnreadMessageId is the oldest of them, zero if there are none
+	FirstUnreadMessageId int64 `json:"firstUnreadMessageId"`
 }
 type CustomError struct {
 	Code    int32  `json:"code"`
@@ -30,32 +90,127 @@ type Picture struct {
 	Size uint32 `json:"size"`
 }
 
+// Attachment is a file sent in a message. Path is where it is stored, never shown to the clients.
+type Attachment struct {
+	Id        int64
+	Name      string
+	MimeType  string
+	Size      int64
+	Path      string
+	CreatedAt int64
+}
+
 type User struct {
 	UserId    int64  `json:"id"`
 	Name      string `json:"name"`
 	UserPhoto string `json:"photo"`
 }
+type Credentials struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	// DeviceName is an optional label the client gives to the new session, e.g. "Office laptop"
+	DeviceName string `json:"deviceName"`
+}
+type PasswordChange struct {
+	OldPassword string `json:"oldPassword"`
+	NewPassword string `json:"newPassword"`
+}
+type PasswordReset struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+type Session struct {
+	Id         int64  `json:"id"`
+	UserId     int64  `json:"userId"`
+	DeviceName string `json:"deviceName"`
+	UserAgent  string `json:"userAgent"`
+	IPAddress  string `json:"ipAddress"`
+	CreatedAt  int64  `json:"createdAt"`
+	LastUsedAt int64  `json:"lastUsedAt"`
+	ExpiresAt  int64  `json:"expiresAt"`
+	// Current is true for the session the listing was requested with
+	Current bool `json:"current"`
+}
+
+// UserSession is returned on login: the user plus the opaque token to send as "Authorization: Bearer <token>"
+type UserSession struct {
+	User
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
 type UserIdList struct {
 	UserId []int64 `json:"userIdList"`
 }
-type Comment struct {
-	Id             int64  `json:"id"`
-	Content        string `json:"content"`
-	MessageId      int64  `json:"messageId"`
-	ConversationId int64  `json:"conversationId"`
-	UserName       string `json:"userName"`
-	UserId         int64  `json:"userId"`
+
+// Reaction aggregates the users who reacted to a message with the same emoji
+type Reaction struct {
+	Emoji string `json:"emoji"`
+	Count int64  `json:"count"`
+	// Reacted is true if the user asking reacted with this emoji
+	Reacted bool `json:"reacted"`
+	// UserIds lists who reacted; it is only filled when listing the reactions of a single message
+	UserIds []int64 `json:"userIds,omitempty"`
+}
+type ReactionInput struct {
+	Emoji string `json:"emoji"`
 }
 type Message struct {
-	Id            int64     `json:"id"`
-	Sender        User      `json:"sender"`
-	ConvId        int64     `json:"conversationId"`
-	CommentList   []Comment `json:"commentList"`
-	Content       string    `json:"content"`
-	Timestamp     int64     `json:"timestamp"`
-	PictureId     int64     `json:"pictureId"`
-	RepliedId     int64     `json:"repliedId"`
-	RepliedConvId int64     `json:"repliedConvId"`
+	Id            int64      `json:"id"`
+	Sender        User       `json:"sender"`
+	ConvId        int64      `json:"conversationId"`
+	Reactions     []Reaction `json:"reactions"`
+	Content       string     `json:"content"`
+	Timestamp     int64      `json:"timestamp"`
+	PictureId     int64      `json:"pictureId"`
+	RepliedId     int64      `json:"repliedId"`
+	RepliedConvId int64      `json:"repliedConvId"`
+	Edited        bool       `json:"edited"`
+	// EditedAt is the time of the last edit, zero if the message has never been edited
+	EditedAt int64 `json:"editedAt"`
+	// Type tells how to read Payload, e.g. "location"; Content is the text, or the caption of the other types
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// Poll is set for polls, when the message is loaded for a user
+	Poll *PollResults `json:"poll,omitempty"`
+	// ExpiresAt is when a disappearing message is deleted, and ExpiresIn how many seconds are left until then; both
+	// are zero for the other messages
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+	ExpiresIn int64 `json:"expiresIn,omitempty"`
+	// DeletedAt is when the sender deleted the message for everyone, leaving only this tombstone
+	DeletedAt int64 `json:"deletedAt,omitempty"`
+	// Mentions are the users the content mentions, in order
+	Mentions []Mention `json:"mentions,omitempty"`
+	// LinkPreview describes the first link in the content, once it has been fetched
+	LinkPreview *LinkPreview `json:"linkPreview,omitempty"`
+}
+
+// LinkPreview is what the page a message links to says about itself. Image is the URL of a picture on the linked
+// site, not on this server.
+type LinkPreview struct {
+	Url         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	SiteName    string `json:"siteName,omitempty"`
+}
+
+// Mention is a span of the content of a message mentioning a user, or everybody in the group with @all. Offset and
+// Length are counted in UTF-16 code units, as JavaScript counts the length of strings.
+type Mention struct {
+	UserId int64 `json:"userId,omitempty"`
+	All    bool  `json:"all,omitempty"`
+	Offset int   `json:"offset"`
+	Length int   `json:"length"`
+}
+
+// MessageEdit is a previous version of a message, valid from WrittenAt until it was replaced at ReplacedAt
+type MessageEdit struct {
+	Content    string `json:"content"`
+	WrittenAt  int64  `json:"writtenAt"`
+	ReplacedAt int64  `json:"replacedAt"`
+}
+type MessageEditInput struct {
+	Content string `json:"content"`
 }
 
 type MessageReadStatus struct {
@@ -63,6 +218,19 @@ type MessageReadStatus struct {
 	ReadByUsers   []int64 `json:"readByUsers"`
 	UnreadByUsers []int64 `json:"unreadByUsers"`
 	MessageId     int64   `json:"messageId"`
+	// Recipients are the members of the conversation but the sender, readers first
+	Recipients     []Receipt `json:"recipients"`
+	DeliveredCount int       `json:"deliveredCount"`
+	ReadCount      int       `json:"readCount"`
+	// Summary is a short description for the user, e.g. "Read by 4 of 7"
+	Summary string `json:"summary"`
+}
+
+// Receipt tells when a recipient got and read a message. The times are zero until it happens.
+type Receipt struct {
+	User        User  `json:"user"`
+	DeliveredAt int64 `json:"deliveredAt"`
+	ReadAt      int64 `json:"readAt"`
 }
 type MessageInput struct {
 	Sender        User   `json:"sender"`
@@ -70,7 +238,36 @@ type MessageInput struct {
 	Content       string `json:"content"`
 	RepliedId     int64  `json:"repliedId"`
 	RepliedConvId int64  `json:"repliedConvId"`
+	// Type defaults to text
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// ScheduledMessageInput is a message to be sent at SendAt, a unix time in seconds
+type ScheduledMessageInput struct {
+	MessageInput
+	SendAt int64 `json:"sendAt"`
 }
+
+// ScheduledMessage is a message waiting to be sent
+type ScheduledMessage struct {
+	Id               int64           `json:"id"`
+	ConversationId   int64           `json:"conversationId"`
+	ConversationName string          `json:"conversationName"`
+	SendAt           int64           `json:"sendAt"`
+	CreatedAt        int64           `json:"createdAt"`
+	Content          string          `json:"content"`
+	Type             string          `json:"type"`
+	Payload          json.RawMessage `json:"payload,omitempty"`
+	RepliedId        int64           `json:"repliedId"`
+	RepliedConvId    int64           `json:"repliedConvId"`
+}
+
+// RescheduleInput moves a scheduled message to SendAt
+type RescheduleInput struct {
+	SendAt int64 `json:"sendAt"`
+}
+
 type MsgForward struct {
 	ConvId int64 `json:"forwardTo"`
 }
@@ -83,11 +280,167 @@ type Conversation struct {
 	Id       int64     `json:"id"`
 	Users    []User    `json:"participants"`
 	Messages []Message `json:"messages"`
+	// NextCursor is passed as "before" to get older messages, it is empty when there are none
+	NextCursor string `json:"nextCursor,omitempty"`
+	// NewerCursor is passed as "after" to get newer messages, it is empty when there are none
+	NewerCursor string `json:"newerCursor,omitempty"`
+	// PinnedIds are the pinned messages, the most recently pinned first
+	PinnedIds []int64 `json:"pinnedIds"`
+	// MessageLifetime is how many seconds the new messages last, zero if they do not disappear
+	MessageLifetime int64 `json:"messageLifetime"`
+}
+
+// Lifetimes of disappearing messages, in seconds
+const (
+	LifetimeOff  = 0
+	LifetimeHour = 60 * 60
+	LifetimeDay  = 24 * LifetimeHour
+	LifetimeWeek = 7 * LifetimeDay
+)
+
+// LifetimeInput sets how many seconds the new messages of a conversation last, one of the Lifetime* values
+type LifetimeInput struct {
+	Lifetime int64 `json:"lifetime"`
+}
+
+// PinnedMessage is a pinned message, with who pinned it and when
+type PinnedMessage struct {
+	Message  Message `json:"message"`
+	PinnedBy User    `json:"pinnedBy"`
+	PinnedAt int64   `json:"pinnedAt"`
+}
+
+// StarredMessage is a message starred by the user, with the conversation it belongs to
+type StarredMessage struct {
+	Message           Message `json:"message"`
+	ConversationName  string  `json:"conversationName"`
+	ConversationPhoto string  `json:"conversationPhoto"`
+	StarredAt         int64   `json:"starredAt"`
+}
+
+// StarredPage is a page of starred messages, the most recently starred first. NextCursor is passed as "before" to get
+// the next page, it is empty on the last one.
+type StarredPage struct {
+	Messages   []StarredMessage `json:"messages"`
+	NextCursor string           `json:"nextCursor,omitempty"`
+}
+
+// MentionedMessage is an unread message mentioning the user, with the conversation it belongs to
+type MentionedMessage struct {
+	Message           Message `json:"message"`
+	ConversationName  string  `json:"conversationName"`
+	ConversationPhoto string  `json:"conversationPhoto"`
+	MentionedAt       int64   `json:"mentionedAt"`
+}
+
+// MentionsPage is a page of unread mentions, the most recent first. NextCursor is passed as "before" to get the next
+// page, and is empty on the last one.
+type MentionsPage struct {
+	Messages   []MentionedMessage `json:"messages"`
+	NextCursor string             `json:"nextCursor,omitempty"`
+}
+
+// Presence tells if a user is online. LastSeen is the last time they were, unless they are now or they hide it.
+type Presence struct {
+	UserId   int64 `json:"userId"`
+	Online   bool  `json:"online"`
+	LastSeen int64 `json:"lastSeen,omitempty"`
+}
+
+// ConversationPresence is the presence of the other participants of a conversation, and who of them is typing
+type ConversationPresence struct {
+	Participants []Presence `json:"participants"`
+	Typing       []int64    `json:"typing"`
+}
+
+type PrivacySettings struct {
+	ShowLastSeen bool `json:"showLastSeen"`
+}
+
+// SearchHit is a message matching a search. Snippet is HTML escaped, with the matched words wrapped in <mark>.
+type SearchHit struct {
+	ConversationId   int64  `json:"conversationId"`
+	ConversationName string `json:"conversationName"`
+	MessageId        int64  `json:"messageId"`
+	SenderId         int64  `json:"senderId"`
+	Timestamp        int64  `json:"timestamp"`
+	Snippet          string `json:"snippet"`
 }
 type ConversationId struct {
 	Value int64 `json:"convId"`
 }
 
+// Event types sent on the events stream
+const (
+	EventMessageCreated      = "message.created"
+	EventMessageEdited       = "message.edited"
+	EventMessageDeleted      = "message.deleted"
+	EventMessageRead         = "message.read"
+	EventMessageDelivered    = "message.delivered"
+	EventReactionAdded       = "reaction.added"
+	EventReactionRemoved     = "reaction.removed"
+	EventPollUpdated         = "poll.updated"
+	EventMessagePinned       = "message.pinned"
+	EventMessageUnpinned     = "message.unpinned"
+	EventMessageStarred      = "message.starred"
+	EventMessageUnstarred    = "message.unstarred"
+	EventMessageHidden       = "message.hidden"
+	EventLinkPreview         = "message.link_preview"
+	EventConversationCreated = "conversation.created"
+	EventConversationRead    = "conversation.read"
+	EventGroupCreated        = "group.created"
+	EventGroupUpdated        = "group.updated"
+	EventGroupMemberAdded    = "group.member_added"
+	EventGroupMemberLeft     = "group.member_left"
+	// The typing and presence events are signals: they have no id and are not resent on reconnection
+	EventTypingStarted   = "typing.started"
+	EventTypingStopped   = "typing.stopped"
+	EventPresenceOnline  = "presence.online"
+	EventPresenceOffline = "presence.offline"
+)
+
+// Change types returned by delta sync, besides the message, reaction and group.updated event types
+const (
+	ChangeMemberAdded = "conversation.member_added"
+	ChangeMemberLeft  = "conversation.member_left"
+	ChangeUserUpdated = "user.updated"
+)
+
+// Event is the payload of an event on the events stream. Only the fields relevant to Type are set.
+type Event struct {
+	Type           string   `json:"type"`
+	ConversationId int64    `json:"conversationId,omitempty"`
+	GroupId        int64    `json:"groupId,omitempty"`
+	MessageId      int64    `json:"messageId,omitempty"`
+	UserId         int64    `json:"userId,omitempty"`
+	Message        *Message `json:"message,omitempty"`
+	Emoji          string   `json:"emoji,omitempty"`
+	LastSeen       int64    `json:"lastSeen,omitempty"`
+}
+
+// Change is an entry of the change log of a user. UserId is the user who made the change, or the subject of it for
+// membership and profile changes. Message is the current version of created and edited messages, unless they have
+// been deleted since.
+type Change struct {
+	Seq            int64    `json:"seq"`
+	Type           string   `json:"type"`
+	ConversationId int64    `json:"conversationId,omitempty"`
+	GroupId        int64    `json:"groupId,omitempty"`
+	MessageId      int64    `json:"messageId,omitempty"`
+	UserId         int64    `json:"userId,omitempty"`
+	Emoji          string   `json:"emoji,omitempty"`
+	Timestamp      int64    `json:"timestamp"`
+	Message        *Message `json:"message,omitempty"`
+}
+
+// Sync is the answer to a delta sync. When Resync is set Changes is empty: the client has to reload everything, then
+// sync from Cursor.
+type Sync struct {
+	Cursor  int64    `json:"cursor"`
+	Resync  bool     `json:"resync"`
+	Changes []Change `json:"changes"`
+}
+
 /*
 	type Param struct{
 		Key
@@ -104,6 +457,9 @@ type GroupPw struct {
 	Pic  string `json:"picture"`
 	Desc string `json:"desc"`
 	Name string `json:"name"`
+	// CreatorId is the member who can pin messages, zero if not known. When they leave, the member who joined first
+	// takes their place
+	CreatorId int64 `json:"creatorId"`
 }
 type Path struct {
 	Path string `json:"path"`
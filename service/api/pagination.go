@@ -0,0 +1,214 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// cursor is a position in the history of a conversation. Messages are ordered by (mtime, messageId), so the cursor
+// stays valid even when messages around it are deleted.
+type cursor struct {
+	mtime int64
+	msgId int64
+}
+
+// latest is a cursor after every message.
+var latest = cursor{mtime: math.MaxInt64, msgId: math.MaxInt64}
+
+func cursorOf(message model.Message) cursor {
+	return cursor{mtime: message.Timestamp, msgId: message.Id}
+}
+
+// encode returns the opaque string sent to clients.
+func (c cursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d.%d", c.mtime, c.msgId)))
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, model.ErrMalformedCursor
+	}
+	if _, err = fmt.Sscanf(string(raw), "%d.%d", &c.mtime, &c.msgId); err != nil {
+		return c, model.ErrMalformedCursor
+	}
+	return c, nil
+}
+
+// pageQuery holds the pagination parameters of a conversation request. At most one of before, after and around is
+// set; with none of them the latest messages are returned.
+type pageQuery struct {
+	before *cursor
+	after  *cursor
+	around int64
+	limit  int
+}
+
+// parsePageQuery reads the before, after, around and limit query parameters.
+func parsePageQuery(r *http.Request) (pageQuery, error) {
+	q := pageQuery{limit: defaultPageSize}
+	values := r.URL.Query()
+
+	modes := 0
+	if s := values.Get("before"); s != "" {
+		c, err := decodeCursor(s)
+		if err != nil {
+			return q, err
+		}
+		q.before = &c
+		modes++
+	}
+	if s := values.Get("after"); s != "" {
+		c, err := decodeCursor(s)
+		if err != nil {
+			return q, err
+		}
+		q.after = &c
+		modes++
+	}
+	if s := values.Get("around"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id <= 0 {
+			return q, model.ErrMalformedMessageId
+		}
+		q.around = id
+		modes++
+	}
+	if modes > 1 {
+		return q, model.ErrConflictingCursors
+	}
+
+	if s := values.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return q, model.ErrMalformedLimit
+		}
+		q.limit = limit
+	}
+	return q, nil
+}
+
+// parseListQuery reads the before cursor and the limit of a list newest first, like the starred messages.
+func parseListQuery(r *http.Request) (cursor, int, error) {
+	c := latest
+	if s := r.URL.Query().Get("before"); s != "" {
+		var err error
+		if c, err = decodeCursor(s); err != nil {
+			return c, 0, err
+		}
+	}
+	limit := defaultPageSize
+	if s := r.URL.Query().Get("limit"); s != "" {
+		var err error
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return c, 0, model.ErrMalformedLimit
+		}
+	}
+	return c, limit, nil
+}
+
+// loadMessages reads up to limit messages before (older) or after the cursor, always in chronological order, and
+// reports whether there are more messages past them. The messages the user hid are skipped.
+func (rt *_router) loadMessages(convId int64, usrId int64, c cursor, older bool, limit int) ([]model.Message, bool, error) {
+	var messages []model.Message
+	var rows *sql.Rows
+	var err error
+	// One more row than needed tells if there is another page
+	if older {
+		rows, err = rt.db.GetMessagesBefore(convId, usrId, c.mtime, c.msgId, limit+1)
+	} else {
+		rows, err = rt.db.GetMessagesAfter(convId, usrId, c.mtime, c.msgId, limit+1)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var message model.Message
+		if err = scanMessage(rows, &message); err != nil {
+			return nil, false, err
+		}
+		messages = append(messages, message)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	more := len(messages) > limit
+	if more {
+		messages = messages[:limit]
+	}
+	if older {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	return messages, more, nil
+}
+
+// loadConversationPage returns the page of messages selected by q, in chronological order, with the cursors to
+// continue towards older (nextCursor) and newer (newerCursor) messages. They are empty when there is nothing more in
+// that direction. If the message to center the page around does not exist, or the user hid it, sql.ErrNoRows is
+// returned.
+func (rt *_router) loadConversationPage(convId int64, usrId int64, q pageQuery) (messages []model.Message, nextCursor string, newerCursor string, err error) {
+	var hasOlder, hasNewer bool
+	switch {
+	case q.after != nil:
+		messages, hasNewer, err = rt.loadMessages(convId, usrId, *q.after, false, q.limit)
+		if err == nil && len(messages) > 0 {
+			_, hasOlder, err = rt.loadMessages(convId, usrId, cursorOf(messages[0]), true, 0)
+		}
+	case q.around > 0:
+		var center model.Message
+		if err = scanMessage(rt.db.GetMessage(q.around, convId), &center); err != nil {
+			return nil, "", "", err
+		}
+		var hidden bool
+		if hidden, err = rt.db.IsMessageHidden(usrId, convId, q.around); err != nil {
+			return nil, "", "", err
+		} else if hidden {
+			return nil, "", "", sql.ErrNoRows
+		}
+		var newer []model.Message
+		messages, hasOlder, err = rt.loadMessages(convId, usrId, cursorOf(center), true, q.limit/2)
+		if err == nil {
+			// Starting right before the centre includes it in the newer half
+			newer, hasNewer, err = rt.loadMessages(convId, usrId, cursor{mtime: center.Timestamp, msgId: center.Id - 1}, false, q.limit-q.limit/2)
+			messages = append(messages, newer...)
+		}
+	default:
+		c := latest
+		if q.before != nil {
+			c = *q.before
+		}
+		messages, hasOlder, err = rt.loadMessages(convId, usrId, c, true, q.limit)
+		if err == nil && q.before != nil && len(messages) > 0 {
+			_, hasNewer, err = rt.loadMessages(convId, usrId, cursorOf(messages[len(messages)-1]), false, 0)
+		}
+	}
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	if hasOlder {
+		nextCursor = cursorOf(messages[0]).encode()
+	}
+	if hasNewer {
+		newerCursor = cursorOf(messages[len(messages)-1]).encode()
+	}
+	return messages, nextCursor, newerCursor, nil
+}
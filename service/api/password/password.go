@@ -0,0 +1,103 @@
+/*
+Package password hashes and verifies user passwords with scrypt (RFC 7914), a memory-hard key derivation function.
+
+Hashes are encoded as a self-describing string, so the cost parameters can be raised later without invalidating the
+passwords already stored:
+
+	$scrypt$ln=15,r=8,p=1$<base64 salt>$<base64 key>
+*/
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// logN is the CPU/memory cost: N = 2^15 with r = 8 uses 32MiB per hash
+	logN    = 15
+	r       = 8
+	p       = 1
+	saltLen = 16
+	keyLen  = 32
+
+	// MinLength and MaxLength bound the accepted password length, in bytes
+	MinLength = 8
+	MaxLength = 128
+)
+
+// dummyHash is verified against when the user does not exist, so that the response time does not reveal which
+// usernames are registered.
+const dummyHash = "$scrypt$ln=15,r=8,p=1$+oygfluntaZJ76OR/uAszA$cgGBstPSqrfp4jLBiGe+Y8DAWKcWBy4n3iRxLG9xm0U"
+
+var ErrMalformedHash = errors.New("the password hash is malformed")
+var ErrTooShort = fmt.Errorf("the password must be at least %d characters long", MinLength)
+var ErrTooLong = fmt.Errorf("the password cannot be longer than %d characters", MaxLength)
+
+// Validate checks the password policy.
+func Validate(password string) error {
+	if len(password) < MinLength {
+		return ErrTooShort
+	}
+	if len(password) > MaxLength {
+		return ErrTooLong
+	}
+	return nil
+}
+
+// Hash derives the encoded hash of the password using a fresh random salt.
+func Hash(password string) (string, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(password), salt, 1<<logN, r, p, keyLen)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", logN, r, p,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify reports whether the password matches the encoded hash.
+func Verify(password string, encoded string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[0] != "" || parts[1] != "scrypt" {
+		return false, ErrMalformedHash
+	}
+
+	var ln, hr, hp int
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &ln, &hr, &hp); err != nil {
+		return false, ErrMalformedHash
+	}
+	if ln < 1 || ln > 24 || hr < 1 || hp < 1 || hr*hp >= 1<<30 {
+		return false, ErrMalformedHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false, ErrMalformedHash
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(want) == 0 {
+		return false, ErrMalformedHash
+	}
+
+	got, err := scrypt.Key([]byte(password), salt, 1<<ln, hr, hp, len(want))
+	if err != nil {
+		return false, ErrMalformedHash
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1, nil
+}
+
+// VerifyDummy spends the same time as Verify, without anything real to compare against.
+func VerifyDummy(password string) {
+	_, _ = Verify(password, dummyHash)
+}
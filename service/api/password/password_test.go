@@ -0,0 +1,55 @@
+package password
+
+import "testing"
+
+// The test vectors of RFC 7914, section 12, written as encoded hashes.
+var rfc7914Vectors = []struct {
+	password string
+	encoded  string
+}{
+	{
+		password: "password",
+		encoded:  "$scrypt$ln=10,r=8,p=16$TmFDbA$/bq+HJ00cgB4VucZDQHp/nxq18vII3gw53N2Y0s3MWIurzDZLiKjiG/xCSedmDDaxyevuUqD7m2DYMvfoswGQA",
+	},
+	{
+		password: "pleaseletmein",
+		encoded:  "$scrypt$ln=14,r=8,p=1$U29kaXVtQ2hsb3JpZGU$cCO9yzr9c0hGHAbNgf046/2o+7qQT44+qbVD9lRdofLVQylVYT8Pz2LUlwUkKpr55h6F3A1lHkDfzwF7RVdYhw",
+	},
+}
+
+func TestVerifyRFC7914(t *testing.T) {
+	for _, v := range rfc7914Vectors {
+		ok, err := Verify(v.password, v.encoded)
+		if err != nil {
+			t.Fatalf("Verify(%q): %v", v.password, err)
+		}
+		if !ok {
+			t.Errorf("Verify(%q) = false, want true", v.password)
+		}
+		if ok, _ = Verify(v.password+"x", v.encoded); ok {
+			t.Errorf("Verify(%q) = true for a wrong password", v.password)
+		}
+	}
+}
+
+func TestHashVerify(t *testing.T) {
+	encoded, err := Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := Verify("correct horse", encoded); err != nil || !ok {
+		t.Errorf("Verify of the hashed password = %v, %v; want true, nil", ok, err)
+	}
+	if ok, _ := Verify("wrong horse", encoded); ok {
+		t.Error("Verify of another password = true, want false")
+	}
+	if _, err = Verify("x", "$scrypt$ln=15$salt$key"); err != ErrMalformedHash {
+		t.Errorf("Verify of a malformed hash: %v, want ErrMalformedHash", err)
+	}
+}
+
+func TestDummyHash(t *testing.T) {
+	if _, err := Verify("password", dummyHash); err != nil {
+		t.Errorf("the dummy hash is malformed: %v", err)
+	}
+}
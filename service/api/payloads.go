@@ -0,0 +1,181 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"gitlab.com/mycompany8201046/myProject/service/api/globaltime"
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+)
+
+const (
+	// maxContentLength bounds the text of a message, or its caption
+	maxContentLength = 1000
+
+	// maxLabelLength bounds the label of a location
+	maxLabelLength = 100
+)
+
+// validMessage checks a message sent with sendMessage in the conversation, writing the error if it is not valid. The
+// payload is decoded strictly and encoded again, so that only the known fields are stored. Photos, files and system
+// messages have their own ways of being created.
+func (rt *_router) validMessage(w http.ResponseWriter, r *http.Request, convId int64, input *model.MessageInput) bool {
+	if len(input.Content) > maxContentLength {
+		rt.internalError(400, model.ErrMessageTooLong, r, w)
+		return false
+	}
+	if input.Type == "" {
+		input.Type = model.MessageText
+	}
+	if input.RepliedId > 0 && input.RepliedConvId > 0 && !rt.activeMessage(w, r, input.RepliedId, input.RepliedConvId) {
+		return false
+	}
+
+	var payload interface{}
+	switch input.Type {
+	case model.MessageText:
+		if strings.TrimSpace(input.Content) == "" {
+			rt.internalError(400, model.ErrEmptyMessage, r, w)
+			return false
+		}
+		if len(input.Payload) > 0 && string(input.Payload) != "null" {
+			rt.internalError(400, model.ErrMalformedPayload, r, w)
+			return false
+		}
+		input.Payload = nil
+		return true
+	case model.MessageLocation:
+		var location model.LocationPayload
+		if !decodePayload(input.Payload, &location) || location.Latitude < -90 || location.Latitude > 90 ||
+			location.Longitude < -180 || location.Longitude > 180 || len(location.Label) > maxLabelLength {
+			rt.internalError(400, model.ErrMalformedPayload, r, w)
+			return false
+		}
+		payload = location
+	case model.MessageContact:
+		var contact model.ContactPayload
+		if !decodePayload(input.Payload, &contact) || contact.UserId <= 0 {
+			rt.internalError(400, model.ErrMalformedPayload, r, w)
+			return false
+		}
+		// The name is the one the user has now, not whatever the sender wrote
+		name, err := rt.db.GetUserName(contact.UserId)
+		if errors.Is(err, sql.ErrNoRows) {
+			rt.internalError(400, model.ErrContactNotFound, r, w)
+			return false
+		} else if err != nil {
+			rt.internalError(500, err, r, w)
+			return false
+		}
+		contact.Name = name
+		payload = contact
+	case model.MessagePoll:
+		var poll model.PollPayload
+		if !decodePayload(input.Payload, &poll) || !validPoll(&poll, globaltime.Now().Unix()) {
+			rt.internalError(400, model.ErrMalformedPayload, r, w)
+			return false
+		}
+		_, err := rt.db.GetGroupByConv(convId)
+		if errors.Is(err, sql.ErrNoRows) {
+			rt.internalError(400, model.ErrNotGroupConv, r, w)
+			return false
+		} else if err != nil {
+			rt.internalError(500, err, r, w)
+			return false
+		}
+		payload = poll
+	case model.MessagePhoto, model.MessageFile, model.MessageSystem:
+		rt.internalError(400, model.ErrTypeNotSendable, r, w)
+		return false
+	default:
+		rt.internalError(400, model.ErrUnknownMessageType, r, w)
+		return false
+	}
+
+	var err error
+	input.Payload, err = json.Marshal(payload)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return false
+	}
+	return true
+}
+
+// activeMessage writes an error if the message is a system message, a tombstone or does not exist. System messages
+// are records of what happened: they cannot be deleted, forwarded, pinned, replied or reacted to. Neither can the
+// messages deleted for everyone.
+func (rt *_router) activeMessage(w http.ResponseWriter, r *http.Request, msgId int64, convId int64) bool {
+	msgType, ok := rt.messageType(w, r, msgId, convId)
+	if ok && msgType == model.MessageSystem {
+		rt.internalError(400, model.ErrSystemMessage, r, w)
+		return false
+	} else if ok && msgType == model.MessageDeleted {
+		rt.internalError(400, model.ErrMessageDeleted, r, w)
+		return false
+	}
+	return ok
+}
+
+// messageType returns the type of the message, writing the error if it cannot.
+func (rt *_router) messageType(w http.ResponseWriter, r *http.Request, msgId int64, convId int64) (string, bool) {
+	msgType, err := rt.db.GetMessageType(msgId, convId)
+	if errors.Is(err, sql.ErrNoRows) {
+		rt.internalError(404, model.ErrMessageNotFound, r, w)
+		return "", false
+	} else if err != nil {
+		rt.internalError(500, err, r, w)
+		return "", false
+	}
+	return msgType, true
+}
+
+// decodePayload decodes the payload into v, refusing unknown fields.
+func decodePayload(payload json.RawMessage, v interface{}) bool {
+	if len(payload) == 0 {
+		return false
+	}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v) == nil && !decoder.More()
+}
+
+// messagePreview is the short text showing a message in the list of conversations: an icon for the type, followed
+// by the caption if there is one.
+func messagePreview(msgType string, content string, payload []byte) string {
+	var icon, label string
+	switch msgType {
+	case model.MessagePhoto:
+		icon, label = "📷", "Photo"
+	case model.MessageFile:
+		var file model.FilePayload
+		_ = json.Unmarshal(payload, &file)
+		icon, label = "📎", file.Name
+	case model.MessageLocation:
+		var location model.LocationPayload
+		_ = json.Unmarshal(payload, &location)
+		icon, label = "📍", "Location"
+		if location.Label != "" {
+			label = location.Label
+		}
+	case model.MessageContact:
+		var contact model.ContactPayload
+		_ = json.Unmarshal(payload, &contact)
+		icon, label = "👤", contact.Name
+	case model.MessagePoll:
+		var poll model.PollPayload
+		_ = json.Unmarshal(payload, &poll)
+		return "📊 " + poll.Question
+	case model.MessageDeleted:
+		return "🚫 This message was deleted"
+	default:
+		return content
+	}
+	if content != "" {
+		label = content
+	}
+	return icon + " " + label
+}
@@ -97,7 +97,7 @@ func (rt *_router) SaveImageOnDisk(newPhoto image.Image) (string, string, error)
 }
 
 func (rt *_router) uploadPhotoHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext, choice uint8) {
-	if !rt.isAuthed(w, r, ps, ctx) {
+	if !rt.isAuthed(w, r, ps, &ctx) {
 		return
 	}
 	var err error
@@ -117,6 +117,9 @@ func (rt *_router) uploadPhotoHandler(w http.ResponseWriter, r *http.Request, ps
 			rt.internalError(400, model.AddError(model.ErrMalformedUserId, err), r, w)
 			return
 		}
+		if !rt.authorizeSelf(w, r, ctx, userId) {
+			return
+		}
 
 	case 1:
 		grpId, err = strconv.ParseInt(ps.ByName("groupId"), 10, 64)
@@ -124,6 +127,9 @@ func (rt *_router) uploadPhotoHandler(w http.ResponseWriter, r *http.Request, ps
 			rt.internalError(400, model.AddError(model.ErrMalformedGroupId, err), r, w)
 			return
 		}
+		if !rt.authorizeGroupMember(w, r, ctx, grpId) {
+			return
+		}
 
 	case 2:
 		rt.baseLogger.Println("Message Photo chosen.")
@@ -140,6 +146,9 @@ func (rt *_router) uploadPhotoHandler(w http.ResponseWriter, r *http.Request, ps
 			rt.internalError(400, model.AddError(model.ErrMalformedConvId, err), r, w)
 			return
 		}
+		if !rt.authorizeSelf(w, r, ctx, userId) || !rt.authorizeConvMember(w, r, ctx, conversationId) {
+			return
+		}
 
 	default:
 		rt.internalError(500, errors.New("something went wrong PHOTO"), r, w)
@@ -183,7 +192,12 @@ func (rt *_router) uploadPhotoHandler(w http.ResponseWriter, r *http.Request, ps
 	if userId != 0 && choice != 2 {
 		_, err = rt.db.SetUserPhoto(picture, userId)
 	} else if grpId != 0 && choice != 2 {
-		_, err = rt.db.SetGroupPhoto(picture, grpId)
+		var convId, msgId int64
+		convId, msgId, err = rt.db.SetGroupPhoto(picture, grpId, ctx.UserId)
+		if err == nil {
+			rt.publishToGroup(model.Event{Type: model.EventGroupUpdated, GroupId: grpId})
+			rt.publishMessage(model.EventMessageCreated, msgId, convId)
+		}
 	}
 	if choice == 2 {
 		picture.Id, err = rt.db.InsertPhoto(picture)
@@ -238,9 +252,18 @@ func (rt *_router) uploadPhotoHandler(w http.ResponseWriter, r *http.Request, ps
 			}
 		}
 		msgInput.RepliedConvId = repliedConvId
+		if repliedId > 0 && repliedConvId > 0 && !rt.activeMessage(w, r, repliedId, repliedConvId) {
+			return
+		}
 		rt.baseLogger.Println("Creating a photo message with photoId:", picture.Id)
 		rt.baseLogger.Info("MessageInput:", msgInput)
-		err = rt.db.PhotoMessage(picture, 0, msgInput, conversationId, userId)
+		var msgId int64
+		msgId, err = rt.db.PhotoMessage(picture, 0, msgInput, conversationId, userId)
+		if err == nil {
+			rt.typingDone(conversationId, userId)
+			rt.previewLink(msgId, conversationId)
+			rt.publishMessage(model.EventMessageCreated, msgId, conversationId)
+		}
 	}
 
 	if err != nil {
@@ -256,7 +279,7 @@ func (rt *_router) uploadPhotoHandler(w http.ResponseWriter, r *http.Request, ps
 	}
 }
 func (rt *_router) getPhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	if !rt.isAuthed(w, r, ps, ctx) {
+	if !rt.isAuthed(w, r, ps, &ctx) {
 		return
 	}
 
@@ -265,6 +288,9 @@ func (rt *_router) getPhoto(w http.ResponseWriter, r *http.Request, ps httproute
 		rt.internalError(400, model.AddError(model.ErrMalformedPhotoId, err), r, w)
 		return
 	}
+	if !rt.authorizePhoto(w, r, ctx, photoId) {
+		return
+	}
 
 	path, err := rt.db.GetPhoto(photoId)
 	if err != nil {
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/mycompany8201046/myProject/service/api/globaltime"
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+	"gitlab.com/mycompany8201046/myProject/service/api/reqcontext"
+	"gitlab.com/mycompany8201046/myProject/service/database"
+)
+
+// maxPinsPerConversation bounds the pinned messages of a conversation
+const maxPinsPerConversation = 3
+
+// loadPins returns the pinned messages of the conversation, the most recently pinned first.
+func (rt *_router) loadPins(convId int64, usrId int64) ([]model.PinnedMessage, error) {
+	pins := []model.PinnedMessage{}
+	rows, err := rt.db.GetPins(convId, usrId)
+	if err != nil {
+		return pins, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pin model.PinnedMessage
+		if err = rows.Scan(&pin.Message.Id, &pin.PinnedBy.UserId, &pin.PinnedBy.Name, &pin.PinnedAt); err != nil {
+			return pins, err
+		}
+		pins = append(pins, pin)
+	}
+	if err = rows.Err(); err != nil {
+		return pins, err
+	}
+
+	for i := range pins {
+		pins[i].Message, err = rt.loadMessageFor(pins[i].Message.Id, convId, usrId)
+		if err != nil {
+			return pins, err
+		}
+	}
+	return pins, nil
+}
+
+// pinnedIds returns the ids of the pinned messages of the conversation the user has not hidden, the most recently
+// pinned first.
+func (rt *_router) pinnedIds(convId int64, usrId int64) ([]int64, error) {
+	ids := []int64{}
+	rows, err := rt.db.GetPins(convId, usrId)
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, pinnedBy, pinnedAt int64
+		var pinnedByName string
+		if err = rows.Scan(&id, &pinnedBy, &pinnedByName, &pinnedAt); err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// pinMessage pins a message in the conversation. In a chat both users can, in a group only its creator.
+func (rt *_router) pinMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Pinning message")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	convId, err := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if rt.malformedConvIdReq(err, w, r) {
+		return
+	}
+	msgId, err := strconv.ParseInt(ps.ByName("messageId"), 10, 64)
+	if rt.malformedMessageIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) || !rt.authorizePin(w, r, ctx, convId) {
+		return
+	}
+	if !rt.activeMessage(w, r, msgId, convId) {
+		return
+	}
+
+	pinned, err := rt.db.PinMessage(convId, msgId, usrId, maxPinsPerConversation, globaltime.Now().Unix())
+	if errors.Is(err, database.ErrTooManyPins) {
+		rt.internalError(409, err, r, w)
+		return
+	} else if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	if pinned {
+		rt.publishToConv(model.Event{Type: model.EventMessagePinned, ConversationId: convId, MessageId: msgId, UserId: usrId})
+	}
+	w.WriteHeader(204)
+}
+
+// unpinMessage unpins a message. Whoever can pin messages can unpin any of them.
+func (rt *_router) unpinMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Unpinning message")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	convId, err := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if rt.malformedConvIdReq(err, w, r) {
+		return
+	}
+	msgId, err := strconv.ParseInt(ps.ByName("messageId"), 10, 64)
+	if rt.malformedMessageIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) || !rt.authorizePin(w, r, ctx, convId) {
+		return
+	}
+
+	n, err := rt.db.UnpinMessage(convId, msgId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	if n == 0 {
+		rt.internalError(404, model.ErrPinNotFound, r, w)
+		return
+	}
+	rt.publishToConv(model.Event{Type: model.EventMessageUnpinned, ConversationId: convId, MessageId: msgId, UserId: usrId})
+	w.WriteHeader(204)
+}
+
+// getPins returns the pinned messages of the conversation with who pinned them.
+func (rt *_router) getPins(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Getting pinned messages")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	convId, err := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if rt.malformedConvIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) {
+		return
+	}
+
+	pins, err := rt.loadPins(convId, usrId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	w.WriteHeader(200)
+	if err = json.NewEncoder(w).Encode(pins); err != nil {
+		rt.baseLogger.Error("getPins error:", err)
+	}
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/mycompany8201046/myProject/service/api/globaltime"
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+	"gitlab.com/mycompany8201046/myProject/service/api/reqcontext"
+	"gitlab.com/mycompany8201046/myProject/service/database"
+)
+
+const (
+	minPollOptions    = 2
+	maxPollOptions    = 10
+	maxQuestionLength = 300
+	maxOptionLength   = 100
+)
+
+// validPoll checks the payload of a new poll, trimming the question and the options.
+func validPoll(poll *model.PollPayload, now int64) bool {
+	poll.Question = strings.TrimSpace(poll.Question)
+	if poll.Question == "" || len(poll.Question) > maxQuestionLength {
+		return false
+	}
+	if len(poll.Options) < minPollOptions || len(poll.Options) > maxPollOptions {
+		return false
+	}
+	seen := make(map[string]bool, len(poll.Options))
+	for i, option := range poll.Options {
+		option = strings.TrimSpace(option)
+		if option == "" || len(option) > maxOptionLength || seen[option] {
+			return false
+		}
+		seen[option] = true
+		poll.Options[i] = option
+	}
+	return poll.ClosesAt == 0 || poll.ClosesAt > now
+}
+
+// loadPoll sets the tallies of a poll message, as seen by the user. Other messages are left as they are.
+func (rt *_router) loadPoll(message *model.Message, usrId int64) error {
+	if message.Type != model.MessagePoll {
+		return nil
+	}
+	var poll model.PollPayload
+	if err := json.Unmarshal(message.Payload, &poll); err != nil {
+		return err
+	}
+	var closesAt, closedAt int64
+	if err := rt.db.GetPoll(message.Id, message.ConvId).Scan(&closesAt, &closedAt); err != nil {
+		return err
+	}
+	if closedAt == 0 && closesAt != 0 && globaltime.Now().Unix() >= closesAt {
+		closedAt = closesAt
+	}
+
+	results := model.PollResults{
+		Options:  make([]model.PollOptionResult, len(poll.Options)),
+		MyVotes:  []int{},
+		Closed:   closedAt != 0,
+		ClosedAt: closedAt,
+	}
+	for i, option := range poll.Options {
+		results.Options[i].Text = option
+	}
+
+	rows, err := rt.db.GetPollVotes(message.Id, message.ConvId)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	voters := make(map[int64]bool)
+	for rows.Next() {
+		var userId int64
+		var option int
+		if err = rows.Scan(&userId, &option); err != nil {
+			return err
+		}
+		if option < 0 || option >= len(results.Options) {
+			continue
+		}
+		results.Options[option].Votes++
+		if !poll.Anonymous {
+			results.Options[option].VoterIds = append(results.Options[option].VoterIds, userId)
+		}
+		if userId == usrId {
+			results.MyVotes = append(results.MyVotes, option)
+		}
+		voters[userId] = true
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	results.Voters = len(voters)
+	message.Poll = &results
+	return nil
+}
+
+// votePoll replaces the vote of the user on a poll.
+func (rt *_router) votePoll(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Voting on poll")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	convId, err := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if rt.malformedConvIdReq(err, w, r) {
+		return
+	}
+	msgId, err := strconv.ParseInt(ps.ByName("messageId"), 10, 64)
+	if rt.malformedMessageIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) {
+		return
+	}
+
+	var input model.PollVoteInput
+	if err = json.NewDecoder(r.Body).Decode(&input); err != nil {
+		rt.internalError(400, err, r, w)
+		return
+	}
+
+	anonymous, err := rt.db.VotePoll(msgId, convId, usrId, input.Options, globaltime.Now().Unix())
+	if errors.Is(err, sql.ErrNoRows) {
+		rt.internalError(404, model.ErrPollNotFound, r, w)
+		return
+	} else if errors.Is(err, database.ErrPollClosed) {
+		rt.internalError(403, err, r, w)
+		return
+	} else if errors.Is(err, database.ErrInvalidVote) {
+		rt.internalError(400, err, r, w)
+		return
+	} else if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	event := model.Event{Type: model.EventPollUpdated, ConversationId: convId, MessageId: msgId}
+	// Who voted on an anonymous poll stays unknown
+	if !anonymous {
+		event.UserId = usrId
+	}
+	rt.publishToConv(event)
+	w.WriteHeader(204)
+}
+
+// closePoll stops a poll from accepting votes. Only who created the poll can close it.
+func (rt *_router) closePoll(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Closing poll")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	convId, err := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if rt.malformedConvIdReq(err, w, r) {
+		return
+	}
+	msgId, err := strconv.ParseInt(ps.ByName("messageId"), 10, 64)
+	if rt.malformedMessageIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) || !rt.authorizeSender(w, r, ctx, msgId, convId) {
+		return
+	}
+
+	closed, err := rt.db.ClosePoll(msgId, convId, globaltime.Now().Unix())
+	if errors.Is(err, sql.ErrNoRows) {
+		rt.internalError(404, model.ErrPollNotFound, r, w)
+		return
+	} else if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	if closed {
+		rt.publishToConv(model.Event{Type: model.EventPollUpdated, ConversationId: convId, MessageId: msgId, UserId: usrId})
+	}
+	w.WriteHeader(204)
+}
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/mycompany8201046/myProject/service/api/globaltime"
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+	"gitlab.com/mycompany8201046/myProject/service/api/reqcontext"
+)
+
+// startTyping tells the other participants that the user is typing. Clients repeat it while the user keeps typing:
+// the signal expires after presence.TypingTimeout otherwise.
+func (rt *_router) startTyping(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	convId, err := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if rt.malformedConvIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) {
+		return
+	}
+
+	if rt.presence.StartTyping(convId, usrId, globaltime.Now()) {
+		rt.signalTyping(model.EventTypingStarted, convId, usrId)
+	}
+	w.WriteHeader(204)
+}
+
+// stopTyping tells the other participants that the user stopped typing without sending anything.
+func (rt *_router) stopTyping(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	convId, err := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if rt.malformedConvIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) {
+		return
+	}
+
+	rt.typingDone(convId, usrId)
+	w.WriteHeader(204)
+}
+
+// getPresence returns the presence of the other participants of the conversation, and who of them is typing.
+func (rt *_router) getPresence(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Getting presence")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	convId, err := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if rt.malformedConvIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) {
+		return
+	}
+
+	rows, err := rt.db.GetUsersByConv(convId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	members, err := scanUserIds(rows)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+
+	result := model.ConversationPresence{Participants: []model.Presence{}, Typing: []int64{}}
+	for _, member := range members {
+		if member == usrId {
+			continue
+		}
+		p, err := rt.presenceOf(member)
+		if err != nil {
+			rt.internalError(500, err, r, w)
+			return
+		}
+		result.Participants = append(result.Participants, p)
+	}
+	for _, typist := range rt.presence.Typing(convId, globaltime.Now()) {
+		if typist != usrId {
+			result.Typing = append(result.Typing, typist)
+		}
+	}
+
+	w.WriteHeader(200)
+	if err = json.NewEncoder(w).Encode(result); err != nil {
+		rt.baseLogger.Error("getPresence error:", err)
+	}
+}
+
+// getPrivacy returns the privacy settings of the user.
+func (rt *_router) getPrivacy(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) {
+		return
+	}
+
+	var settings model.PrivacySettings
+	settings.ShowLastSeen, err = rt.db.GetShowLastSeen(usrId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	w.WriteHeader(200)
+	if err = json.NewEncoder(w).Encode(settings); err != nil {
+		rt.baseLogger.Error("getPrivacy error:", err)
+	}
+}
+
+// setPrivacy changes the privacy settings of the user. Hiding the last seen time does not hide being online.
+func (rt *_router) setPrivacy(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Setting privacy")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) {
+		return
+	}
+
+	var settings model.PrivacySettings
+	if err = json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		rt.internalError(400, err, r, w)
+		return
+	}
+	if err = rt.db.SetShowLastSeen(usrId, settings.ShowLastSeen); err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// presenceOf returns the presence of the user as the others see it. The last seen time comes from the sessions when
+// the user was not seen since the server started.
+func (rt *_router) presenceOf(usrId int64) (model.Presence, error) {
+	online, lastSeen := rt.presence.Status(usrId, globaltime.Now())
+	p := model.Presence{UserId: usrId, Online: online}
+	if online {
+		return p, nil
+	}
+	show, err := rt.db.GetShowLastSeen(usrId)
+	if err != nil || !show {
+		return p, err
+	}
+	if lastSeen.IsZero() {
+		p.LastSeen, err = rt.db.GetLastSeen(usrId)
+	} else {
+		p.LastSeen = lastSeen.Unix()
+	}
+	return p, err
+}
+
+// markSeen records the activity of the user, announcing them if they just came online.
+func (rt *_router) markSeen(usrId int64) {
+	if rt.presence.Seen(usrId, globaltime.Now()) {
+		rt.publishPresence(usrId, true)
+	}
+}
+
+// publishPresence signals the users sharing a conversation with the user that they came online or went offline.
+func (rt *_router) publishPresence(usrId int64, online bool) {
+	event := model.Event{Type: model.EventPresenceOnline, UserId: usrId}
+	if !online {
+		p, err := rt.presenceOf(usrId)
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("can't get the presence of the user ", usrId)
+			return
+		}
+		event.Type = model.EventPresenceOffline
+		event.LastSeen = p.LastSeen
+	}
+	contacts, err := rt.db.GetContactIds(usrId)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("can't get the recipients of the event ", event.Type)
+		return
+	}
+	rt.signal(event, contacts)
+}
+
+// typingDone stops the typing signal of the user in the conversation, e.g. because they sent the message.
+func (rt *_router) typingDone(convId int64, usrId int64) {
+	if rt.presence.StopTyping(convId, usrId) {
+		rt.signalTyping(model.EventTypingStopped, convId, usrId)
+	}
+}
+
+// signalTyping sends a typing event to the participants of the conversation but the typist.
+func (rt *_router) signalTyping(eventType string, convId int64, usrId int64) {
+	rows, err := rt.db.GetUsersByConv(convId)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("can't get the recipients of the event ", eventType)
+		return
+	}
+	members, err := scanUserIds(rows)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("can't get the recipients of the event ", eventType)
+		return
+	}
+	recipients := members[:0]
+	for _, member := range members {
+		if member != usrId {
+			recipients = append(recipients, member)
+		}
+	}
+	rt.signal(model.Event{Type: eventType, ConversationId: convId, UserId: usrId}, recipients)
+}
+
+// expirePresence announces the users who went offline and the typing signals that expired.
+func (rt *_router) expirePresence() {
+	offline, stopped := rt.presence.Expire(globaltime.Now())
+	for _, usrId := range offline {
+		rt.publishPresence(usrId, false)
+	}
+	for _, typist := range stopped {
+		rt.signalTyping(model.EventTypingStopped, typist.ConvId, typist.UserId)
+	}
+}
@@ -0,0 +1,174 @@
+/*
+Package presence keeps track in memory of who is online and who is typing.
+
+A user is online while they have an event stream open, and for ActiveWindow after their last request. Typing signals
+last TypingTimeout unless renewed. Nothing is persisted: after a restart everybody starts offline and nobody is typing.
+
+The methods return the changes the callers have to announce; users going offline and typing signals expiring are
+collected by Expire, which has to be called periodically.
+*/
+package presence
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// ActiveWindow is how long a user without event streams stays online after a request
+	ActiveWindow = time.Minute
+
+	// TypingTimeout is how long a typing signal lasts unless renewed
+	TypingTimeout = 6 * time.Second
+)
+
+// Typist is a user typing in a conversation.
+type Typist struct {
+	ConvId int64
+	UserId int64
+}
+
+type user struct {
+	// streams is the number of event streams the user has open
+	streams int
+
+	// lastSeen is the time of the last request, or of the last stream closed
+	lastSeen time.Time
+
+	// online is the state last announced
+	online bool
+}
+
+func (u *user) isOnline(now time.Time) bool {
+	return u.streams > 0 || now.Sub(u.lastSeen) < ActiveWindow
+}
+
+// comeOnline marks the user as online, returning true if they were not.
+func (u *user) comeOnline() bool {
+	if u.online {
+		return false
+	}
+	u.online = true
+	return true
+}
+
+// Tracker holds the presence of the users and the typing signals. It is safe for concurrent use.
+type Tracker struct {
+	mu     sync.Mutex
+	users  map[int64]*user
+	typing map[Typist]time.Time
+}
+
+// New returns a Tracker where everybody is offline.
+func New() *Tracker {
+	return &Tracker{
+		users:  make(map[int64]*user),
+		typing: make(map[Typist]time.Time),
+	}
+}
+
+// get must be called with the lock held.
+func (t *Tracker) get(userId int64) *user {
+	u, ok := t.users[userId]
+	if !ok {
+		u = &user{}
+		t.users[userId] = u
+	}
+	return u
+}
+
+// Seen records a request of the user, returning true if they just came online.
+func (t *Tracker) Seen(userId int64, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u := t.get(userId)
+	u.lastSeen = now
+	return u.comeOnline()
+}
+
+// Connect records an event stream opened by the user, returning true if they just came online.
+func (t *Tracker) Connect(userId int64, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u := t.get(userId)
+	u.streams++
+	u.lastSeen = now
+	return u.comeOnline()
+}
+
+// Disconnect records the end of an event stream of the user. They stay online for ActiveWindow, so that a client
+// reconnecting does not flicker.
+func (t *Tracker) Disconnect(userId int64, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u := t.get(userId)
+	if u.streams > 0 {
+		u.streams--
+	}
+	u.lastSeen = now
+}
+
+// Status tells whether the user is online, and when they were last seen. The time is zero if they were not seen
+// since the tracker was created.
+func (t *Tracker) Status(userId int64, now time.Time) (online bool, lastSeen time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u, ok := t.users[userId]
+	if !ok {
+		return false, time.Time{}
+	}
+	return u.isOnline(now), u.lastSeen
+}
+
+// StartTyping records that the user is typing in the conversation until now+TypingTimeout. It returns true if they
+// were not typing already, false if the signal was only renewed.
+func (t *Tracker) StartTyping(convId int64, userId int64, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	typist := Typist{ConvId: convId, UserId: userId}
+	_, renewed := t.typing[typist]
+	t.typing[typist] = now.Add(TypingTimeout)
+	return !renewed
+}
+
+// StopTyping records that the user stopped typing in the conversation, returning true if they were typing.
+func (t *Tracker) StopTyping(convId int64, userId int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	typist := Typist{ConvId: convId, UserId: userId}
+	_, ok := t.typing[typist]
+	delete(t.typing, typist)
+	return ok
+}
+
+// Typing returns the users typing in the conversation.
+func (t *Tracker) Typing(convId int64, now time.Time) []int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	userIds := []int64{}
+	for typist, expiresAt := range t.typing {
+		if typist.ConvId == convId && now.Before(expiresAt) {
+			userIds = append(userIds, typist.UserId)
+		}
+	}
+	return userIds
+}
+
+// Expire returns the users who went offline and the typing signals that expired since the last call.
+func (t *Tracker) Expire(now time.Time) (offline []int64, stopped []Typist) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for userId, u := range t.users {
+		if u.online && !u.isOnline(now) {
+			u.online = false
+			offline = append(offline, userId)
+		}
+	}
+	for typist, expiresAt := range t.typing {
+		if !now.Before(expiresAt) {
+			delete(t.typing, typist)
+			stopped = append(stopped, typist)
+		}
+	}
+	return offline, stopped
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"gitlab.com/mycompany8201046/myProject/service/api/globaltime"
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+	"gitlab.com/mycompany8201046/myProject/service/api/ratelimit"
+)
+
+// RateLimitConfig holds the request budgets enforced by the router. A zero ratelimit.Limit disables the corresponding
+// check.
+type RateLimitConfig struct {
+	// PerIP is the budget of every remote IP address, across all routes
+	PerIP ratelimit.Limit
+
+	// PerUser is the budget of every authenticated user, across all routes
+	PerUser ratelimit.Limit
+
+	// Routes holds additional budgets for single routes, keyed by "METHOD /path" as registered in Handler(). They are
+	// counted per remote IP and, once the request is authenticated, per user.
+	Routes map[string]ratelimit.Limit
+}
+
+// allowRequest takes a token from the global and the route bucket of the client identified by key. If either is empty,
+// neither is taken from, a 429 response with the Retry-After header is sent back and false is returned.
+func (rt *_router) allowRequest(w http.ResponseWriter, r *http.Request, route string, key string, global ratelimit.Limit) bool {
+	ok, wait := rt.limiter.AllowAll(globaltime.Now(),
+		ratelimit.Budget{Key: key, Limit: global},
+		ratelimit.Budget{Key: route + "|" + key, Limit: rt.rateLimit.Routes[route]})
+	if ok {
+		return true
+	}
+
+	retryAfter := int64((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	rt.internalError(http.StatusTooManyRequests, model.ErrTooManyRequests, r, w)
+	return false
+}
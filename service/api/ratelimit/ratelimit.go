@@ -0,0 +1,165 @@
+/*
+Package ratelimit implements an in-memory token bucket rate limiter.
+
+Each key (e.g. a remote IP address or a user) has its own bucket holding up to Limit.Burst tokens, refilled at
+Limit.Rate tokens per second. Every request takes a token: when the bucket is empty the request must be rejected.
+*/
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit is the budget of a bucket. The zero value means "no limit".
+type Limit struct {
+	// Rate is how many tokens are added back to the bucket every second
+	Rate float64
+
+	// Burst is the maximum number of tokens in the bucket
+	Burst float64
+}
+
+// IsZero reports whether the limit is disabled.
+func (l Limit) IsZero() bool {
+	return l.Rate <= 0 || l.Burst <= 0
+}
+
+// ParseLimit parses a limit in the "N/duration" form, e.g. "10/1m" means ten requests per minute, with bursts of up to
+// ten requests. An empty string or "0" means no limit.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return Limit{}, nil
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, expected N/duration", s)
+	}
+	n, err := strconv.Atoi(parts[0])
+	if err != nil || n < 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: bad number of requests", s)
+	}
+	d, err := time.ParseDuration(parts[1])
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: bad duration", s)
+	}
+	return Limit{Rate: float64(n) / d.Seconds(), Burst: float64(n)}, nil
+}
+
+// ParseRoutes parses per-route limits in the "METHOD /path=N/duration" form, e.g. "POST /session=10/1m". The path is
+// the pattern the route is registered with.
+func ParseRoutes(specs []string) (map[string]Limit, error) {
+	routes := make(map[string]Limit)
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		i := strings.LastIndex(spec, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid route rate limit %q, expected METHOD /path=N/duration", spec)
+		}
+		limit, err := ParseLimit(spec[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		routes[strings.Join(strings.Fields(spec[:i]), " ")] = limit
+	}
+	return routes, nil
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// sweepEvery is how many calls to Allow happen between two sweeps of the idle buckets.
+const sweepEvery = 4096
+
+// Limiter holds the buckets of every key. It is safe for concurrent use.
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	calls   int
+}
+
+// New returns an empty Limiter.
+func New() *Limiter {
+	return &Limiter{buckets: make(map[string]*bucket)}
+}
+
+// Budget is a bucket to take a token from: the one of Key, with the given Limit.
+type Budget struct {
+	Key   string
+	Limit Limit
+}
+
+// Allow takes a token from the bucket of key, creating it full if needed. If the bucket is empty, Allow returns false
+// and how long the caller has to wait before the next token is available.
+func (l *Limiter) Allow(key string, limit Limit, now time.Time) (bool, time.Duration) {
+	return l.AllowAll(now, Budget{Key: key, Limit: limit})
+}
+
+// AllowAll takes a token from each bucket only if none of them is empty, so that a rejected request costs nothing. If
+// any is empty, AllowAll returns false and how long the caller has to wait before every bucket has a token again.
+func (l *Limiter) AllowAll(now time.Time, budgets ...Budget) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.calls++
+	if l.calls%sweepEvery == 0 {
+		l.sweep(now)
+	}
+
+	buckets := make([]*bucket, 0, len(budgets))
+	var wait time.Duration
+	for _, budget := range budgets {
+		if budget.Limit.IsZero() {
+			continue
+		}
+		b, ok := l.buckets[budget.Key]
+		if !ok {
+			b = &bucket{tokens: budget.Limit.Burst, last: now, limit: budget.Limit}
+			l.buckets[budget.Key] = b
+		}
+		b.refill(now)
+		b.limit = budget.Limit
+		if b.tokens < 1 {
+			if w := time.Duration(math.Ceil((1 - b.tokens) / budget.Limit.Rate * float64(time.Second))); w > wait {
+				wait = w
+			}
+		}
+		buckets = append(buckets, b)
+	}
+	if wait > 0 {
+		return false, wait
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return true, 0
+}
+
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(b.limit.Burst, b.tokens+elapsed*b.limit.Rate)
+		b.last = now
+	}
+}
+
+// sweep drops the buckets that are full again: they are indistinguishable from a new one.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= b.limit.Burst {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"gitlab.com/mycompany8201046/myProject/service/api/globaltime"
+)
+
+// fixClock stops globaltime at a known moment for the duration of the test.
+func fixClock(t *testing.T) {
+	globaltime.FixedTime = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	t.Cleanup(func() { globaltime.FixedTime = time.Time{} })
+}
+
+func advance(d time.Duration) {
+	globaltime.FixedTime = globaltime.FixedTime.Add(d)
+}
+
+func TestAllow(t *testing.T) {
+	fixClock(t)
+	l := New()
+	limit := Limit{Rate: 2, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("k", limit, globaltime.Now()); !ok {
+			t.Fatalf("request %d of the burst rejected", i+1)
+		}
+	}
+	ok, wait := l.Allow("k", limit, globaltime.Now())
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("Allow with an empty bucket = %v, %v, want false, 500ms", ok, wait)
+	}
+
+	// Half a token is not enough
+	advance(250 * time.Millisecond)
+	if ok, wait = l.Allow("k", limit, globaltime.Now()); ok || wait != 250*time.Millisecond {
+		t.Fatalf("Allow after 250ms = %v, %v, want false, 250ms", ok, wait)
+	}
+	advance(250 * time.Millisecond)
+	if ok, _ = l.Allow("k", limit, globaltime.Now()); !ok {
+		t.Fatal("Allow after the refill rejected")
+	}
+
+	// Other keys have their own bucket, and the bucket never holds more than the burst
+	if ok, _ = l.Allow("other", limit, globaltime.Now()); !ok {
+		t.Fatal("Allow of another key rejected")
+	}
+	advance(time.Hour)
+	for i := 0; i < 2; i++ {
+		if ok, _ = l.Allow("k", limit, globaltime.Now()); !ok {
+			t.Fatalf("request %d after an hour rejected", i+1)
+		}
+	}
+	if ok, _ = l.Allow("k", limit, globaltime.Now()); ok {
+		t.Fatal("Allow beyond the burst accepted")
+	}
+}
+
+func TestAllowZeroLimit(t *testing.T) {
+	fixClock(t)
+	l := New()
+	for i := 0; i < 100; i++ {
+		if ok, _ := l.Allow("k", Limit{}, globaltime.Now()); !ok {
+			t.Fatal("Allow with no limit rejected")
+		}
+	}
+}
+
+func TestAllowAll(t *testing.T) {
+	fixClock(t)
+	l := New()
+	ip := Budget{Key: "ip", Limit: Limit{Rate: 1, Burst: 2}}
+	user := Budget{Key: "user", Limit: Limit{Rate: 1, Burst: 1}}
+
+	if ok, _ := l.AllowAll(globaltime.Now(), ip, user); !ok {
+		t.Fatal("first AllowAll rejected")
+	}
+	// The user bucket is empty: the request is rejected, and the token left in the ip bucket is not taken
+	ok, wait := l.AllowAll(globaltime.Now(), ip, user)
+	if ok || wait != time.Second {
+		t.Fatalf("AllowAll with an empty bucket = %v, %v, want false, 1s", ok, wait)
+	}
+	if ok, _ = l.Allow("ip", ip.Limit, globaltime.Now()); !ok {
+		t.Fatal("the rejected AllowAll took a token from the ip bucket")
+	}
+
+	// The wait is the one of the emptiest bucket
+	advance(500 * time.Millisecond)
+	if ok, wait = l.AllowAll(globaltime.Now(), ip, user); ok || wait != 500*time.Millisecond {
+		t.Fatalf("AllowAll after 500ms = %v, %v, want false, 500ms", ok, wait)
+	}
+	advance(500 * time.Millisecond)
+	if ok, _ = l.AllowAll(globaltime.Now(), ip, user); !ok {
+		t.Fatal("AllowAll after the refill rejected")
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Limit
+		wantErr bool
+	}{
+		{"", Limit{}, false},
+		{"0", Limit{}, false},
+		{"10/1m", Limit{Rate: 10.0 / 60, Burst: 10}, false},
+		{" 5/1s ", Limit{Rate: 5, Burst: 5}, false},
+		{"10", Limit{}, true},
+		{"x/1m", Limit{}, true},
+		{"-1/1m", Limit{}, true},
+		{"10/0s", Limit{}, true},
+		{"10/soon", Limit{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLimit(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseLimit(%q) = %+v, %v, want %+v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+	"gitlab.com/mycompany8201046/myProject/service/api/reqcontext"
+	"gitlab.com/mycompany8201046/myProject/service/database"
+)
+
+// maxReactionsPerUser is how many different emoji a single user can put on a message.
+const maxReactionsPerUser = 20
+
+// getReactionCounts aggregates the reactions of the message per emoji, flagging the ones usrId reacted with.
+func (rt *_router) getReactionCounts(msgId int64, convId int64, usrId int64) ([]model.Reaction, error) {
+	reactions := []model.Reaction{}
+	rows, err := rt.db.GetReactionCounts(msgId, convId, usrId)
+	if err != nil {
+		return reactions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var reaction model.Reaction
+		err = rows.Scan(&reaction.Emoji, &reaction.Count, &reaction.Reacted)
+		if err != nil {
+			return reactions, err
+		}
+		reactions = append(reactions, reaction)
+	}
+	return reactions, rows.Err()
+}
+
+// getReactions lists the reactions of a message per emoji, with the users who reacted.
+func (rt *_router) getReactions(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Getting Reactions")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	convId, err := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if rt.malformedConvIdReq(err, w, r) {
+		return
+	}
+	msgId, err := strconv.ParseInt(ps.ByName("messageId"), 10, 64)
+	if rt.malformedMessageIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) {
+		return
+	}
+
+	rows, err := rt.db.GetReactions(msgId, convId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	defer rows.Close()
+
+	// Rows are ordered by time, so every emoji keeps the position of its first use
+	reactions := []model.Reaction{}
+	index := make(map[string]int)
+	for rows.Next() {
+		var emoji string
+		var reactionUser int64
+		err = rows.Scan(&emoji, &reactionUser)
+		if err != nil {
+			rt.internalError(500, err, r, w)
+			return
+		}
+		i, ok := index[emoji]
+		if !ok {
+			i = len(reactions)
+			index[emoji] = i
+			reactions = append(reactions, model.Reaction{Emoji: emoji})
+		}
+		reactions[i].Count++
+		reactions[i].UserIds = append(reactions[i].UserIds, reactionUser)
+		if reactionUser == usrId {
+			reactions[i].Reacted = true
+		}
+	}
+	if err = rows.Err(); err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+
+	w.WriteHeader(200)
+	err = json.NewEncoder(w).Encode(reactions)
+	if err != nil {
+		rt.baseLogger.Error("getReactions error:", err)
+		return
+	}
+}
+
+// addReaction adds an emoji to the reactions of the user on a message. A user can react with several different emoji,
+// adding the same one twice has no effect.
+func (rt *_router) addReaction(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	var input model.ReactionInput
+
+	rt.baseLogger.Info("Adding Reaction")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	convId, err := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if rt.malformedConvIdReq(err, w, r) {
+		return
+	}
+	msgId, err := strconv.ParseInt(ps.ByName("messageId"), 10, 64)
+	if rt.malformedMessageIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) {
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		rt.internalError(400, err, r, w)
+		return
+	}
+	if !database.IsSingleEmoji(input.Emoji) {
+		rt.internalError(400, model.ErrInvalidEmoji, r, w)
+		return
+	}
+
+	if !rt.activeMessage(w, r, msgId, convId) {
+		return
+	}
+
+	added, err := rt.db.AddReaction(msgId, convId, usrId, input.Emoji, maxReactionsPerUser)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	if !added {
+		rt.internalError(409, model.ErrTooManyReactions, r, w)
+		return
+	}
+	rt.publishToConv(model.Event{Type: model.EventReactionAdded, ConversationId: convId, MessageId: msgId, UserId: usrId, Emoji: input.Emoji})
+	w.WriteHeader(204)
+}
+
+// removeReaction removes a single emoji from the reactions of the user on a message.
+func (rt *_router) removeReaction(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Removing Reaction")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	convId, err := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if rt.malformedConvIdReq(err, w, r) {
+		return
+	}
+	msgId, err := strconv.ParseInt(ps.ByName("messageId"), 10, 64)
+	if rt.malformedMessageIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) {
+		return
+	}
+
+	removed, err := rt.db.RemoveReaction(msgId, convId, usrId, ps.ByName("emoji"))
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	if removed == 0 {
+		rt.internalError(404, model.ErrReactionNotFound, r, w)
+		return
+	}
+	rt.publishToConv(model.Event{Type: model.EventReactionRemoved, ConversationId: convId, MessageId: msgId, UserId: usrId, Emoji: ps.ByName("emoji")})
+	w.WriteHeader(204)
+}
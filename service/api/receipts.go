@@ -0,0 +1,100 @@
+package api
+
+import (
+	"fmt"
+
+	"gitlab.com/mycompany8201046/myProject/service/api/globaltime"
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+)
+
+// loadReceipts returns the delivery and read state of the message for each recipient, with the totals.
+func (rt *_router) loadReceipts(msgId int64, convId int64) (model.MessageReadStatus, error) {
+	status := model.MessageReadStatus{
+		MessageId:     msgId,
+		ReadByUsers:   []int64{},
+		UnreadByUsers: []int64{},
+		Recipients:    []model.Receipt{},
+	}
+	rows, err := rt.db.GetReceipts(msgId, convId)
+	if err != nil {
+		return status, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var receipt model.Receipt
+		err = rows.Scan(&receipt.User.UserId, &receipt.User.Name, &receipt.User.UserPhoto, &receipt.DeliveredAt, &receipt.ReadAt)
+		if err != nil {
+			return status, err
+		}
+		if receipt.DeliveredAt > 0 {
+			status.DeliveredCount++
+		}
+		if receipt.ReadAt > 0 {
+			status.ReadCount++
+			status.ReadByUsers = append(status.ReadByUsers, receipt.User.UserId)
+		} else {
+			status.UnreadByUsers = append(status.UnreadByUsers, receipt.User.UserId)
+		}
+		status.Recipients = append(status.Recipients, receipt)
+	}
+	if err = rows.Err(); err != nil {
+		return status, err
+	}
+
+	status.HasBeenRead = status.ReadCount == len(status.Recipients)
+	status.Summary = receiptSummary(status.DeliveredCount, status.ReadCount, len(status.Recipients))
+	return status, nil
+}
+
+// receiptSummary describes the state of a message: a chat has a single recipient, groups get the counts.
+func receiptSummary(delivered int, read int, recipients int) string {
+	switch {
+	case recipients == 0:
+		return ""
+	case recipients == 1 && read == 1:
+		return "Read"
+	case recipients == 1 && delivered == 1:
+		return "Delivered"
+	case read == recipients:
+		return "Read by everyone"
+	case read > 0:
+		return fmt.Sprintf("Read by %d of %d", read, recipients)
+	case delivered == recipients:
+		return "Delivered to everyone"
+	case delivered > 0:
+		return fmt.Sprintf("Delivered to %d of %d", delivered, recipients)
+	default:
+		return "Sent"
+	}
+}
+
+// markDelivered records that the messages, all from the same conversation, were fetched by the user, and tells their
+// senders. Errors are only logged, as they must not fail the fetch.
+func (rt *_router) markDelivered(usrId int64, messages ...model.Message) {
+	if len(messages) == 0 {
+		return
+	}
+	senders := make(map[int64]int64, len(messages))
+	var msgIds []int64
+	for _, message := range messages {
+		if message.Sender.UserId != usrId {
+			senders[message.Id] = message.Sender.UserId
+			msgIds = append(msgIds, message.Id)
+		}
+	}
+	if len(msgIds) == 0 {
+		return
+	}
+
+	convId := messages[0].ConvId
+	delivered, err := rt.db.MarkDelivered(convId, usrId, msgIds, globaltime.Now().Unix())
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("can't record the delivery of the messages")
+		return
+	}
+	for _, msgId := range delivered {
+		rt.publish(model.Event{Type: model.EventMessageDelivered, ConversationId: convId, MessageId: msgId, UserId: usrId},
+			[]int64{senders[msgId]})
+	}
+}
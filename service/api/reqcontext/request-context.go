@@ -18,4 +18,17 @@ type RequestContext struct {
 
 	// Logger is a custom field logger for the request
 	Logger logrus.FieldLogger
+
+	// Route is the "METHOD /path" pattern the request was routed to
+	Route string
+
+	// UserId is the authenticated user, filled by isAuthed (zero if the request is not authenticated)
+	UserId int64
+
+	// SessionId is the session the request was authenticated with
+	SessionId int64
+
+	// ActiveUser is shared by the copies of the context: isAuthed stores the authenticated user in it, so that the
+	// middleware can record their activity once the request is handled
+	ActiveUser *int64
 }
@@ -0,0 +1,234 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/mycompany8201046/myProject/service/api/globaltime"
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+	"gitlab.com/mycompany8201046/myProject/service/api/reqcontext"
+)
+
+const (
+	// maxScheduleAhead bounds how far in the future a message can be scheduled
+	maxScheduleAhead = 365 * 24 * time.Hour
+
+	// scheduledBatch is how many due messages the dispatcher sends at most on every tick
+	scheduledBatch = 100
+)
+
+// validSendAt checks that sendAt is in the future, within maxScheduleAhead.
+func validSendAt(sendAt int64) bool {
+	now := globaltime.Now()
+	return sendAt > now.Unix() && sendAt <= now.Add(maxScheduleAhead).Unix()
+}
+
+// scheduleMessage stores a message to be sent later by the dispatcher. It is validated as sendMessage does, now and
+// not when it is sent.
+func (rt *_router) scheduleMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Scheduling message")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	convId, err := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if rt.malformedConvIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) {
+		return
+	}
+
+	var input model.ScheduledMessageInput
+	if err = json.NewDecoder(r.Body).Decode(&input); err != nil {
+		rt.internalError(400, err, r, w)
+		return
+	}
+	if !validSendAt(input.SendAt) {
+		rt.internalError(400, model.ErrMalformedSendAt, r, w)
+		return
+	}
+	if !rt.validMessage(w, r, convId, &input.MessageInput) {
+		return
+	}
+	// A poll closing before it is sent would arrive closed
+	if input.Type == model.MessagePoll {
+		var poll model.PollPayload
+		if err = json.Unmarshal(input.Payload, &poll); err != nil || (poll.ClosesAt != 0 && poll.ClosesAt <= input.SendAt) {
+			rt.internalError(400, model.ErrMalformedPayload, r, w)
+			return
+		}
+	}
+
+	var scheduledId model.MessageId
+	scheduledId.Value, err = rt.db.ScheduleMessage(input.MessageInput, usrId, convId, input.SendAt, globaltime.Now().Unix())
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(w).Encode(scheduledId); err != nil {
+		rt.baseLogger.Error("scheduleMessage error:", err)
+	}
+}
+
+// getScheduled returns the messages the user scheduled in every conversation, the first to be sent first.
+func (rt *_router) getScheduled(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Getting scheduled messages")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) {
+		return
+	}
+
+	rows, err := rt.db.GetScheduled(usrId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	defer rows.Close()
+
+	scheduled := []model.ScheduledMessage{}
+	names := make(map[int64]string)
+	for rows.Next() {
+		var message model.ScheduledMessage
+		var payload []byte
+		err = rows.Scan(&message.Id, &message.ConversationId, &message.SendAt, &message.CreatedAt, &message.Content,
+			&message.Type, &payload, &message.RepliedId, &message.RepliedConvId)
+		if err != nil {
+			rt.internalError(500, err, r, w)
+			return
+		}
+		if len(payload) > 0 {
+			message.Payload = payload
+		}
+
+		name, ok := names[message.ConversationId]
+		if !ok {
+			name, err = rt.db.GetConvName(message.ConversationId, usrId)
+			if err != nil {
+				rt.internalError(500, err, r, w)
+				return
+			}
+			names[message.ConversationId] = name
+		}
+		message.ConversationName = name
+		scheduled = append(scheduled, message)
+	}
+	if err = rows.Err(); err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+
+	w.WriteHeader(200)
+	if err = json.NewEncoder(w).Encode(scheduled); err != nil {
+		rt.baseLogger.Error("getScheduled error:", err)
+	}
+}
+
+// rescheduleMessage moves a scheduled message to another time.
+func (rt *_router) rescheduleMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Rescheduling message")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	scheduledId, err := strconv.ParseInt(ps.ByName("scheduledId"), 10, 64)
+	if err != nil {
+		rt.internalError(400, model.AddError(model.ErrMalformedScheduledId, err), r, w)
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) {
+		return
+	}
+
+	var input model.RescheduleInput
+	if err = json.NewDecoder(r.Body).Decode(&input); err != nil {
+		rt.internalError(400, err, r, w)
+		return
+	}
+	if !validSendAt(input.SendAt) {
+		rt.internalError(400, model.ErrMalformedSendAt, r, w)
+		return
+	}
+
+	n, err := rt.db.RescheduleMessage(scheduledId, usrId, input.SendAt)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	if n == 0 {
+		rt.internalError(404, model.ErrScheduledNotFound, r, w)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// cancelScheduled deletes a scheduled message before it is sent.
+func (rt *_router) cancelScheduled(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Cancelling scheduled message")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	scheduledId, err := strconv.ParseInt(ps.ByName("scheduledId"), 10, 64)
+	if err != nil {
+		rt.internalError(400, model.AddError(model.ErrMalformedScheduledId, err), r, w)
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) {
+		return
+	}
+
+	n, err := rt.db.CancelScheduled(scheduledId, usrId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	if n == 0 {
+		rt.internalError(404, model.ErrScheduledNotFound, r, w)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// dispatchScheduled sends the scheduled messages due by globaltime.Now(). Errors are only logged: the messages that
+// failed stay scheduled and are tried again on the next run.
+func (rt *_router) dispatchScheduled() {
+	ids, err := rt.db.GetDueScheduled(globaltime.Now().Unix(), scheduledBatch)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("can't get the scheduled messages due")
+		return
+	}
+	for _, id := range ids {
+		convId, msgId, err := rt.db.SendScheduledMessage(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			// Cancelled in the meantime
+			continue
+		} else if err != nil {
+			rt.baseLogger.WithError(err).Error("can't send the scheduled message ", id)
+			continue
+		}
+		rt.previewLink(msgId, convId)
+		rt.publishMessage(model.EventMessageCreated, msgId, convId)
+	}
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+	"gitlab.com/mycompany8201046/myProject/service/api/reqcontext"
+	"gitlab.com/mycompany8201046/myProject/service/database"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+	maxSearchLength    = 100
+)
+
+// searchMessages searches the messages of every conversation of the user.
+func (rt *_router) searchMessages(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Searching messages")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) {
+		return
+	}
+	rt.search(w, r, usrId, 0)
+}
+
+// searchConversation searches the messages of a single conversation.
+func (rt *_router) searchConversation(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Searching conversation")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	convId, err := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if rt.malformedConvIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) {
+		return
+	}
+	rt.search(w, r, usrId, convId)
+}
+
+// search runs the query of the request and sends back the hits, best first. Membership is enforced by the query
+// itself: only the conversations usrId belongs to are searched.
+func (rt *_router) search(w http.ResponseWriter, r *http.Request, usrId int64, convId int64) {
+	text := strings.TrimSpace(r.URL.Query().Get("q"))
+	if text == "" || len(text) > maxSearchLength {
+		rt.internalError(400, model.ErrMalformedSearch, r, w)
+		return
+	}
+	limit := defaultSearchLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		var err error
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			rt.internalError(400, model.ErrMalformedLimit, r, w)
+			return
+		}
+	}
+
+	rows, err := rt.db.SearchMessages(usrId, convId, text, limit)
+	if errors.Is(err, database.ErrSearchUnavailable) {
+		rt.internalError(501, err, r, w)
+		return
+	} else if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	defer rows.Close()
+
+	hits := []model.SearchHit{}
+	names := make(map[int64]string)
+	for rows.Next() {
+		var hit model.SearchHit
+		var snippet string
+		err = rows.Scan(&hit.ConversationId, &hit.MessageId, &snippet, &hit.Timestamp, &hit.SenderId)
+		if err != nil {
+			rt.internalError(500, err, r, w)
+			return
+		}
+		hit.Snippet = highlight(snippet)
+
+		name, ok := names[hit.ConversationId]
+		if !ok {
+			name, err = rt.db.GetConvName(hit.ConversationId, usrId)
+			if err != nil {
+				rt.internalError(500, err, r, w)
+				return
+			}
+			names[hit.ConversationId] = name
+		}
+		hit.ConversationName = name
+		hits = append(hits, hit)
+	}
+	if err = rows.Err(); err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+
+	w.WriteHeader(200)
+	err = json.NewEncoder(w).Encode(hits)
+	if err != nil {
+		rt.baseLogger.Error("search error:", err)
+		return
+	}
+}
+
+// highlight escapes the snippet and turns the match markers set by the database into <mark> tags.
+func highlight(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, database.SnippetMatchStart, "<mark>")
+	return strings.ReplaceAll(snippet, database.SnippetMatchEnd, "</mark>")
+}
@@ -1,56 +1,174 @@
 package api
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"gitlab.com/mycompany8201046/myProject/service/api/globaltime"
 	"gitlab.com/mycompany8201046/myProject/service/api/model"
 	"gitlab.com/mycompany8201046/myProject/service/api/reqcontext"
+	"gitlab.com/mycompany8201046/myProject/service/database"
 )
 
-func (rt *_router) isAuthed(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) bool {
-	rt.baseLogger.Info("Checking for auth")
+// newSessionToken generates a random opaque token. Only its hash is stored in the database, so a leaked database
+// does not leak usable tokens.
+func newSessionToken() (token string, tokenHash string, err error) {
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashSessionToken(token), nil
+}
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssuePasswordReset creates a token letting the user set a new password, through the /password-reset endpoint, for
+// the next ttl. It is how the users without a password get one: the token is meant to be given to them by the operator.
+func IssuePasswordReset(db database.AppDatabase, userName string, ttl time.Duration) (string, error) {
+	usrId, err := db.CheckUsername(userName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("no user is named %q", userName)
+	} else if err != nil {
+		return "", err
+	}
+	token, tokenHash, err := newSessionToken()
+	if err != nil {
+		return "", err
+	}
+	err = db.CreatePasswordReset(usrId, tokenHash, globaltime.Now().Add(ttl).Unix())
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// bearerToken extracts the token from the Authorization header.
+func bearerToken(r *http.Request) (string, error) {
 	authHeader := r.Header.Get("Authorization")
 
 	if authHeader == "" {
-		rt.internalError(401, errors.New("no authorization header"), r, w)
-		return false
+		return "", errors.New("no authorization header")
 	}
 
 	// Check if the header starts with "Bearer "
 	if !strings.HasPrefix(authHeader, "Bearer ") {
-		rt.internalError(401, errors.New("invalid authorization header"), r, w)
-		return false
+		return "", errors.New("invalid authorization header")
 	}
 
 	// Extract the token part (remove "Bearer " prefix)
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == "" {
-		rt.internalError(401, errors.New("no token provided"), r, w)
-		return false
+		return "", errors.New("no token provided")
+	}
+	return token, nil
+}
+
+// requestSession returns the live session the bearer token of the request belongs to, sql.ErrNoRows if there is none.
+func (rt *_router) requestSession(r *http.Request) (model.Session, error) {
+	token, err := bearerToken(r)
+	if err != nil {
+		return model.Session{}, sql.ErrNoRows
 	}
+	return rt.db.GetSessionByToken(hashSessionToken(token), globaltime.Now().Unix())
+}
 
-	// Parse the token as userId (assuming the token is the userId)
-	userId, err := strconv.ParseInt(token, 10, 64)
+// clientIP returns the address of the client without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		rt.baseLogger.Error("Invalid token", err)
-		rt.internalError(400, model.AddErrorString("Invalid token", err.Error()), r, w)
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// truncate cuts s to at most n bytes, so that client supplied metadata cannot grow without bounds.
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// startSession mints a new session for the user and returns what has to be sent back to the client. The request is
+// used to record which device the session belongs to.
+func (rt *_router) startSession(user model.User, r *http.Request, deviceName string) (model.UserSession, error) {
+	var us model.UserSession
+	token, tokenHash, err := newSessionToken()
+	if err != nil {
+		return us, err
+	}
+
+	now := globaltime.Now()
+	session := model.Session{
+		UserId:     user.UserId,
+		DeviceName: truncate(deviceName, 50),
+		UserAgent:  truncate(r.UserAgent(), 255),
+		IPAddress:  clientIP(r),
+		CreatedAt:  now.Unix(),
+		ExpiresAt:  now.Add(rt.sessionTTL).Unix(),
+	}
+	_, err = rt.db.CreateSession(session, tokenHash)
+	if err != nil {
+		return us, err
+	}
+
+	us.User = user
+	us.Token = token
+	us.ExpiresAt = session.ExpiresAt
+	return us, nil
+}
+
+// isAuthed resolves the bearer token to its session and stores the authenticated user in ctx. If the request is not
+// authenticated the error is sent back to the client and false is returned.
+func (rt *_router) isAuthed(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx *reqcontext.RequestContext) bool {
+	rt.baseLogger.Info("Checking for auth")
+	token, err := bearerToken(r)
+	if err != nil {
+		rt.internalError(401, err, r, w)
 		return false
 	}
 
-	// Verify the user exists in the database
-	_, err = rt.db.GetUserName(userId)
+	now := globaltime.Now().Unix()
+	session, err := rt.db.GetSessionByToken(hashSessionToken(token), now)
 	if errors.Is(err, sql.ErrNoRows) {
-		rt.internalError(401, model.AddErrorString("User not found:", err.Error()), r, w)
+		rt.internalError(401, model.ErrInvalidSession, r, w)
 		return false
 	} else if err != nil {
 		rt.internalError(500, err, r, w)
 		return false
 	}
 
+	if !rt.allowRequest(w, r, ctx.Route, "user:"+strconv.FormatInt(session.UserId, 10), rt.rateLimit.PerUser) {
+		ctx.Logger.Info("rate limit exceeded for ", ctx.Route)
+		return false
+	}
+
+	err = rt.db.TouchSession(session.Id, now, clientIP(r), truncate(r.UserAgent(), 255))
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return false
+	}
+
+	ctx.UserId = session.UserId
+	ctx.SessionId = session.Id
+	if ctx.ActiveUser != nil {
+		*ctx.ActiveUser = session.UserId
+	}
+	ctx.Logger = ctx.Logger.WithField("userId", session.UserId)
 	return true
 }
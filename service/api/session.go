@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/mycompany8201046/myProject/service/api/globaltime"
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+	"gitlab.com/mycompany8201046/myProject/service/api/reqcontext"
+)
+
+// getMySessions lists the active sessions of the user, one for each device it is logged in from.
+func (rt *_router) getMySessions(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Getting Sessions")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	var sessions []model.Session
+	var tmp model.Session
+
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) {
+		return
+	}
+
+	rows, err := rt.db.GetSessions(usrId, globaltime.Now().Unix())
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		err = rows.Scan(
+			&tmp.Id,
+			&tmp.UserId,
+			&tmp.DeviceName,
+			&tmp.UserAgent,
+			&tmp.IPAddress,
+			&tmp.CreatedAt,
+			&tmp.LastUsedAt,
+			&tmp.ExpiresAt,
+		)
+		if err != nil {
+			rt.internalError(500, err, r, w)
+			return
+		}
+		tmp.Current = tmp.Id == ctx.SessionId
+		sessions = append(sessions, tmp)
+	}
+	if err = rows.Err(); err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	err = json.NewEncoder(w).Encode(sessions)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+}
+
+// deleteMySession signs out a single device of the user. It can also be the session the request is made with.
+func (rt *_router) deleteMySession(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Deleting Session")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	sessionId, err := strconv.ParseInt(ps.ByName("sessionId"), 10, 64)
+	if err != nil {
+		rt.internalError(400, model.AddError(model.ErrMalformedSessionId, err), r, w)
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) {
+		return
+	}
+
+	deleted, err := rt.db.DeleteUserSession(usrId, sessionId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	if deleted == 0 {
+		rt.internalError(404, model.ErrSessionNotFound, r, w)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// deleteMyOtherSessions signs out every device of the user except the one making the request.
+func (rt *_router) deleteMyOtherSessions(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Deleting other Sessions")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) {
+		return
+	}
+
+	err = rt.db.DeleteOtherSessions(usrId, ctx.SessionId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	w.WriteHeader(204)
+}
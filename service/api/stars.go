@@ -0,0 +1,185 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/mycompany8201046/myProject/service/api/globaltime"
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+	"gitlab.com/mycompany8201046/myProject/service/api/reqcontext"
+)
+
+// starMessage stars a message for the user. Stars are private, the other participants do not know about them.
+func (rt *_router) starMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Starring message")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	convId, err := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if rt.malformedConvIdReq(err, w, r) {
+		return
+	}
+	msgId, err := strconv.ParseInt(ps.ByName("messageId"), 10, 64)
+	if rt.malformedMessageIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) {
+		return
+	}
+	if msgType, ok := rt.messageType(w, r, msgId, convId); !ok {
+		return
+	} else if msgType == model.MessageDeleted {
+		rt.internalError(400, model.ErrMessageDeleted, r, w)
+		return
+	}
+
+	starred, err := rt.db.StarMessage(usrId, convId, msgId, globaltime.Now().Unix())
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	if starred {
+		rt.publish(model.Event{Type: model.EventMessageStarred, ConversationId: convId, MessageId: msgId, UserId: usrId}, []int64{usrId})
+	}
+	w.WriteHeader(204)
+}
+
+// unstarMessage removes the star of the user from a message.
+func (rt *_router) unstarMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Unstarring message")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	convId, err := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if rt.malformedConvIdReq(err, w, r) {
+		return
+	}
+	msgId, err := strconv.ParseInt(ps.ByName("messageId"), 10, 64)
+	if rt.malformedMessageIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) || !rt.authorizeConvMember(w, r, ctx, convId) {
+		return
+	}
+
+	n, err := rt.db.UnstarMessage(usrId, convId, msgId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	if n == 0 {
+		rt.internalError(404, model.ErrStarNotFound, r, w)
+		return
+	}
+	rt.publish(model.Event{Type: model.EventMessageUnstarred, ConversationId: convId, MessageId: msgId, UserId: usrId}, []int64{usrId})
+	w.WriteHeader(204)
+}
+
+// getStarred returns the messages starred by the user in every conversation, the most recently starred first. The
+// before query parameter takes the nextCursor of the previous page; the cursor of a star is its (starredAt, starId).
+func (rt *_router) getStarred(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Getting starred messages")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) {
+		return
+	}
+
+	c, limit, err := parseListQuery(r)
+	if err != nil {
+		rt.internalError(400, err, r, w)
+		return
+	}
+
+	page, err := rt.loadStarred(usrId, c, limit)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	w.WriteHeader(200)
+	if err = json.NewEncoder(w).Encode(page); err != nil {
+		rt.baseLogger.Error("getStarred error:", err)
+	}
+}
+
+// loadStarred reads up to limit messages starred by the user before the cursor.
+func (rt *_router) loadStarred(usrId int64, c cursor, limit int) (model.StarredPage, error) {
+	page := model.StarredPage{Messages: []model.StarredMessage{}}
+	// One more row than needed tells if there is another page
+	rows, err := rt.db.GetStarred(usrId, c.mtime, c.msgId, limit+1)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	var stars []cursor
+	for rows.Next() {
+		var star cursor
+		var starred model.StarredMessage
+		if err = rows.Scan(&star.msgId, &star.mtime, &starred.Message.ConvId, &starred.Message.Id); err != nil {
+			return page, err
+		}
+		starred.StarredAt = star.mtime
+		stars = append(stars, star)
+		page.Messages = append(page.Messages, starred)
+	}
+	if err = rows.Err(); err != nil {
+		return page, err
+	}
+	if len(page.Messages) > limit {
+		page.Messages = page.Messages[:limit]
+		page.NextCursor = stars[limit-1].encode()
+	}
+
+	convs := make(map[int64]convLabel)
+	for i := range page.Messages {
+		starred := &page.Messages[i]
+		convId := starred.Message.ConvId
+		starred.Message, err = rt.loadMessageFor(starred.Message.Id, convId, usrId)
+		if err != nil {
+			return page, err
+		}
+		label, err := rt.convLabelOf(convs, convId, usrId)
+		if err != nil {
+			return page, err
+		}
+		starred.ConversationName = label.name
+		starred.ConversationPhoto = label.photo
+	}
+	return page, nil
+}
+
+// convLabel is the name and photo of a conversation as a user sees them.
+type convLabel struct{ name, photo string }
+
+// convLabelOf returns the label of the conversation for the user, caching it in labels.
+func (rt *_router) convLabelOf(labels map[int64]convLabel, convId int64, usrId int64) (convLabel, error) {
+	label, ok := labels[convId]
+	if ok {
+		return label, nil
+	}
+	var err error
+	if label.name, err = rt.db.GetConvName(convId, usrId); err != nil {
+		return label, err
+	}
+	if label.photo, err = rt.db.GetConversationPhoto(convId, usrId); err != nil {
+		return label, err
+	}
+	labels[convId] = label
+	return label, nil
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/mycompany8201046/myProject/service/api/globaltime"
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+	"gitlab.com/mycompany8201046/myProject/service/api/reqcontext"
+	"gitlab.com/mycompany8201046/myProject/service/database"
+)
+
+// maxSyncChanges is the most changes returned by a sync: past it, reloading everything is cheaper.
+const maxSyncChanges = 1000
+
+// pruneInterval is how often the change log is pruned, see pruneChanges.
+const pruneInterval = time.Hour
+
+// sync returns the changes of the user after the since cursor, in a single response. Created and edited messages come
+// with their current content, so an offline client can catch up without reloading the conversations.
+func (rt *_router) sync(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Syncing")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) {
+		return
+	}
+
+	var since int64
+	if s := r.URL.Query().Get("since"); s != "" {
+		since, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || since < 0 {
+			rt.internalError(400, model.ErrMalformedSince, r, w)
+			return
+		}
+	}
+
+	var result model.Sync
+	result.Changes, result.Cursor, err = rt.db.GetChanges(usrId, since, maxSyncChanges)
+	if errors.Is(err, database.ErrResyncRequired) {
+		result.Resync = true
+	} else if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+
+	// A message changed several times is loaded once, with its latest content
+	messages := make(map[[2]int64]*model.Message)
+	for i, change := range result.Changes {
+		if change.Type != model.EventMessageCreated && change.Type != model.EventMessageEdited &&
+			change.Type != model.EventLinkPreview {
+			continue
+		}
+		key := [2]int64{change.ConversationId, change.MessageId}
+		message, loaded := messages[key]
+		if !loaded {
+			m, err := rt.loadMessage(change.MessageId, change.ConversationId)
+			if err == nil {
+				message = &m
+			} else if !errors.Is(err, sql.ErrNoRows) {
+				rt.internalError(500, err, r, w)
+				return
+			}
+			messages[key] = message
+		}
+		result.Changes[i].Message = message
+	}
+	for _, message := range messages {
+		if message != nil {
+			rt.markDelivered(usrId, *message)
+		}
+	}
+	if result.Changes == nil {
+		result.Changes = []model.Change{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		rt.baseLogger.Error("sync error:", err)
+		return
+	}
+}
+
+// pruneChanges deletes the changes older than the sync retention. It runs at most once every pruneInterval, and its
+// errors are only logged: an unpruned log is still correct.
+func (rt *_router) pruneChanges() {
+	now := globaltime.Now()
+	if now.Sub(rt.lastPrune) < pruneInterval {
+		return
+	}
+	rt.lastPrune = now
+	err := rt.db.PruneChanges(now.Add(-rt.syncRetention).Unix())
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("can't prune the change log")
+	}
+}
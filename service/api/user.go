@@ -8,7 +8,9 @@ import (
 	"strconv"
 
 	"github.com/julienschmidt/httprouter"
+	"gitlab.com/mycompany8201046/myProject/service/api/globaltime"
 	"gitlab.com/mycompany8201046/myProject/service/api/model"
+	"gitlab.com/mycompany8201046/myProject/service/api/password"
 	"gitlab.com/mycompany8201046/myProject/service/api/reqcontext"
 )
 
@@ -16,6 +18,9 @@ func (rt *_router) setMyUserName(w http.ResponseWriter, r *http.Request, ps http
 	var err error
 	var User model.User
 	rt.baseLogger.Info("Setting username")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
 	// The username is in the request
 	err = json.NewDecoder(r.Body).Decode(&User)
 	if err != nil {
@@ -24,7 +29,13 @@ func (rt *_router) setMyUserName(w http.ResponseWriter, r *http.Request, ps http
 		rt.internalError(500, err, r, w)
 		return
 	}
-	//	User.UserId, _ = strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	User.UserId, err = strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, User.UserId) {
+		return
+	}
 	_, err = rt.db.SetMyUserName(User.Name, User.UserId)
 	if err != nil {
 		rt.internalError(500, err, r, w)
@@ -37,11 +48,17 @@ func (rt *_router) getUserPicture(w http.ResponseWriter, r *http.Request, ps htt
 	var pathContainer model.Path
 	var err error
 	rt.baseLogger.Info("Get User Picture")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
 	userId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
 	if err != nil {
 		rt.internalError(400, model.AddError(model.ErrMalformedUserId, err), r, w)
 		return
 	}
+	if !rt.authorizeContact(w, r, ctx, userId) {
+		return
+	}
 	pathContainer.Path, err = rt.db.GetUserPhoto(userId)
 
 	if err != nil {
@@ -60,6 +77,9 @@ func (rt *_router) getUsersNotInConversation(w http.ResponseWriter, r *http.Requ
 	var UserList []model.User
 
 	rt.baseLogger.Info("Getting Not In conversation")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 
 	userId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
@@ -67,6 +87,9 @@ func (rt *_router) getUsersNotInConversation(w http.ResponseWriter, r *http.Requ
 		rt.internalError(400, model.AddError(model.ErrMalformedUserId, err), r, w)
 		return
 	}
+	if !rt.authorizeSelf(w, r, ctx, userId) {
+		return
+	}
 	rows, err := rt.db.GetUsersNotInConversation(userId)
 	if err != nil {
 		rt.internalError(500, err, r, w)
@@ -104,6 +127,9 @@ func (rt *_router) getUsers(w http.ResponseWriter, r *http.Request, ps httproute
 	var UserList []model.User
 
 	rt.baseLogger.Info("Getting Users")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 
 	rows, err := rt.db.GetUsers()
@@ -138,78 +164,287 @@ func (rt *_router) getUsers(w http.ResponseWriter, r *http.Request, ps httproute
 		return
 	}
 }
+
+// doLogin checks the credentials and starts a new session. Users created before passwords existed have none yet: the
+// password sent on their first login becomes theirs, if the request comes with one of their sessions, as knowing the
+// name is not enough to claim an account. Without a session they need a reset token from the operator.
 func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	var err error
+	var creds model.Credentials
 	var User model.User
 	w.Header().Set("Content-Type", "application/json")
 	rt.baseLogger.Info("Logging In")
 
-	err = json.NewDecoder(r.Body).Decode(&User)
-	rt.baseLogger.Infof("Username: %s\n", User.Name)
-	if User.Name == "" {
-		w.WriteHeader(http.StatusBadRequest)
+	err = json.NewDecoder(r.Body).Decode(&creds)
+	if err != nil {
+		rt.internalError(400, err, r, w)
 		return
 	}
-
-	if err != nil {
-		rt.internalError(500, err, r, w)
+	rt.baseLogger.Infof("Username: %s\n", creds.Name)
+	if creds.Name == "" || creds.Password == "" {
+		rt.internalError(400, model.ErrInvalidCredentials, r, w)
 		return
 	}
 
-	rt.baseLogger.Println("Checking if username already exists...")
-	User.UserId, err = rt.db.CheckUsername(User.Name)
+	User.Name = creds.Name
+	User.UserId, err = rt.db.CheckUsername(creds.Name)
 	if errors.Is(err, sql.ErrNoRows) {
-		rt.baseLogger.Infof("The user was not found creating it: %s\n", User.Name)
-		var result sql.Result
-		result, err = rt.db.InsertUser(User.Name)
-		if err != nil {
-			rt.baseLogger.Errorf("The user could not be created: %v\n", err)
-			rt.internalError(500, model.AddErrorString("The user could not be created", err.Error()), r, w)
-			return
-		}
-		User.UserId, err = result.LastInsertId()
+		password.VerifyDummy(creds.Password)
+		rt.internalError(401, model.ErrInvalidCredentials, r, w)
+		return
+	} else if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
 
-		if err != nil {
-			rt.internalError(500, err, r, w)
-			return
-		}
-		User.UserPhoto, err = rt.db.GetUserPhoto(User.UserId)
-		if err != nil {
-			rt.internalError(500, err, r, w)
+	hash, err := rt.db.GetPasswordHash(User.UserId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	if hash == "" {
+		if !rt.claimAccount(w, r, User.UserId, creds.Password) {
 			return
 		}
 	} else {
-		rt.baseLogger.Infof("The user was found userId: %d.\n", User.UserId)
-		w.WriteHeader(http.StatusAccepted)
-		User.UserPhoto, err = rt.db.GetUserPhoto(User.UserId)
+		ok, err := password.Verify(creds.Password, hash)
 		if err != nil {
 			rt.internalError(500, err, r, w)
 			return
 		}
-		rt.baseLogger.Infof("The user photo is   : %s", User.UserPhoto)
-		err = json.NewEncoder(w).Encode(User)
-		if err != nil {
-			rt.internalError(500, err, r, w)
+		if !ok {
+			rt.internalError(401, model.ErrInvalidCredentials, r, w)
 			return
 		}
-		return
 	}
 
-	rt.baseLogger.Infof("UserId %d\n", User.UserId)
 	User.UserPhoto, err = rt.db.GetUserPhoto(User.UserId)
-	rt.baseLogger.Infof("The user photo is   : %s", User.UserPhoto)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	session, err := rt.startSession(User, r, creds.DeviceName)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(session)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	rt.baseLogger.Infof("User successfully logged!!\n")
+}
+
+// claimAccount sets the first password of a user created before passwords existed, if the request comes with a session
+// of the user, and signs out their other sessions. If the account can't be claimed the error is sent back to the client
+// and false is returned.
+func (rt *_router) claimAccount(w http.ResponseWriter, r *http.Request, usrId int64, newPassword string) bool {
+	session, err := rt.requestSession(r)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && session.UserId != usrId) {
+		password.VerifyDummy(newPassword)
+		rt.internalError(401, model.ErrPasswordNotSet, r, w)
+		return false
+	} else if err != nil {
+		rt.internalError(500, err, r, w)
+		return false
+	}
+	if err = password.Validate(newPassword); err != nil {
+		rt.internalError(400, err, r, w)
+		return false
+	}
+
+	hash, err := password.Hash(newPassword)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return false
+	}
+	if err = rt.db.SetPasswordHash(usrId, hash); err != nil {
+		rt.internalError(500, err, r, w)
+		return false
+	}
+	if err = rt.db.DeleteOtherSessions(usrId, session.Id); err != nil {
+		rt.internalError(500, err, r, w)
+		return false
+	}
+	return true
+}
+
+// registerUser creates a new password protected account and logs it in.
+func (rt *_router) registerUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	var err error
+	var creds model.Credentials
+	var User model.User
+	w.Header().Set("Content-Type", "application/json")
+	rt.baseLogger.Info("Registering User")
+
+	err = json.NewDecoder(r.Body).Decode(&creds)
+	if err != nil {
+		rt.internalError(400, err, r, w)
+		return
+	}
+	if creds.Name == "" || len(creds.Name) > 20 {
+		rt.internalError(400, model.ErrMalformedUserName, r, w)
+		return
+	}
+	err = password.Validate(creds.Password)
+	if err != nil {
+		rt.internalError(400, err, r, w)
+		return
+	}
+
+	_, err = rt.db.CheckUsername(creds.Name)
 	if err == nil {
-		w.WriteHeader(http.StatusCreated)
-		err = json.NewEncoder(w).Encode(User)
+		rt.internalError(409, model.ErrUsernameTaken, r, w)
+		return
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		rt.internalError(500, err, r, w)
+		return
+	}
+
+	hash, err := password.Hash(creds.Password)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	User.Name = creds.Name
+	User.UserId, err = rt.db.RegisterUser(creds.Name, hash)
+	if err != nil {
+		rt.internalError(500, model.AddErrorString("The user could not be created", err.Error()), r, w)
+		return
+	}
+	User.UserPhoto, err = rt.db.GetUserPhoto(User.UserId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	session, err := rt.startSession(User, r, creds.DeviceName)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(session)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+}
+
+// setMyPassword changes the password of the authenticated user and signs out every other session.
+func (rt *_router) setMyPassword(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	var change model.PasswordChange
+	rt.baseLogger.Info("Setting password")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+	usrId, err := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if rt.malformedUserIdReq(err, w, r) {
+		return
+	}
+	if !rt.authorizeSelf(w, r, ctx, usrId) {
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&change)
+	if err != nil {
+		rt.internalError(400, err, r, w)
+		return
+	}
+	err = password.Validate(change.NewPassword)
+	if err != nil {
+		rt.internalError(400, err, r, w)
+		return
+	}
+
+	hash, err := rt.db.GetPasswordHash(usrId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	if hash != "" {
+		ok, err := password.Verify(change.OldPassword, hash)
 		if err != nil {
 			rt.internalError(500, err, r, w)
 			return
 		}
-		rt.baseLogger.Infof("User successfully logged!!\n")
-	} else {
-		rt.baseLogger.Errorf("Error %v", err)
-		w.WriteHeader(http.StatusBadRequest)
+		if !ok {
+			rt.internalError(403, model.ErrWrongPassword, r, w)
+			return
+		}
+	}
+
+	hash, err = password.Hash(change.NewPassword)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	err = rt.db.SetPasswordHash(usrId, hash)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	err = rt.db.DeleteOtherSessions(usrId, ctx.SessionId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// resetPassword sets the password of a user with a reset token given by the operator. The token can be used once, and
+// every session of the user is signed out.
+func (rt *_router) resetPassword(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	var reset model.PasswordReset
+	rt.baseLogger.Info("Resetting password")
+	err := json.NewDecoder(r.Body).Decode(&reset)
+	if err != nil {
+		rt.internalError(400, err, r, w)
+		return
+	}
+	if reset.Token == "" {
+		rt.internalError(400, model.ErrInvalidResetToken, r, w)
+		return
+	}
+	err = password.Validate(reset.NewPassword)
+	if err != nil {
+		rt.internalError(400, err, r, w)
+		return
+	}
+
+	hash, err := password.Hash(reset.NewPassword)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	usrId, err := rt.db.ResetPassword(hashSessionToken(reset.Token), hash, globaltime.Now().Unix())
+	if errors.Is(err, sql.ErrNoRows) {
+		rt.internalError(400, model.ErrInvalidResetToken, r, w)
+		return
+	} else if err != nil {
+		rt.internalError(500, err, r, w)
+		return
 	}
+	rt.baseLogger.Infof("The password of the user %d was reset", usrId)
+	w.WriteHeader(204)
+}
+
+// doLogout revokes the session the request was authenticated with.
+func (rt *_router) doLogout(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.baseLogger.Info("Logging Out")
+	if !rt.isAuthed(w, r, ps, &ctx) {
+		return
+	}
+
+	err := rt.db.DeleteSession(ctx.SessionId)
+	if err != nil {
+		rt.internalError(500, err, r, w)
+		return
+	}
+	w.WriteHeader(204)
 }
 
 func (rt *_router) setMyPhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
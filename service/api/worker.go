@@ -0,0 +1,24 @@
+package api
+
+import "time"
+
+// runWorker sends the scheduled messages as they become due, deletes the expired ones, announces the users going
+// offline and the typing signals expiring, prunes the change log and deletes the files no longer used, every interval,
+// until stopWorker is closed.
+func (rt *_router) runWorker(interval time.Duration) {
+	defer close(rt.workerDone)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rt.stopWorker:
+			return
+		case <-ticker.C:
+			rt.dispatchScheduled()
+			rt.deleteExpired()
+			rt.expirePresence()
+			rt.pruneChanges()
+			rt.pruneAttachments()
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+)
+
+// fileMessageOf is the condition on Message M selecting the file messages referencing the attachment A. Deleted
+// messages have no payload, so they reference nothing.
+const fileMessageOf = `M.type = 'file' AND json_extract(M.payload,'$.fileId') = A.attachmentId`
+
+// FileMessage stores the attachment and sends the message with it in the conversation, returning the id of the
+// message. The payload of the message is made from the attachment.
+func (db *appdbimpl) FileMessage(file model.Attachment, msgInput model.MessageInput, usrId int64, convId int64) (int64, error) {
+	tx, err := db.BeginTx()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err = tx.Rollback(); err != nil {
+			if errors.Is(err, sql.ErrTxDone) {
+				err = nil
+			}
+		}
+	}()
+
+	query := `INSERT INTO Attachment (uploaderId,name,mimeType,size,path,createdAt) VALUES($1,$2,$3,$4,$5,unixepoch())`
+	res, err := tx.Exec(query, usrId, file.Name, file.MimeType, file.Size, file.Path)
+	if err != nil {
+		return 0, err
+	}
+	fileId, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	msgInput.Type = model.MessageFile
+	msgInput.Payload, err = json.Marshal(model.FilePayload{
+		FileId:   fileId,
+		Name:     file.Name,
+		MimeType: file.MimeType,
+		Size:     file.Size,
+	})
+	if err != nil {
+		return 0, err
+	}
+	msgId, err := db.createMessageWithTx(tx, msgInput, 0, usrId, convId)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return msgId, nil
+}
+
+// GetAttachment returns the attachment if the user can download it, that is if it is in a message of one of their
+// conversations, and sql.ErrNoRows otherwise.
+func (db *appdbimpl) GetAttachment(fileId int64, usrId int64) (model.Attachment, error) {
+	var file model.Attachment
+	query := `SELECT A.attachmentId,A.name,A.mimeType,A.size,A.path,A.createdAt FROM Attachment AS A
+		WHERE A.attachmentId = $1 AND EXISTS (SELECT 1 FROM Message AS M
+			JOIN Conv_User AS C ON C.convId = M.convId AND C.usrId = $2
+			WHERE ` + fileMessageOf + `)`
+	err := db.c.QueryRow(query, fileId, usrId).Scan(&file.Id, &file.Name, &file.MimeType, &file.Size, &file.Path, &file.CreatedAt)
+	return file, err
+}
+
+// HasAttachment tells if an attachment is stored in the file at path.
+func (db *appdbimpl) HasAttachment(path string) (bool, error) {
+	var exists bool
+	err := db.c.QueryRow("SELECT EXISTS(SELECT 1 FROM Attachment WHERE path = $1)", path).Scan(&exists)
+	return exists, err
+}
+
+// DeleteOrphanAttachments deletes up to limit attachments uploaded before the given time that no message references
+// anymore, because they were deleted or they expired, and returns the paths of their files.
+func (db *appdbimpl) DeleteOrphanAttachments(before int64, limit int) ([]string, error) {
+	tx, err := db.BeginTx()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err = tx.Rollback(); err != nil {
+			if errors.Is(err, sql.ErrTxDone) {
+				err = nil
+			}
+		}
+	}()
+
+	query := `SELECT A.attachmentId, A.path FROM Attachment AS A
+		WHERE A.createdAt < $1 AND NOT EXISTS (SELECT 1 FROM Message AS M WHERE ` + fileMessageOf + `)
+		LIMIT $2`
+	rows, err := tx.Query(query, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	var paths []string
+	for rows.Next() {
+		var id int64
+		var path string
+		if err = rows.Scan(&id, &path); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		paths = append(paths, path)
+	}
+	if err = rows.Err(); err != nil {
+		_ = rows.Close()
+		return nil, err
+	}
+	_ = rows.Close()
+
+	for _, id := range ids {
+		if _, err = tx.Exec("DELETE FROM Attachment WHERE attachmentId = $1", id); err != nil {
+			return nil, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
@@ -6,17 +6,31 @@ import (
 	"fmt"
 )
 
-func (db *appdbimpl) GetConversation(convId int64) (*sql.Rows, error) {
-	query := "SELECT messageId,content,mtime,usrSenderId,convId,IFNULL(photoId,-1),IFNULL(repliedId,0),IFNULL(repliedConvId,0) AS photoId FROM Message AS M WHERE M.convId = $1"
-	var conversation *sql.Rows
-
-	conversation, err := db.c.Query(query, convId)
-	if err != nil {
-		defer conversation.Close()
-		return nil, err
-	}
+// messageColumns are the columns of Message read by scanMessage in the api package.
+const messageColumns = "messageId,content,mtime,usrSenderId,convId,IFNULL(photoId,-1),IFNULL(repliedId,0),IFNULL(repliedConvId,0),IFNULL(editedAt,0),type,IFNULL(payload,''),IFNULL(expiresAt,0),IFNULL(deletedAt,0),IFNULL(mentions,'')," + linkPreviewColumn
+
+// notHidden is the condition leaving out of a query on Message the messages hidden by the user $2.
+const notHidden = `NOT EXISTS (SELECT 1 FROM HiddenMessage AS H
+	WHERE H.userId = $2 AND H.convId = Message.convId AND H.messageId = Message.messageId)`
+
+// GetMessagesBefore returns up to limit messages of the conversation sent before the (mtime, msgId) cursor, newest
+// first. The messages the user hid are left out.
+func (db *appdbimpl) GetMessagesBefore(convId int64, usrId int64, mtime int64, msgId int64, limit int) (*sql.Rows, error) {
+	query := "SELECT " + messageColumns + ` FROM Message
+		WHERE convId = $1 AND ` + notHidden + ` AND (mtime < $3 OR (mtime = $3 AND messageId < $4))
+		ORDER BY mtime DESC, messageId DESC
+		LIMIT $5`
+	return db.c.Query(query, convId, usrId, mtime, msgId, limit)
+}
 
-	return conversation, err
+// GetMessagesAfter returns up to limit messages of the conversation sent after the (mtime, msgId) cursor, oldest
+// first. The messages the user hid are left out.
+func (db *appdbimpl) GetMessagesAfter(convId int64, usrId int64, mtime int64, msgId int64, limit int) (*sql.Rows, error) {
+	query := "SELECT " + messageColumns + ` FROM Message
+		WHERE convId = $1 AND ` + notHidden + ` AND (mtime > $3 OR (mtime = $3 AND messageId > $4))
+		ORDER BY mtime ASC, messageId ASC
+		LIMIT $5`
+	return db.c.Query(query, convId, usrId, mtime, msgId, limit)
 }
 
 func (db *appdbimpl) GetConvName(convId int64, usrId int64) (string, error) {
@@ -37,13 +51,20 @@ func (db *appdbimpl) GetConvName(convId int64, usrId int64) (string, error) {
 	return name, nil
 }
 
+// GetGroupByConv returns the id of the group of the conversation, sql.ErrNoRows if it is a chat between two users.
+func (db *appdbimpl) GetGroupByConv(convId int64) (int64, error) {
+	var groupId int64
+	err := db.c.QueryRow("SELECT groupId FROM GroupTB WHERE convId = $1", convId).Scan(&groupId)
+	return groupId, err
+}
+
 func (db *appdbimpl) GetConversationPhoto(convId int64, userId int64) (string, error) {
 	var groupId int64
 	q := "SELECT groupId FROM GroupTB WHERE convId = $1"
 	err := db.c.QueryRow(q, convId).Scan(&groupId)
 	var photo string
 	if errors.Is(err, sql.ErrNoRows) || groupId == 0 {
-		q = "SELECT IFNULL(userPhoto,'images/defaultPP.png') AS userPhoto FROM User WHERE userId == (SELECT usrId FROM Conv_User WHERE convId = 1 AND usrId !=1) "
+		q = "SELECT IFNULL(userPhoto,'images/defaultPP.png') AS userPhoto FROM User WHERE userId == (SELECT usrId FROM Conv_User WHERE convId = $1 AND usrId != $2)"
 		err = db.c.QueryRow(q, convId, userId).Scan(&photo)
 	} else if err == nil {
 		q = "SELECT IFNULL(photo,'images/defaultPP.png') AS photo FROM GroupTB WHERE groupId = $1"
@@ -53,6 +74,19 @@ func (db *appdbimpl) GetConversationPhoto(convId int64, userId int64) (string, e
 	return photo, err
 }
 
+// unreadMessages selects the messages of the conversation C the user $1 has not read, that is the ones after the read
+// marker which were not read one by one. System messages do not count.
+const unreadMessages = `FROM Message AS M
+	LEFT JOIN ReadMarker AS K ON K.convId = M.convId AND K.userId = $1
+	WHERE M.convId = C.conversationId AND M.usrSenderId != $1 AND M.type NOT IN ('system','deleted')
+	AND (K.userId IS NULL OR (M.mtime, M.messageId) > (K.lastReadMtime, K.lastReadMessageId))
+	AND NOT EXISTS (SELECT 1 FROM MessageReadStatus AS R
+		WHERE R.messageId = M.messageId AND R.convId = M.convId AND R.userId = $1)
+	AND NOT EXISTS (SELECT 1 FROM HiddenMessage AS H
+		WHERE H.userId = $1 AND H.convId = M.convId AND H.messageId = M.messageId)`
+
+// GetConversations returns the conversations of the user with their last message, their group or other user, how
+// many messages the user has not read and the id of the oldest of them, zero if there are none.
 func (db *appdbimpl) GetConversations(usrId int64) (*sql.Rows, error) {
 	/* *********************************************************
 		query := `SELECT C.*,IFNULL(G.groupId,0)  AS groupId FROM (SELECT conversationId,
@@ -60,10 +94,14 @@ func (db *appdbimpl) GetConversations(usrId int64) (*sql.Rows, error) {
 	  IFNULL(Message.mtime, unixepoch()) AS mtime FROM Conversation INNER JOIN Conv_User ON  Conversation.conversationId = Conv_User.convId AND Conv_User.usrId=$1
 				  LEFT JOIN Message ON Conversation.lastMsgId = Message.messageId AND Message.convId = Conversation.conversationId) AS C LEFT JOIN GroupTB AS G ON G.convId = C.conversationId`
 	*/
-	query := `SELECT C.*,IFNULL(G.groupId,0) AS groupId,IFNULL(CU.usrId,0) AS userId FROM 
+	query := `SELECT C.*,IFNULL(G.groupId,0) AS groupId,IFNULL(CU.usrId,0) AS userId,
+			  (SELECT COUNT(*) ` + unreadMessages + `) AS unreadCount,
+			  IFNULL((SELECT M.messageId ` + unreadMessages + ` ORDER BY M.mtime, M.messageId LIMIT 1), 0) AS firstUnreadId FROM 
 			  ((SELECT conversationId, 
 			  IFNULL(Message.content, '') AS content, 
-			  IFNULL(Message.mtime, unixepoch()) AS mtime FROM Conversation INNER JOIN Conv_User ON  Conversation.conversationId = Conv_User.convId AND Conv_User.usrId=$1 
+			  IFNULL(Message.mtime, unixepoch()) AS mtime,
+			  IFNULL(Message.type, 'text') AS type,
+			  IFNULL(Message.payload, '') AS payload FROM Conversation INNER JOIN Conv_User ON  Conversation.conversationId = Conv_User.convId AND Conv_User.usrId=$1 
 			  LEFT JOIN Message ON Conversation.lastMsgId = Message.messageId AND Message.convId = Conversation.conversationId) AS C 
 			  LEFT JOIN GroupTB AS G ON G.convId = C.conversationId) AS GC
 			  LEFT JOIN Conv_User AS CU ON CU.convId = GC.conversationId  AND CU.usrId != $1  AND GC.groupId IS NULL`
@@ -80,6 +118,13 @@ func (db *appdbimpl) GetConversations(usrId int64) (*sql.Rows, error) {
 	return conversations, err
 }
 
+func (db *appdbimpl) IsConvMember(convId int64, usrId int64) (bool, error) {
+	query := "SELECT COUNT(*) FROM Conv_User WHERE convId = $1 AND usrId = $2"
+	var count int
+	err := db.c.QueryRow(query, convId, usrId).Scan(&count)
+	return count > 0, err
+}
+
 // * The query can be done prettier by using Triggers
 func (db *appdbimpl) addUsersToConv(tx *HookedTx, users []int64, last_inserted_conv int64) error {
 	var err error
@@ -40,30 +40,88 @@ import (
 
 // AppDatabase is the high level interface for the DB
 type AppDatabase interface {
-	GetConversation(convId int64) (*sql.Rows, error)
+	GetMessagesBefore(convId int64, usrId int64, mtime int64, msgId int64, limit int) (*sql.Rows, error)
+	GetMessagesAfter(convId int64, usrId int64, mtime int64, msgId int64, limit int) (*sql.Rows, error)
 	GetConvName(convId int64, usrId int64) (string, error)
 	GetConversations(usrId int64) (*sql.Rows, error)
+	IsConvMember(convId int64, usrId int64) (bool, error)
+	GetGroupByConv(convId int64) (int64, error)
 
 	GetMessage(msgId int64, convId int64) *sql.Row
-	HasMessageBeenRead(msgId int64, convId int64, userId int64) (bool, error)
-	WhoHasReadMessage(msgId int64, convId int64, userId int64) (*sql.Rows, error)
-	WhoHasNotReadMessage(msgId int64, convId int64, userId int64) (*sql.Rows, error)
+	GetMessageSender(msgId int64, convId int64) (int64, error)
+	GetMessageType(msgId int64, convId int64) (string, error)
+	GetReceipts(msgId int64, convId int64) (*sql.Rows, error)
+	MarkDelivered(convId int64, usrId int64, msgIds []int64, now int64) ([]int64, error)
 	ReadMessage(msgId int64, convId int64, userId int64) error
-	DeleteMessage(msgId int64, convId int64) (sql.Result, error)
+	MarkConversationRead(convId int64, usrId int64, msgId int64, now int64) (bool, error)
+	DeleteMessage(msgId int64, convId int64, now int64) (bool, error)
+	HideMessage(usrId int64, convId int64, msgId int64, now int64) (bool, error)
+	IsMessageHidden(usrId int64, convId int64, msgId int64) (bool, error)
+	EditMessage(msgId int64, convId int64, content string, editedAt int64) error
+	GetMessageEdits(msgId int64, convId int64) (*sql.Rows, error)
 
 	CreateMessage(message model.MessageInput, photoId int64, usrId int64, convId int64) (int64, error)
-	ForwardMessage(OgMessageId int64, OgConvId int64, usrId int64, convId int64) (sql.Result, error)
-	GetComments(convId int64, messageId int64) (*sql.Rows, error)
-	CommentMessage(msgId int64, convId int64, usrId int64, commentContent string) (int64, error)
-	PhotoMessage(picture model.Picture, messageId int64, msgInput model.Message, conversationId int64, userId int64) error
+	ForwardMessage(OgMessageId int64, OgConvId int64, usrId int64, convId int64) (int64, int64, error)
+	PhotoMessage(picture model.Picture, messageId int64, msgInput model.Message, conversationId int64, userId int64) (int64, error)
 
-	GetFinalComment(msgId int64, convId int64) ([]model.Comment, error)
-	RemoveComment(userId int64, convId int64, messageId int64) error
+	AddReaction(msgId int64, convId int64, usrId int64, emoji string, limit int) (bool, error)
+	RemoveReaction(msgId int64, convId int64, usrId int64, emoji string) (int64, error)
+	GetReactionCounts(msgId int64, convId int64, usrId int64) (*sql.Rows, error)
+	GetReactions(msgId int64, convId int64) (*sql.Rows, error)
+	GetPoll(msgId int64, convId int64) *sql.Row
+	GetPollVotes(msgId int64, convId int64) (*sql.Rows, error)
+	VotePoll(msgId int64, convId int64, usrId int64, options []int, now int64) (bool, error)
+	ClosePoll(msgId int64, convId int64, now int64) (bool, error)
+	PinMessage(convId int64, msgId int64, usrId int64, limit int, now int64) (bool, error)
+	UnpinMessage(convId int64, msgId int64) (int64, error)
+	GetPins(convId int64, usrId int64) (*sql.Rows, error)
+	StarMessage(usrId int64, convId int64, msgId int64, now int64) (bool, error)
+	UnstarMessage(usrId int64, convId int64, msgId int64) (int64, error)
+	GetStarred(usrId int64, starredAt int64, starId int64, limit int) (*sql.Rows, error)
+	GetMentions(usrId int64, createdAt int64, mentionId int64, limit int) (*sql.Rows, error)
+	SetMessageLink(msgId int64, convId int64, url string) error
+	GetLinkPreviewFetchedAt(url string) (int64, bool, error)
+	SaveLinkPreview(preview model.LinkPreview, ok bool, fetchedAt int64) error
+	FileMessage(file model.Attachment, msgInput model.MessageInput, usrId int64, convId int64) (int64, error)
+	GetAttachment(fileId int64, usrId int64) (model.Attachment, error)
+	DeleteOrphanAttachments(before int64, limit int) ([]string, error)
+	HasAttachment(path string) (bool, error)
+	ScheduleMessage(message model.MessageInput, usrId int64, convId int64, sendAt int64, now int64) (int64, error)
+	GetScheduled(usrId int64) (*sql.Rows, error)
+	CancelScheduled(scheduledId int64, usrId int64) (int64, error)
+	RescheduleMessage(scheduledId int64, usrId int64, sendAt int64) (int64, error)
+	GetDueScheduled(now int64, limit int) ([]int64, error)
+	SendScheduledMessage(scheduledId int64) (int64, int64, error)
+	GetMessageLifetime(convId int64) (int64, error)
+	SetMessageLifetime(convId int64, lifetime int64, actorId int64) (int64, error)
+	GetExpiredMessages(now int64, limit int) (*sql.Rows, error)
+	DeleteExpiredMessage(msgId int64, convId int64, now int64) (bool, error)
+	SearchMessages(usrId int64, convId int64, text string, limit int) (*sql.Rows, error)
+	GetChanges(usrId int64, since int64, limit int) ([]model.Change, int64, error)
+	PruneChanges(before int64) error
 	CreateConversation(users []int64) (int64, error)
 
 	CheckUsername(usrName string) (int64, error)
 	InsertUser(newUsrName string) (sql.Result, error)
+	RegisterUser(usrName string, passwordHash string) (int64, error)
+	GetPasswordHash(usrId int64) (string, error)
+	SetPasswordHash(usrId int64, passwordHash string) error
+	CreatePasswordReset(usrId int64, tokenHash string, expiresAt int64) error
+	ResetPassword(tokenHash string, passwordHash string, now int64) (int64, error)
 	SetMyUserName(newUsrName string, usrId int64) (sql.Result, error)
+	GetShowLastSeen(usrId int64) (bool, error)
+	SetShowLastSeen(usrId int64, show bool) error
+	GetLastSeen(usrId int64) (int64, error)
+	GetContactIds(usrId int64) ([]int64, error)
+	SharesConversation(usrId int64, otherId int64) (bool, error)
+
+	CreateSession(session model.Session, tokenHash string) (int64, error)
+	GetSessionByToken(tokenHash string, now int64) (model.Session, error)
+	GetSessions(usrId int64, now int64) (*sql.Rows, error)
+	TouchSession(sessionId int64, now int64, ipAddress string, userAgent string) error
+	DeleteSession(sessionId int64) error
+	DeleteUserSession(usrId int64, sessionId int64) (int64, error)
+	DeleteOtherSessions(usrId int64, keepSessionId int64) error
 
 	GetConversationPhoto(convId int64, userId int64) (string, error)
 	SetUserPhoto(pic model.Picture, usrId int64) (sql.Result, error)
@@ -71,6 +129,7 @@ type AppDatabase interface {
 	GetUserPhoto(userId int64) (string, error)
 	GetGroupPhoto(groupId int64) (string, error)
 	GetPhoto(photoId int64) (string, error)
+	CanSeePhoto(photoId int64, usrId int64) (bool, error)
 
 	GetUsers() (*sql.Rows, error)
 	GetUsersNotInConversation(userId int64) (*sql.Rows, error)
@@ -78,12 +137,14 @@ type AppDatabase interface {
 	GetUsersByConv(convId int64) (*sql.Rows, error)
 	GetGroupInfo(groupId int64) *sql.Row
 	GetUsersByGroup(groupId int64) (*sql.Rows, error)
-	CreateGroup(g_name string, usrIds []int64) (sql.Result, error)
-	AddGroup(newUsrIds []int64, grpId int64) (sql.Result, error)
-	LeaveGroup(usrId int64, grpId int64) (sql.Result, error)
-	SetGroupName(newName string, grpId int64) (sql.Result, error)
-	SetGroupPhoto(pic model.Picture, grpId int64) (sql.Result, error)
-	SetGroupDesc(newDesc string, grpId int64) (sql.Result, error)
+	IsGroupMember(groupId int64, usrId int64) (bool, error)
+	CreateGroup(g_name string, usrIds []int64, creatorId int64) (int64, int64, error)
+	GetGroupCreator(grpId int64) (int64, error)
+	AddGroup(newUsrIds []int64, grpId int64, actorId int64) (int64, int64, error)
+	LeaveGroup(usrId int64, grpId int64) (int64, int64, error)
+	SetGroupName(newName string, grpId int64, actorId int64) (int64, int64, error)
+	SetGroupPhoto(pic model.Picture, grpId int64, actorId int64) (int64, int64, error)
+	SetGroupDesc(newDesc string, grpId int64, actorId int64) (int64, int64, error)
 	Ping() error
 	SanitizeString(s string) (string, error)
 	AddPreCommitHook(hook PreCommitHook)
@@ -97,6 +158,9 @@ type PreCommitHook func(tx *sql.Tx) error
 type appdbimpl struct {
 	c              *sql.DB
 	preCommitHooks []PreCommitHook
+
+	// searchable is false when SQLite has no FTS5 support, see searchMigration
+	searchable bool
 }
 
 func (db *appdbimpl) GetNumCurrentlyUsedConn() int {
@@ -224,17 +288,6 @@ func New(db *sql.DB) (AppDatabase, error) {
 	    CONSTRAINT "photoId" FOREIGN KEY("photoId") REFERENCES "Photo"("id"),
 		CONSTRAINT "repliedMessage" FOREIGN KEY("repliedId","repliedConvId") REFERENCES "Message"("messageId","convId") ON DELETE CASCADE
       )`,
-			`CREATE TABLE "Comment" (
-	"commentId"	INTEGER NOT NULL CHECK("commentId" > 0),
-	"msgId"	INTEGER NOT NULL,
-	"convId"	INTEGER NOT NULL,
-	"userId"	INTEGER NOT NULL,
-	"userName" TEXT ,
-	"content"	TEXT NOT NULL,
-	PRIMARY KEY("commentId","msgId","convId"),
-	CONSTRAINT "msgId" FOREIGN KEY("msgId","convId") REFERENCES "Message"("messageId","convId") ON DELETE CASCADE,
-	CONSTRAINT "userId" FOREIGN KEY("userId") REFERENCES "User"("userId")
-	 )`,
 			`CREATE TABLE "Conv_User" (
 	    "convId"	INTEGER NOT NULL,
 	    "usrId"	INTEGER NOT NULL,
@@ -249,13 +302,6 @@ func New(db *sql.DB) (AppDatabase, error) {
 		FOREIGN KEY("messageId", "convId") REFERENCES "Message"("messageId", "convId"),
 		FOREIGN KEY("userId") REFERENCES "User"("userId")
 	  );`,
-			`CREATE TRIGGER delete_comments_before_message 
-  		 BEFORE DELETE ON Message
-  		 FOR EACH ROW
-		BEGIN
-   			DELETE FROM Comment 
-   		WHERE OLD.messageId = Comment.msgId AND OLD.convId = Comment.convId;
-			END`,
 			`CREATE TRIGGER update_conversation_after_message
   		 AFTER INSERT ON Message
 		BEGIN
@@ -298,23 +344,6 @@ func New(db *sql.DB) (AppDatabase, error) {
 			BEGIN 
 			DELETE FROM GroupTB WHERE GroupTB.groupId = OLD.groupId;
 			END;`,
-			`CREATE TRIGGER comprehensive_conversation_cleanup
-			AFTER DELETE ON Message
-			FOR EACH ROW
-			WHEN (SELECT COUNT(messageId) FROM Message WHERE convId = OLD.convId) = 0
-			BEGIN
-			    -- When the last message is deleted, clean up everything
-			    DELETE FROM MessageReadStatus WHERE convId = OLD.convId;
-			    DELETE FROM Comment WHERE convId = OLD.convId;
-			    -- Messages already deleted (what triggered this)
-			    DELETE FROM Group_User WHERE groupId IN (
-			        SELECT groupId FROM GroupTB WHERE convId = OLD.convId
-			    );
-			    DELETE FROM GroupTB WHERE convId = OLD.convId;
-			    DELETE FROM User_Chat WHERE convId = OLD.convId;
-			    DELETE FROM Conv_User WHERE convId = OLD.convId;
-			    DELETE FROM Conversation WHERE conversationId = OLD.convId;
-			END;`,
 			`INSERT INTO User (userName) VALUES("Alice");`,
 			`INSERT INTO User (userName) VALUES("Bob");`,
 			`INSERT INTO User (userName) VALUES("Charlie");`,
@@ -340,7 +369,6 @@ func New(db *sql.DB) (AppDatabase, error) {
 			`INSERT INTO Message (messageId,content,mtime,usrSenderId,convId) VALUES(3,"Domani dove vuoi andare?",1737478510,2,1)`,
 			`INSERT INTO Message (messageId,content,mtime,usrSenderId,convId) VALUES(4,"Non so...",unixepoch(),1,1)`,
 			`INSERT INTO Message (messageId,content,mtime,usrSenderId,convId) VALUES(1,"Benvenuti🥳🎉🎊 nel gruppo di cybersec💻!!!",1737478600,3,2)`,
-			`INSERT INTO Comment (commentId,msgId,convId,userId,userName,content) VALUES(1,2,1,1,"Alice","😍")`,
 			`INSERT INTO Photo (path,size) VALUES("./images/defaultPP.png",2183)`,
 		}
 		for _, query := range sqlStmt {
@@ -358,8 +386,21 @@ func New(db *sql.DB) (AppDatabase, error) {
 			return nil, errors.New("error committing transaction:" + err.Error())
 		}
 	}
+
+	err = migrate(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var searchable bool
+	q := "SELECT sqlite_compileoption_used('ENABLE_FTS5') AND EXISTS (SELECT 1 FROM sqlite_master WHERE name = 'MessageFTS')"
+	err = db.QueryRow(q).Scan(&searchable)
+	if err != nil {
+		return nil, err
+	}
 	return &appdbimpl{
-		c: db,
+		c:          db,
+		searchable: searchable,
 	}, nil
 }
 
@@ -2,7 +2,11 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 
 	"gitlab.com/mycompany8201046/myProject/service/api/model"
 )
@@ -54,14 +58,15 @@ func checkUserInGroupWithTx(tx *HookedTx, groupId int64, user int64) (bool, erro
 	return false, nil
 }
 
-func (db *appdbimpl) CreateGroup(g_name string, usrId []int64) (res sql.Result, err error) {
+// CreateGroup creates a group with the users, and its conversation. The creator pins its messages.
+func (db *appdbimpl) CreateGroup(g_name string, usrId []int64, creatorId int64) (int64, int64, error) {
 	if len(usrId) < 3 {
-		return nil, errors.New("you need at least three users to create a new group")
+		return 0, 0, errors.New("you need at least three users to create a new group")
 	}
 
 	tx, err := db.BeginTx()
 	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return nil, err
+		return 0, 0, err
 	}
 	defer func() {
 		if err = tx.Rollback(); err != nil {
@@ -73,38 +78,37 @@ func (db *appdbimpl) CreateGroup(g_name string, usrId []int64) (res sql.Result,
 
 	var result sql.Result
 	var query string
-	var conv_id int64
 
-	conv_id, err = db.createConversationWithTx(tx, usrId)
+	convId, err := db.createConversationWithTx(tx, usrId)
 	if err != nil {
-		return nil, err
+		return 0, 0, err
 	}
 
-	query = "INSERT INTO GroupTB (ConvId,Name) VALUES($1,$2)"
-	result, err = tx.Exec(query, conv_id, g_name)
+	query = "INSERT INTO GroupTB (ConvId,Name,creatorId) VALUES($1,$2,$3)"
+	result, err = tx.Exec(query, convId, g_name, creatorId)
 	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return nil, err
+		return 0, 0, err
 	}
 
-	group_id, err := result.LastInsertId()
+	groupId, err := result.LastInsertId()
 	if err != nil {
-		return nil, err
+		return 0, 0, err
 	}
 
 	query = "INSERT INTO Group_User (groupId,userId) VALUES($1,$2)"
 	for _, i := range usrId {
-		result, err = tx.Exec(query, group_id, i)
+		_, err = tx.Exec(query, groupId, i)
 		if err != nil && !errors.Is(err, sql.ErrTxDone) {
-			return nil, err
+			return 0, 0, err
 		}
 	}
 
 	// Commit with hooks
 	if err = tx.Commit(); err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return nil, err
+		return 0, 0, err
 	}
 
-	return result, nil
+	return groupId, convId, nil
 }
 
 // Helper method to create conversation within existing transaction
@@ -155,20 +159,31 @@ func (db *appdbimpl) createConversationWithTx(tx *HookedTx, users []int64) (int6
 */
 
 func (db *appdbimpl) GetGroupInfo(groupId int64) *sql.Row {
-	query := "SELECT G.Name,IFNULL(G.Description,'')AS Description,IFNULL(photo,'./images/defaultPP.png') AS Photo FROM GroupTB AS G WHERE groupId = $1"
+	query := "SELECT G.Name,IFNULL(G.Description,'')AS Description,IFNULL(photo,'./images/defaultPP.png') AS Photo,IFNULL(G.creatorId,0) FROM GroupTB AS G WHERE groupId = $1"
 	return db.c.QueryRow(query, groupId)
 }
 
+// groupUsersQuery selects the members of the group $1 as (userId, userName, photo)
+const groupUsersQuery = "SELECT User.userId,User.userName, IFNULL(User.userPhoto,'') AS photo FROM Group_User INNER JOIN USER ON User.userId = Group_User.userId AND Group_User.groupId = $1"
+
 func (db *appdbimpl) GetUsersByGroup(groupId int64) (res *sql.Rows, err error) {
-	query := "SELECT User.userId,User.userName, IFNULL(User.userPhoto,'') AS photo FROM Group_User INNER JOIN USER ON User.userId = Group_User.userId AND Group_User.groupId = $1"
-	return db.c.Query(query, groupId)
+	return db.c.Query(groupUsersQuery, groupId)
 }
 
-func (db *appdbimpl) AddGroup(newUserIds []int64, grpId int64) (sql.Result, error) {
+func (db *appdbimpl) IsGroupMember(groupId int64, usrId int64) (bool, error) {
+	query := "SELECT COUNT(*) FROM Group_User WHERE groupId = $1 AND userId = $2"
+	var count int
+	err := db.c.QueryRow(query, groupId, usrId).Scan(&count)
+	return count > 0, err
+}
+
+// AddGroup adds the users to the group, and writes a system message from the actor in its conversation. It returns
+// the ids of the conversation and of the message.
+func (db *appdbimpl) AddGroup(newUserIds []int64, grpId int64, actorId int64) (int64, int64, error) {
 	tx, err := db.BeginTx()
 
 	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return nil, err
+		return 0, 0, err
 	}
 
 	defer func() {
@@ -179,43 +194,51 @@ func (db *appdbimpl) AddGroup(newUserIds []int64, grpId int64) (sql.Result, erro
 		}
 	}()
 
-	var result sql.Result
 	var convId int64
 	var is_in_group bool
 
 	convId, err = getConvIdWithTx(tx, grpId)
 	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return nil, err
+		return 0, 0, err
 	}
 
 	query := "INSERT INTO Conv_User (convId,usrId) VALUES($1,$2)"
 	for _, userId := range newUserIds {
 		is_in_group, err = checkUserInGroupWithTx(tx, grpId, userId)
 		if is_in_group {
-			return nil, errors.New("user: is in group")
+			return 0, 0, errors.New("user: is in group")
 		}
 		if err != nil && !errors.Is(err, sql.ErrTxDone) {
-			return nil, err
+			return 0, 0, err
 		}
 
-		result, err = tx.Exec(query, convId, userId)
+		_, err = tx.Exec(query, convId, userId)
 		if err != nil && !errors.Is(err, sql.ErrTxDone) {
-			return nil, err
+			return 0, 0, err
 		}
 
 		q := "INSERT INTO Group_User (groupId,userId) VALUES($1,$2)"
 		_, err = tx.Exec(q, grpId, userId)
 		if err != nil && !errors.Is(err, sql.ErrTxDone) {
-			return nil, err
+			return 0, 0, err
 		}
 	}
 
+	msgId, err := db.createSystemMessageWithTx(tx, convId, model.SystemPayload{
+		Action:  model.SystemMemberAdded,
+		ActorId: actorId,
+		UserIds: newUserIds,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
 	// Commit with hooks
 	if err = tx.Commit(); err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return nil, err
+		return 0, 0, err
 	}
 
-	return result, nil
+	return convId, msgId, nil
 }
 
 func (db *appdbimpl) GetGroupPhoto(groupId int64) (string, error) {
@@ -228,10 +251,12 @@ func (db *appdbimpl) GetGroupPhoto(groupId int64) (string, error) {
 	return p, nil
 }
 
-func (db *appdbimpl) LeaveGroup(usrId int64, grpId int64) (res sql.Result, err error) {
+// LeaveGroup removes the user from the group, and writes a system message from them in its conversation. It returns
+// the ids of the conversation and of the message.
+func (db *appdbimpl) LeaveGroup(usrId int64, grpId int64) (int64, int64, error) {
 	tx, err := db.BeginTx()
 	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return nil, err
+		return 0, 0, err
 	}
 	defer func() {
 		if err = tx.Rollback(); err != nil {
@@ -245,62 +270,77 @@ func (db *appdbimpl) LeaveGroup(usrId int64, grpId int64) (res sql.Result, err e
 
 	convId, err = getConvIdWithTx(tx, grpId)
 	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return nil, err
+		return 0, 0, err
 	}
 
 	query := "DELETE FROM Conv_User WHERE usrId = $1 AND convId = $2"
 	_, err = tx.Exec(query, usrId, convId)
 	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return nil, err
+		return 0, 0, err
 	}
 
 	query = "DELETE FROM Group_User WHERE userId = $1 AND groupId = $2"
-	result, err := tx.Exec(query, usrId, grpId)
+	_, err = tx.Exec(query, usrId, grpId)
+	if err != nil && !errors.Is(err, sql.ErrTxDone) {
+		return 0, 0, err
+	}
+
+	// The rights of the creator pass to the longest-standing member
+	query = `UPDATE GroupTB SET creatorId = (
+			SELECT userId FROM Group_User WHERE groupId = $1 ORDER BY rowid LIMIT 1)
+		WHERE groupId = $1 AND creatorId = $2`
+	_, err = tx.Exec(query, grpId, usrId)
 	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return nil, err
+		return 0, 0, err
+	}
+
+	msgId, err := db.createSystemMessageWithTx(tx, convId, model.SystemPayload{
+		Action:  model.SystemMemberLeft,
+		ActorId: usrId,
+	})
+	if err != nil {
+		return 0, 0, err
 	}
 
 	// Commit with hooks
 	if err = tx.Commit(); err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return nil, err
+		return 0, 0, err
 	}
 
-	return result, nil
+	return convId, msgId, nil
 }
 
-func (db *appdbimpl) SetGroupDesc(newDesc string, grpId int64) (res sql.Result, err error) {
-	tx, err := db.BeginTx()
-	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return nil, err
-	}
-	defer func() {
-		if err = tx.Rollback(); err != nil {
-			if errors.Is(err, sql.ErrTxDone) {
-				err = nil
-			}
-		}
-	}()
-
-	var result sql.Result
+// GetGroupCreator returns the id of the user who created the group, zero if it is not known.
+func (db *appdbimpl) GetGroupCreator(grpId int64) (int64, error) {
+	var creatorId int64
+	err := db.c.QueryRow("SELECT IFNULL(creatorId,0) FROM GroupTB WHERE groupId = $1", grpId).Scan(&creatorId)
+	return creatorId, err
+}
 
-	query := "UPDATE GroupTB SET Description = $1 WHERE groupId = $2"
-	result, err = tx.Exec(query, newDesc, grpId)
-	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return nil, err
-	}
+// SetGroupDesc changes the description of the group, and writes a system message from the actor in its conversation.
+// It returns the ids of the conversation and of the message, which is zero if the description did not change.
+func (db *appdbimpl) SetGroupDesc(newDesc string, grpId int64, actorId int64) (int64, int64, error) {
+	return db.updateGroup(grpId, actorId, "IFNULL(Description,'')", "Description", newDesc, model.SystemDescriptionChanged)
+}
 
-	// Commit with hooks
-	if err = tx.Commit(); err != nil {
-		return nil, err
-	}
+// SetGroupName changes the name of the group, and writes a system message from the actor in its conversation. It
+// returns the ids of the conversation and of the message, which is zero if the name did not change.
+func (db *appdbimpl) SetGroupName(newName string, grpId int64, actorId int64) (int64, int64, error) {
+	return db.updateGroup(grpId, actorId, "Name", "Name", newName, model.SystemGroupRenamed)
+}
 
-	return result, nil
+// SetGroupPhoto changes the photo of the group, and writes a system message from the actor in its conversation. It
+// returns the ids of the conversation and of the message.
+func (db *appdbimpl) SetGroupPhoto(pic model.Picture, grpId int64, actorId int64) (int64, int64, error) {
+	return db.updateGroup(grpId, actorId, "IFNULL(photo,'')", "photo", pic.Path, model.SystemPhotoChanged)
 }
 
-func (db *appdbimpl) SetGroupName(newName string, grpId int64) (res sql.Result, err error) {
+// updateGroup sets a column of GroupTB, selected as current, and records the change with a system message. The
+// column names are never user input.
+func (db *appdbimpl) updateGroup(grpId int64, actorId int64, current string, column string, value string, action string) (int64, int64, error) {
 	tx, err := db.BeginTx()
 	if err != nil {
-		return nil, err
+		return 0, 0, err
 	}
 	defer func() {
 		if err = tx.Rollback(); err != nil {
@@ -310,44 +350,92 @@ func (db *appdbimpl) SetGroupName(newName string, grpId int64) (res sql.Result,
 		}
 	}()
 
-	var result sql.Result
+	var convId int64
+	var oldValue string
+	err = tx.QueryRow("SELECT convId,"+current+" FROM GroupTB WHERE groupId = $1", grpId).Scan(&convId, &oldValue)
+	if err != nil {
+		return 0, 0, err
+	}
+	if oldValue == value {
+		return convId, 0, tx.Commit()
+	}
 
-	query := "UPDATE GroupTB SET Name = $1 WHERE groupId = $2"
-	result, err = tx.Exec(query, newName, grpId)
-	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return nil, err
+	_, err = tx.Exec("UPDATE GroupTB SET "+column+" = $1 WHERE groupId = $2", value, grpId)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	payload := model.SystemPayload{Action: action, ActorId: actorId}
+	// The photos are paths on the server, which the members have no use for
+	if action != model.SystemPhotoChanged {
+		payload.OldValue = oldValue
+		payload.NewValue = value
+	}
+	msgId, err := db.createSystemMessageWithTx(tx, convId, payload)
+	if err != nil {
+		return 0, 0, err
 	}
 
 	// Commit with hooks
 	if err = tx.Commit(); err != nil {
-		return nil, err
+		return 0, 0, err
 	}
 
-	return result, nil
+	return convId, msgId, nil
 }
 
-func (db *appdbimpl) SetGroupPhoto(pic model.Picture, grpId int64) (res sql.Result, err error) {
-	tx, err := db.BeginTx()
-	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return nil, err
+// createSystemMessageWithTx writes a system message from the actor of the payload. Its content is the text to show,
+// with the names the users have now.
+func (db *appdbimpl) createSystemMessageWithTx(tx *HookedTx, convId int64, payload model.SystemPayload) (int64, error) {
+	actor, err := userNameWithTx(tx, payload.ActorId)
+	if err != nil {
+		return 0, err
 	}
-	defer func() {
-		if err = tx.Rollback(); err != nil {
-			if errors.Is(err, sql.ErrTxDone) {
-				err = nil
+
+	var content string
+	switch payload.Action {
+	case model.SystemMemberAdded:
+		names := make([]string, 0, len(payload.UserIds))
+		for _, userId := range payload.UserIds {
+			name, err := userNameWithTx(tx, userId)
+			if err != nil {
+				return 0, err
 			}
+			names = append(names, name)
 		}
-	}()
-
-	q := "UPDATE GroupTB SET photo = $1 WHERE groupId = $2"
-	result, err := tx.Exec(q, pic.Path, grpId)
-	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return nil, err
+		content = actor + " added " + strings.Join(names, ", ")
+	case model.SystemMemberLeft:
+		content = actor + " left"
+	case model.SystemGroupRenamed:
+		content = fmt.Sprintf("%s changed the group name from %q to %q", actor, payload.OldValue, payload.NewValue)
+	case model.SystemDescriptionChanged:
+		content = actor + " changed the group description"
+	case model.SystemPhotoChanged:
+		content = actor + " changed the group photo"
+	case model.SystemLifetimeChanged:
+		lifetime, err := strconv.ParseInt(payload.NewValue, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		if lifetime == 0 {
+			content = actor + " turned off disappearing messages"
+		} else {
+			content = actor + " set disappearing messages to " + lifetimeLabel(lifetime)
+		}
+	default:
+		return 0, fmt.Errorf("unknown system message action %q", payload.Action)
 	}
-	// Commit with hooks
-	if err = tx.Commit(); err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return nil, err
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
 	}
+	message := model.MessageInput{Content: content, Type: model.MessageSystem, Payload: data}
+	return db.createMessageWithTx(tx, message, 0, payload.ActorId, convId)
+}
 
-	return result, nil
+func userNameWithTx(tx *HookedTx, usrId int64) (string, error) {
+	var name string
+	err := tx.QueryRow("SELECT userName FROM User WHERE userId = $1", usrId).Scan(&name)
+	return name, err
 }
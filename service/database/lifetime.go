@@ -0,0 +1,117 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+)
+
+// GetMessageLifetime returns how many seconds the new messages of the conversation last, zero if they do not
+// disappear.
+func (db *appdbimpl) GetMessageLifetime(convId int64) (int64, error) {
+	var lifetime int64
+	err := db.c.QueryRow("SELECT messageLifetime FROM Conversation WHERE conversationId = $1", convId).Scan(&lifetime)
+	return lifetime, err
+}
+
+// SetMessageLifetime changes how long the new messages of the conversation last, and writes a system message from the
+// actor. It returns the id of the message, zero if the lifetime was unchanged. The messages already sent keep their
+// expiry.
+func (db *appdbimpl) SetMessageLifetime(convId int64, lifetime int64, actorId int64) (int64, error) {
+	tx, err := db.BeginTx()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err = tx.Rollback(); err != nil {
+			if errors.Is(err, sql.ErrTxDone) {
+				err = nil
+			}
+		}
+	}()
+
+	var old int64
+	err = tx.QueryRow("SELECT messageLifetime FROM Conversation WHERE conversationId = $1", convId).Scan(&old)
+	if err != nil {
+		return 0, err
+	}
+	if old == lifetime {
+		return 0, tx.Commit()
+	}
+	_, err = tx.Exec("UPDATE Conversation SET messageLifetime = $1 WHERE conversationId = $2", lifetime, convId)
+	if err != nil {
+		return 0, err
+	}
+
+	msgId, err := db.createSystemMessageWithTx(tx, convId, model.SystemPayload{
+		Action:   model.SystemLifetimeChanged,
+		ActorId:  actorId,
+		OldValue: strconv.FormatInt(old, 10),
+		NewValue: strconv.FormatInt(lifetime, 10),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return msgId, nil
+}
+
+// GetExpiredMessages returns up to limit messages expired at now as (convId, messageId), the first to expire first.
+func (db *appdbimpl) GetExpiredMessages(now int64, limit int) (*sql.Rows, error) {
+	query := `SELECT convId,messageId FROM Message WHERE expiresAt <= $1
+		ORDER BY expiresAt
+		LIMIT $2`
+	return db.c.Query(query, now, limit)
+}
+
+// DeleteExpiredMessage deletes the message for good if it expired at now, with everything attached to it. The replies
+// to it are kept, without the reference. It returns false if the message is gone or has not expired.
+func (db *appdbimpl) DeleteExpiredMessage(msgId int64, convId int64, now int64) (bool, error) {
+	tx, err := db.BeginTx()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err = tx.Rollback(); err != nil {
+			if errors.Is(err, sql.ErrTxDone) {
+				err = nil
+			}
+		}
+	}()
+
+	// Replies reference the message with ON DELETE CASCADE, which would delete them too
+	query := "UPDATE Message SET repliedId = NULL, repliedConvId = NULL WHERE repliedId = $1 AND repliedConvId = $2"
+	if _, err = tx.Exec(query, msgId, convId); err != nil {
+		return false, err
+	}
+	res, err := tx.Exec("DELETE FROM Message WHERE messageId = $1 AND convId = $2 AND expiresAt <= $3", msgId, convId, now)
+	if err != nil {
+		return false, err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return deleted > 0, nil
+}
+
+// lifetimeLabel is the lifetime as shown in system messages.
+func lifetimeLabel(lifetime int64) string {
+	switch lifetime {
+	case model.LifetimeHour:
+		return "1 hour"
+	case model.LifetimeDay:
+		return "1 day"
+	case model.LifetimeWeek:
+		return "7 days"
+	}
+	return strconv.FormatInt(lifetime, 10) + " seconds"
+}
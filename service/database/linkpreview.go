@@ -0,0 +1,42 @@
+package database
+
+import (
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+)
+
+// linkPreviewColumn selects the preview of the link of a message as a JSON object, empty if it has none or its fetch
+// failed.
+const linkPreviewColumn = `IFNULL((SELECT json_object('url',L.url,'title',L.title,'description',L.description,
+	'image',L.imageUrl,'siteName',L.siteName)
+	FROM LinkPreview AS L WHERE L.url = Message.linkUrl AND L.ok),'')`
+
+// SetMessageLink sets the link previewed in the message, or removes it if url is empty.
+func (db *appdbimpl) SetMessageLink(msgId int64, convId int64, url string) error {
+	_, err := db.c.Exec("UPDATE Message SET linkUrl = NULLIF($1,'') WHERE messageId = $2 AND convId = $3", url, msgId, convId)
+	return err
+}
+
+// GetLinkPreviewFetchedAt returns when the preview of the link was last fetched, and whether that worked. It returns
+// sql.ErrNoRows if the link was never fetched.
+func (db *appdbimpl) GetLinkPreviewFetchedAt(url string) (int64, bool, error) {
+	var fetchedAt int64
+	var ok bool
+	err := db.c.QueryRow("SELECT fetchedAt, ok FROM LinkPreview WHERE url = $1", url).Scan(&fetchedAt, &ok)
+	return fetchedAt, ok, err
+}
+
+// SaveLinkPreview stores the preview of the link fetched at fetchedAt. A failed fetch, with ok false, only records
+// the attempt: the preview fetched before, if any, is kept.
+func (db *appdbimpl) SaveLinkPreview(preview model.LinkPreview, ok bool, fetchedAt int64) error {
+	if !ok {
+		query := `INSERT INTO LinkPreview (url,fetchedAt,ok) VALUES($1,$2,0)
+			ON CONFLICT(url) DO UPDATE SET fetchedAt = excluded.fetchedAt`
+		_, err := db.c.Exec(query, preview.Url, fetchedAt)
+		return err
+	}
+	query := `INSERT INTO LinkPreview (url,title,description,imageUrl,siteName,fetchedAt,ok) VALUES($1,$2,$3,$4,$5,$6,1)
+		ON CONFLICT(url) DO UPDATE SET title = excluded.title, description = excluded.description,
+		imageUrl = excluded.imageUrl, siteName = excluded.siteName, fetchedAt = excluded.fetchedAt, ok = 1`
+	_, err := db.c.Exec(query, preview.Url, preview.Title, preview.Description, preview.Image, preview.SiteName, fetchedAt)
+	return err
+}
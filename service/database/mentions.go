@@ -0,0 +1,179 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+)
+
+// mentionAll is the name mentioning every member of the group, which only its creator can use
+const mentionAll = "all"
+
+// mentionable is a member of the group a message can mention.
+type mentionable struct {
+	userId int64
+	name   string
+}
+
+// mentionWithTx finds the @mentions in the content of a message, stores their spans in the message and a row for each
+// user mentioned but the sender. Mentions are resolved against the members of the group of the conversation: in a
+// chat there are none. The mentions the message had before, if it is being edited, are replaced.
+func mentionWithTx(tx *HookedTx, msgId int64, convId int64, senderId int64, content string) error {
+	_, err := tx.Exec("DELETE FROM Mention WHERE messageId = $1 AND convId = $2", msgId, convId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE Message SET mentions = NULL WHERE messageId = $1 AND convId = $2", msgId, convId)
+	if err != nil || !strings.Contains(content, "@") {
+		return err
+	}
+
+	var grpId, creatorId int64
+	err = tx.QueryRow("SELECT groupId, IFNULL(creatorId,0) FROM GroupTB WHERE convId = $1", convId).Scan(&grpId, &creatorId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	rows, err := tx.Query(groupUsersQuery, grpId)
+	if err != nil {
+		return err
+	}
+	var members []mentionable
+	for rows.Next() {
+		var member mentionable
+		var photo string
+		if err = rows.Scan(&member.userId, &member.name, &photo); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		members = append(members, member)
+	}
+	if err = rows.Err(); err != nil {
+		_ = rows.Close()
+		return err
+	}
+	_ = rows.Close()
+
+	mentions := findMentions(content, members, creatorId == senderId)
+	if len(mentions) == 0 {
+		return nil
+	}
+	spans, err := json.Marshal(mentions)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE Message SET mentions = $1 WHERE messageId = $2 AND convId = $3", string(spans), msgId, convId)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT OR IGNORE INTO Mention (userId,convId,messageId,createdAt) VALUES($1,$2,$3,unixepoch())`
+	for _, member := range members {
+		if member.userId != senderId && mentioned(mentions, member.userId) {
+			if _, err = tx.Exec(query, member.userId, convId, msgId); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mentioned tells if the mentions include the user, or everybody.
+func mentioned(mentions []model.Mention, usrId int64) bool {
+	for _, mention := range mentions {
+		if mention.All || mention.UserId == usrId {
+			return true
+		}
+	}
+	return false
+}
+
+// findMentions returns the spans of the content mentioning one of the members, or everybody if all is set. A mention
+// is "@" followed by the name of a member, case insensitive, neither preceded nor followed by a letter or a digit; when
+// more names match the longest wins, and @all always means everybody.
+func findMentions(content string, members []mentionable, all bool) []model.Mention {
+	var mentions []model.Mention
+	prev := ' '
+	offset := 0
+	for i := 0; i < len(content); {
+		r, size := utf8.DecodeRuneInString(content[i:])
+		if r == '@' && !isNameRune(prev) {
+			if mention, n, ok := matchMention(content[i+size:], members, all); ok {
+				mention.Offset = offset
+				mention.Length = utf16Len(content[i : i+size+n])
+				mentions = append(mentions, mention)
+				offset += mention.Length
+				i += size + n
+				prev, _ = utf8.DecodeLastRuneInString(content[:i])
+				continue
+			}
+		}
+		offset += utf16Len(content[i : i+size])
+		i += size
+		prev = r
+	}
+	return mentions
+}
+
+// matchMention returns the mention s starts with, and its length in bytes.
+func matchMention(s string, members []mentionable, all bool) (model.Mention, int, bool) {
+	if all && hasName(s, mentionAll) {
+		return model.Mention{All: true}, len(mentionAll), true
+	}
+	best := -1
+	for i, member := range members {
+		if hasName(s, member.name) && (best < 0 || len(member.name) > len(members[best].name)) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return model.Mention{}, 0, false
+	}
+	return model.Mention{UserId: members[best].userId}, len(members[best].name), true
+}
+
+// hasName tells if s starts with the name as a whole word.
+func hasName(s string, name string) bool {
+	if name == "" || len(s) < len(name) || !strings.EqualFold(s[:len(name)], name) {
+		return false
+	}
+	next, _ := utf8.DecodeRuneInString(s[len(name):])
+	return len(s) == len(name) || !isNameRune(next)
+}
+
+func isNameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// utf16Len returns the length of s in UTF-16 code units, the way JavaScript counts the length of strings.
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n++
+		if r >= 0x10000 {
+			n++
+		}
+	}
+	return n
+}
+
+// GetMentions returns up to limit unread messages mentioning the user before the (createdAt, mentionId) cursor, as
+// (mentionId, createdAt, convId, messageId), the most recent first. A mention is read with its message.
+func (db *appdbimpl) GetMentions(usrId int64, createdAt int64, mentionId int64, limit int) (*sql.Rows, error) {
+	query := `SELECT N.mentionId,N.createdAt,N.convId,N.messageId FROM Mention AS N
+		JOIN Message AS M ON M.messageId = N.messageId AND M.convId = N.convId
+		LEFT JOIN ReadMarker AS K ON K.convId = N.convId AND K.userId = N.userId
+		WHERE N.userId = $1 AND (N.createdAt < $2 OR (N.createdAt = $2 AND N.mentionId < $3))
+		AND (K.userId IS NULL OR (M.mtime, M.messageId) > (K.lastReadMtime, K.lastReadMessageId))
+		AND NOT EXISTS (SELECT 1 FROM MessageReadStatus AS R
+			WHERE R.messageId = N.messageId AND R.convId = N.convId AND R.userId = $1)
+		ORDER BY N.createdAt DESC, N.mentionId DESC
+		LIMIT $4`
+	return db.c.Query(query, usrId, createdAt, mentionId, limit)
+}
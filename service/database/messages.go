@@ -2,21 +2,39 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 
+	"gitlab.com/mycompany8201046/myProject/service/api/globaltime"
 	"gitlab.com/mycompany8201046/myProject/service/api/model"
 )
 
 func (db *appdbimpl) GetMessage(msgId int64, convId int64) *sql.Row {
-	q := "SELECT messageId,content,mtime,usrSenderId,convId,IFNULL(photoId,-1),IFNULL(repliedId,-1),IFNULL(repliedConvId,-1) FROM Message WHERE messageId=$1 AND convId=$2"
+	q := "SELECT messageId,content,mtime,usrSenderId,convId,IFNULL(photoId,-1),IFNULL(repliedId,-1),IFNULL(repliedConvId,-1),IFNULL(editedAt,0),type,IFNULL(payload,''),IFNULL(expiresAt,0),IFNULL(deletedAt,0),IFNULL(mentions,'')," + linkPreviewColumn +
+		" FROM Message WHERE messageId=$1 AND convId=$2"
 	res := db.c.QueryRow(q, msgId, convId)
 	return res
 }
 
-func (db *appdbimpl) CreateMessage(message model.MessageInput, photoId int64, usrId int64, convId int64) (val int64, err error) {
+func (db *appdbimpl) GetMessageSender(msgId int64, convId int64) (int64, error) {
+	q := "SELECT usrSenderId FROM Message WHERE messageId=$1 AND convId=$2"
+	var senderId int64
+	err := db.c.QueryRow(q, msgId, convId).Scan(&senderId)
+	return senderId, err
+}
+
+// GetMessageType returns the type of the message.
+func (db *appdbimpl) GetMessageType(msgId int64, convId int64) (string, error) {
+	var msgType string
+	err := db.c.QueryRow("SELECT type FROM Message WHERE messageId=$1 AND convId=$2", msgId, convId).Scan(&msgType)
+	return msgType, err
+}
+
+// EditMessage replaces the content of the message, saving the previous version in the MessageEdit table.
+func (db *appdbimpl) EditMessage(msgId int64, convId int64, content string, editedAt int64) error {
 	tx, err := db.BeginTx()
-	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return 0, err
+	if err != nil {
+		return err
 	}
 	defer func() {
 		if err = tx.Rollback(); err != nil {
@@ -25,26 +43,55 @@ func (db *appdbimpl) CreateMessage(message model.MessageInput, photoId int64, us
 			}
 		}
 	}()
-	// Will be ignored if commit succeeds
 
-	// * Needed to emulate AUTOINCREMENT Feature ON Message TABLE
-	var next_id int64
-	var query string
-	next_id_q := "SELECT COALESCE(MAX(messageId) + 1, 1) FROM Message WHERE convId = $1"
-	err = tx.QueryRow(next_id_q, convId).Scan(&next_id)
+	// The version being replaced was written when the message was sent or last edited
+	var oldContent string
+	var writtenAt, senderId int64
+	q := "SELECT content,IFNULL(editedAt,mtime),usrSenderId FROM Message WHERE messageId = $1 AND convId = $2"
+	err = tx.QueryRow(q, msgId, convId).Scan(&oldContent, &writtenAt, &senderId)
+	if err != nil {
+		return err
+	}
 
-	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return next_id, err
+	q = "INSERT INTO MessageEdit (messageId,convId,content,writtenAt,replacedAt) VALUES($1,$2,$3,$4,$5)"
+	_, err = tx.Exec(q, msgId, convId, oldContent, writtenAt, editedAt)
+	if err != nil {
+		return err
 	}
 
-	if photoId > 0 {
-		query = "INSERT INTO Message (messageId,content,mtime,usrSenderId,convId,photoId,repliedId,repliedConvId) VALUES($1,$2,unixepoch(),$3,$4,$5,$6,$7);"
-		_, err = tx.Exec(query, next_id, message.Content, usrId, convId, photoId, message.RepliedId, message.RepliedConvId)
-	} else {
-		query = "INSERT INTO Message (messageId,content,mtime,usrSenderId,convId,repliedId,repliedConvId) VALUES($1,$2,unixepoch(),$3,$4,$5,$6);"
-		_, err = tx.Exec(query, next_id, message.Content, usrId, convId, message.RepliedId, message.RepliedConvId)
+	q = "UPDATE Message SET content = $1, editedAt = $2 WHERE messageId = $3 AND convId = $4"
+	_, err = tx.Exec(q, content, editedAt, msgId, convId)
+	if err != nil {
+		return err
+	}
+	if err = mentionWithTx(tx, msgId, convId, senderId, content); err != nil {
+		return err
+	}
 
+	return tx.Commit()
+}
+
+// GetMessageEdits returns the previous versions of the message, oldest first.
+func (db *appdbimpl) GetMessageEdits(msgId int64, convId int64) (*sql.Rows, error) {
+	q := "SELECT content,writtenAt,replacedAt FROM MessageEdit WHERE messageId = $1 AND convId = $2 ORDER BY replacedAt,editId"
+	return db.c.Query(q, msgId, convId)
+}
+
+func (db *appdbimpl) CreateMessage(message model.MessageInput, photoId int64, usrId int64, convId int64) (val int64, err error) {
+	tx, err := db.BeginTx()
+	if err != nil && !errors.Is(err, sql.ErrTxDone) {
+		return 0, err
 	}
+	defer func() {
+		if err = tx.Rollback(); err != nil {
+			if errors.Is(err, sql.ErrTxDone) {
+				err = nil
+			}
+		}
+	}()
+	// Will be ignored if commit succeeds
+
+	next_id, err := db.createMessageWithTx(tx, message, photoId, usrId, convId)
 	if err != nil && !errors.Is(err, sql.ErrTxDone) {
 		return 0, err
 	}
@@ -57,10 +104,10 @@ func (db *appdbimpl) CreateMessage(message model.MessageInput, photoId int64, us
 	return next_id, nil
 }
 
-func (db *appdbimpl) ForwardMessage(ogMessageId int64, ogConvId int64, usrId int64, newConvId int64) (res sql.Result, err error) {
+func (db *appdbimpl) ForwardMessage(ogMessageId int64, ogConvId int64, usrId int64, newConvId int64) (int64, int64, error) {
 	tx, err := db.BeginTx()
 	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return nil, err
+		return 0, 0, err
 	}
 	defer func() {
 		if err = tx.Rollback(); err != nil {
@@ -71,106 +118,134 @@ func (db *appdbimpl) ForwardMessage(ogMessageId int64, ogConvId int64, usrId int
 	}()
 
 	// Here we take the contents of the original message and we copy them over to the new message
-	var messageContent string
+	var message model.MessageInput
 	var photoId int64
-	q := "SELECT content,IFNULL(photoId,-1) FROM Message WHERE messageId = $1 and convId = $2"
-	e := tx.QueryRow(q, ogMessageId, ogConvId).Scan(&messageContent, &photoId)
+	var payload string
+	q := "SELECT content,IFNULL(photoId,-1),type,IFNULL(payload,'') FROM Message WHERE messageId = $1 and convId = $2"
+	e := tx.QueryRow(q, ogMessageId, ogConvId).Scan(&message.Content, &photoId, &message.Type, &payload)
 	if e != nil && !errors.Is(err, sql.ErrTxDone) {
-		return nil, e
+		return 0, 0, e
 	}
+	message.Payload = []byte(payload)
 	if newConvId < 0 {
 		var userArray = []int64{usrId, -newConvId}
 
 		newConvId, err = db.createConversationWithTx(tx, userArray)
 		if err != nil && !errors.Is(err, sql.ErrTxDone) {
-			return nil, err
+			return 0, 0, err
 		}
 	}
 
 	// We create the new message using transaction
-	_, err = db.createMessageWithTx(tx, messageContent, photoId, usrId, newConvId, 0, 0)
+	msgId, err := db.createMessageWithTx(tx, message, photoId, usrId, newConvId)
 	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return nil, err
+		return 0, 0, err
 	}
 
 	if err = tx.Commit(); err != nil {
-		return nil, err
+		return 0, 0, err
 	}
 
-	return nil, nil
+	return newConvId, msgId, nil
 }
 
 // Helper method to create message within existing transaction
-func (db *appdbimpl) createMessageWithTx(tx *HookedTx, messageContent string, photoId int64, usrId int64, convId int64, replId int64, replConvId int64) (int64, error) {
+func (db *appdbimpl) createMessageWithTx(tx *HookedTx, message model.MessageInput, photoId int64, usrId int64, convId int64) (int64, error) {
 	var next_id int64
 	var query string
-	next_id_q := "SELECT COALESCE(MAX(messageId) + 1, 1) FROM Message WHERE convId = $1"
+	next_id_q := "UPDATE Conversation SET nextMessageId = nextMessageId + 1 WHERE conversationId = $1 RETURNING nextMessageId - 1"
 	err := tx.QueryRow(next_id_q, convId).Scan(&next_id)
 
 	if err != nil && !errors.Is(err, sql.ErrTxDone) {
 		return next_id, err
 	}
+	if message.Type == "" {
+		message.Type = model.MessageText
+	}
+	// Messages sent while disappearing messages are on expire; the notices about the conversation itself do not
+	var lifetime, expiresAt int64
+	if message.Type != model.MessageSystem {
+		err = tx.QueryRow("SELECT messageLifetime FROM Conversation WHERE conversationId = $1", convId).Scan(&lifetime)
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			return next_id, err
+		}
+	}
+	if lifetime > 0 {
+		expiresAt = globaltime.Now().Unix() + lifetime
+	}
 
 	if photoId > 0 {
-		query = "INSERT INTO Message (messageId,content,mtime,usrSenderId,convId,photoId,repliedId,repliedConvId) VALUES($1,$2,unixepoch(),$3,$4,$5,$6,$7);"
-		_, err = tx.Exec(query, next_id, messageContent, usrId, convId, photoId, replId, replConvId)
+		query = "INSERT INTO Message (messageId,content,mtime,usrSenderId,convId,photoId,repliedId,repliedConvId,type,payload,expiresAt) VALUES($1,$2,unixepoch(),$3,$4,$5,NULLIF($6,0),NULLIF($7,0),$8,NULLIF($9,''),NULLIF($10,0));"
+		_, err = tx.Exec(query, next_id, message.Content, usrId, convId, photoId, message.RepliedId, message.RepliedConvId, message.Type, string(message.Payload), expiresAt)
 
 	} else {
-		query = "INSERT INTO Message (messageId,content,mtime,usrSenderId,convId,repliedId,repliedConvId) VALUES($1,$2,unixepoch(),$3,$4,$5,$6);"
-		_, err = tx.Exec(query, next_id, messageContent, usrId, convId, replId, replConvId)
+		query = "INSERT INTO Message (messageId,content,mtime,usrSenderId,convId,repliedId,repliedConvId,type,payload,expiresAt) VALUES($1,$2,unixepoch(),$3,$4,NULLIF($5,0),NULLIF($6,0),$7,NULLIF($8,''),NULLIF($9,0));"
+		_, err = tx.Exec(query, next_id, message.Content, usrId, convId, message.RepliedId, message.RepliedConvId, message.Type, string(message.Payload), expiresAt)
 
 	}
+	if err == nil && message.Type == model.MessagePoll {
+		err = createPollWithTx(tx, next_id, convId, message.Payload)
+	}
+	if err == nil && message.Type != model.MessageSystem {
+		err = mentionWithTx(tx, next_id, convId, usrId, message.Content)
+	}
 	if errors.Is(err, sql.ErrTxDone) {
 		err = nil
 	}
 	return next_id, err
 }
 
-func (db *appdbimpl) HasMessageBeenRead(msgId int64, convId int64, userId int64) (bool, error) {
-	var hasBeenread uint8
-	query := `SELECT 
-            (SELECT COUNT(DISTINCT "userId") FROM MessageReadStatus 
-             WHERE messageId = $1 AND convId = $2) = 
-            (SELECT COUNT(DISTINCT "usrId") FROM Conv_User 
-             WHERE convId = $2 AND usrId NOT IN 
-                (SELECT usrSenderId FROM Message 
-                 WHERE messageId = $1 AND convId = $2))`
-	err := db.c.QueryRow(query, msgId, convId).Scan(&hasBeenread)
-	if err != nil {
-		return false, err
-	}
-	return hasBeenread != 0, nil
+// GetReceipts returns, for every recipient of the message (the members of the conversation but the sender), the
+// time it was delivered and read, zero if it has not been yet, as (userId, userName, userPhoto, deliveredAt, readAt).
+// Readers come first, in the order they read the message. No rows are returned if the message does not exist.
+func (db *appdbimpl) GetReceipts(msgId int64, convId int64) (*sql.Rows, error) {
+	query := `SELECT U.userId, U.userName, IFNULL(U.userPhoto,'./images/defaultPP.png'),
+			IFNULL(D.deliveredAt, IFNULL(R.readTime, 0)), IFNULL(R.readTime, 0)
+		FROM Message AS M
+		JOIN Conv_User AS CU ON CU.convId = M.convId AND CU.usrId != M.usrSenderId
+		JOIN User AS U ON U.userId = CU.usrId
+		LEFT JOIN MessageDelivery AS D ON D.messageId = M.messageId AND D.convId = M.convId AND D.userId = CU.usrId
+		LEFT JOIN MessageReadStatus AS R ON R.messageId = M.messageId AND R.convId = M.convId AND R.userId = CU.usrId
+		WHERE M.messageId = $1 AND M.convId = $2
+		ORDER BY R.readTime IS NULL, R.readTime, U.userName`
+	return db.c.Query(query, msgId, convId)
 }
 
-func (db *appdbimpl) WhoHasReadMessage(msgId int64, convId int64, userId int64) (*sql.Rows, error) {
-	query := `SELECT userId FROM MessageReadStatus WHERE messageId = $1 AND convId = $2`
-	rows, err := db.c.Query(query, msgId, convId)
+// MarkDelivered records that the messages were delivered to the user, unless they were already or the user sent
+// them. It returns the ids of the messages delivered now.
+func (db *appdbimpl) MarkDelivered(convId int64, usrId int64, msgIds []int64, now int64) ([]int64, error) {
+	var delivered []int64
+	tx, err := db.BeginTx()
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		if err = tx.Rollback(); err != nil {
+			if errors.Is(err, sql.ErrTxDone) {
+				err = nil
+			}
+		}
+	}()
 
-	return rows, nil
-}
+	query := `INSERT OR IGNORE INTO MessageDelivery (messageId,convId,userId,deliveredAt)
+		SELECT M.messageId, M.convId, U.userId, U.now FROM (SELECT $1 AS userId, $2 AS now) AS U
+		JOIN Message AS M ON M.messageId = $3 AND M.convId = $4 AND M.usrSenderId != U.userId`
+	for _, msgId := range msgIds {
+		res, err := tx.Exec(query, usrId, now, msgId, convId)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return nil, err
+		} else if n > 0 {
+			delivered = append(delivered, msgId)
+		}
+	}
 
-func (db *appdbimpl) WhoHasNotReadMessage(msgId int64, convId int64, userId int64) (*sql.Rows, error) {
-	query := `SELECT "usrId" FROM "Conv_User"
-        WHERE "convId" = $1
-        AND "usrId" NOT IN (
-            -- Users who have read the message
-            SELECT "userId" FROM "MessageReadStatus"
-            WHERE "messageId" = $2 AND "convId" = $1
-        )
-        AND "usrId" != (
-            -- The message sender
-            SELECT "usrSenderId" FROM "Message"
-            WHERE "messageId" = $2 AND "convId" = $1
-        )`
-	rows, err := db.c.Query(query, convId, msgId)
-	if err != nil {
+	if err = tx.Commit(); err != nil {
 		return nil, err
 	}
-
-	return rows, nil
+	return delivered, nil
 }
 
 func (db *appdbimpl) ReadMessage(msgId int64, convId int64, userId int64) error {
@@ -186,7 +261,15 @@ func (db *appdbimpl) ReadMessage(msgId int64, convId int64, userId int64) error
 		}
 	}()
 
-	query := "INSERT OR IGNORE INTO MessageReadStatus (messageId,convId,userId,readTime) VALUES($1,$2,$3,unixepoch())"
+	// Reading implies receiving, for messages read before being fetched
+	query := `INSERT OR IGNORE INTO MessageDelivery (messageId,convId,userId,deliveredAt)
+		SELECT messageId, convId, $1, unixepoch() FROM Message WHERE usrSenderId != $1 AND messageId = $2 AND convId = $3`
+	_, err = tx.Exec(query, userId, msgId, convId)
+	if err != nil && !errors.Is(err, sql.ErrTxDone) {
+		return err
+	}
+
+	query = "INSERT OR IGNORE INTO MessageReadStatus (messageId,convId,userId,readTime) VALUES($1,$2,$3,unixepoch())"
 	_, err = tx.Exec(query, msgId, convId, userId)
 	if err != nil && !errors.Is(err, sql.ErrTxDone) {
 		return err
@@ -195,10 +278,13 @@ func (db *appdbimpl) ReadMessage(msgId int64, convId int64, userId int64) error
 	return tx.Commit()
 }
 
-func (db *appdbimpl) DeleteMessage(msgId int64, convId int64) (res sql.Result, err error) {
+// MarkConversationRead moves the read marker of the user in the conversation forward to the message, and marks every
+// message up to it as delivered and read. It returns false if the marker was already there or further, and
+// sql.ErrNoRows if the message does not exist.
+func (db *appdbimpl) MarkConversationRead(convId int64, usrId int64, msgId int64, now int64) (bool, error) {
 	tx, err := db.BeginTx()
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 	defer func() {
 		if err = tx.Rollback(); err != nil {
@@ -208,60 +294,56 @@ func (db *appdbimpl) DeleteMessage(msgId int64, convId int64) (res sql.Result, e
 		}
 	}()
 
-	query := "DELETE FROM Message WHERE messageId=$1 AND convId = $2;"
-	result, err := tx.Exec(query, msgId, convId)
-	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return nil, err
+	var mtime int64
+	err = tx.QueryRow("SELECT mtime FROM Message WHERE messageId = $1 AND convId = $2", msgId, convId).Scan(&mtime)
+	if err != nil {
+		return false, err
 	}
 
-	if err = tx.Commit(); err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return nil, err
+	// The marker goes first, so that the change log triggers skip the messages it covers
+	query := `INSERT INTO ReadMarker (convId,userId,lastReadMessageId,lastReadMtime,updatedAt) VALUES($1,$2,$3,$4,$5)
+		ON CONFLICT(convId,userId) DO UPDATE SET lastReadMessageId = excluded.lastReadMessageId,
+		lastReadMtime = excluded.lastReadMtime, updatedAt = excluded.updatedAt
+		WHERE (excluded.lastReadMtime, excluded.lastReadMessageId) > (ReadMarker.lastReadMtime, ReadMarker.lastReadMessageId)`
+	res, err := tx.Exec(query, convId, usrId, msgId, mtime, now)
+	if err != nil {
+		return false, err
 	}
-
-	return result, nil
-}
-
-func CommentCheckUser(db *appdbimpl, msgId int64, convId int64, userId int64) (val int64, a bool, err error) {
-	var commentId int64
-	q := "SELECT commentId FROM Comment WHERE msgId= $1 AND convId = $2 AND userId = $3"
-	err = db.c.QueryRow(q, msgId, convId, userId).Scan(&commentId)
-	if errors.Is(err, sql.ErrNoRows) {
-		return 0, false, nil
-	} else if err != nil {
-		return 0, false, err
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
 	}
-
-	return commentId, commentId != 0, nil
-}
-
-func UpdateComment(db *appdbimpl, userId int64, msgId int64, convId int64, commentContent string, commentId int64) (err error) {
-	tx, err := db.BeginTx()
-	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return err
+	if n == 0 {
+		return false, tx.Commit()
 	}
-	defer func() {
-		if err = tx.Rollback(); err != nil {
-			if errors.Is(err, sql.ErrTxDone) {
-				err = nil
-			}
-		}
-	}()
 
-	q := `UPDATE Comment AS C
-		  SET content = $1
-		  WHERE  C.userId = $2 AND C.convId=$3 AND C.msgId = $4 AND C.commentId = $5`
-	_, err = tx.Exec(q, commentContent, userId, convId, msgId, commentId)
-	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return err
+	query = `INSERT OR IGNORE INTO MessageDelivery (messageId,convId,userId,deliveredAt)
+		SELECT messageId, convId, $1, $2 FROM Message
+		WHERE usrSenderId != $1 AND convId = $3 AND (mtime, messageId) <= ($4, $5)`
+	if _, err = tx.Exec(query, usrId, now, convId, mtime, msgId); err != nil {
+		return false, err
+	}
+	query = `INSERT OR IGNORE INTO MessageReadStatus (messageId,convId,userId,readTime)
+		SELECT messageId, convId, $1, $2 FROM Message
+		WHERE usrSenderId != $1 AND convId = $3 AND (mtime, messageId) <= ($4, $5)`
+	if _, err = tx.Exec(query, usrId, now, convId, mtime, msgId); err != nil {
+		return false, err
 	}
 
-	return tx.Commit()
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
-func (db *appdbimpl) RemoveComment(userId int64, convId int64, messageId int64) (err error) {
+// DeleteMessage deletes the message for everyone, leaving a tombstone in its place: the row stays, so that the order
+// of the conversation, the replies to it and the conversation itself are kept, but its content, reactions, edits,
+// poll, pins and stars are gone. It returns false if the message was already deleted, and sql.ErrNoRows if it does
+// not exist.
+func (db *appdbimpl) DeleteMessage(msgId int64, convId int64, now int64) (bool, error) {
 	tx, err := db.BeginTx()
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer func() {
 		if err = tx.Rollback(); err != nil {
@@ -271,47 +353,48 @@ func (db *appdbimpl) RemoveComment(userId int64, convId int64, messageId int64)
 		}
 	}()
 
-	q := `DELETE FROM Comment AS C
-		  WHERE C.userId = $1 AND C.convId=$2 AND C.msgId = $3 `
-	_, err = tx.Exec(q, userId, convId, messageId)
-	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return err
+	var deletedAt int64
+	err = tx.QueryRow("SELECT IFNULL(deletedAt,0) FROM Message WHERE messageId = $1 AND convId = $2", msgId, convId).Scan(&deletedAt)
+	if err != nil {
+		return false, err
 	}
-
-	return tx.Commit()
-}
-
-func (db *appdbimpl) GetFinalComment(msgId int64, convId int64) ([]model.Comment, error) {
-	var commentList []model.Comment
-	var tmp model.Comment
-
-	q := "SELECT * FROM Comment WHERE msgId= $1 AND convId = $2"
-	rows, err := db.c.Query(q, msgId, convId)
-	if errors.Is(err, sql.ErrNoRows) {
-		return commentList, nil
-	} else if err != nil {
-		return commentList, err
+	if deletedAt != 0 {
+		return false, tx.Commit()
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		err = rows.Scan(&tmp.Id, &tmp.MessageId, &tmp.ConversationId, &tmp.UserId, &tmp.UserName, &tmp.Content)
-		if err != nil {
-			return commentList, err
+	stmts := []string{
+		"DELETE FROM Reaction WHERE msgId = $1 AND convId = $2",
+		"DELETE FROM MessageEdit WHERE messageId = $1 AND convId = $2",
+		"DELETE FROM PollVote WHERE messageId = $1 AND convId = $2",
+		"DELETE FROM Poll WHERE messageId = $1 AND convId = $2",
+		"DELETE FROM PinnedMessage WHERE messageId = $1 AND convId = $2",
+		"DELETE FROM StarredMessage WHERE messageId = $1 AND convId = $2",
+		"DELETE FROM Mention WHERE messageId = $1 AND convId = $2",
+	}
+	for _, stmt := range stmts {
+		if _, err = tx.Exec(stmt, msgId, convId); err != nil {
+			return false, err
 		}
-		commentList = append(commentList, tmp)
+	}
+	query := `UPDATE Message SET deletedAt = $1, content = '', type = $2, payload = NULL, photoId = NULL, editedAt = NULL,
+		expiresAt = NULL, mentions = NULL, linkUrl = NULL
+		WHERE messageId = $3 AND convId = $4`
+	if _, err = tx.Exec(query, now, model.MessageDeleted, msgId, convId); err != nil {
+		return false, err
 	}
 
-	if err = rows.Err(); err != nil {
-		return commentList, err
+	if err = tx.Commit(); err != nil {
+		return false, err
 	}
-	return commentList, nil
+	return true, nil
 }
 
-func (db *appdbimpl) CommentMessage(msgId int64, convId int64, usrId int64, commentContent string) (val int64, err error) {
+// HideMessage hides the message for the user only, removing their star from it. It returns false if it was already
+// hidden.
+func (db *appdbimpl) HideMessage(usrId int64, convId int64, msgId int64, now int64) (bool, error) {
 	tx, err := db.BeginTx()
 	if err != nil {
-		return 0, err
+		return false, err
 	}
 	defer func() {
 		if err = tx.Rollback(); err != nil {
@@ -321,57 +404,42 @@ func (db *appdbimpl) CommentMessage(msgId int64, convId int64, usrId int64, comm
 		}
 	}()
 
-	var ogId int64
-	q := "SELECT COALESCE(MAX(commentId)+1,1) FROM Comment WHERE msgId = $1 and convId = $2"
-	err = tx.QueryRow(q, msgId, convId).Scan(&ogId)
-	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return 0, err
+	query := "INSERT OR IGNORE INTO HiddenMessage (userId,convId,messageId,hiddenAt) VALUES($1,$2,$3,$4)"
+	res, err := tx.Exec(query, usrId, convId, msgId, now)
+	if err != nil {
+		return false, err
 	}
-
-	cid, condition, err := CommentCheckUser(db, msgId, convId, usrId)
-	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return 0, err
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
 	}
-
-	if condition {
-		// Update existing comment
-		updateQ := `UPDATE Comment AS C
-			  SET content = $1
-			  WHERE  C.userId = $2 AND C.convId=$3 AND C.msgId = $4 AND C.commentId = $5`
-		_, err = tx.Exec(updateQ, commentContent, usrId, convId, msgId, cid)
-		if err != nil && !errors.Is(err, sql.ErrTxDone) {
-			return 0, err
+	for _, query = range []string{
+		"DELETE FROM StarredMessage WHERE userId = $1 AND convId = $2 AND messageId = $3",
+		"DELETE FROM Mention WHERE userId = $1 AND convId = $2 AND messageId = $3",
+	} {
+		if _, err = tx.Exec(query, usrId, convId, msgId); err != nil {
+			return false, err
 		}
-
-		if err = tx.Commit(); err != nil && !errors.Is(err, sql.ErrTxDone) {
-			return 0, err
-		}
-		return cid, nil
 	}
 
-	// Create new comment
-	q = "INSERT INTO Comment (commentId,msgId,convId,userId,userName,content) VALUES($1,$2,$3,$4,$5,$6)"
-	name, err := db.GetUserName(usrId)
-	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return 0, err
-	}
-
-	_, err = tx.Exec(q, ogId, msgId, convId, usrId, name, commentContent)
-	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return 0, err
-	}
-
-	if err = tx.Commit(); err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return 0, err
+	if err = tx.Commit(); err != nil {
+		return false, err
 	}
+	return n > 0, nil
+}
 
-	return ogId, nil
+// IsMessageHidden tells if the user hid the message.
+func (db *appdbimpl) IsMessageHidden(usrId int64, convId int64, msgId int64) (bool, error) {
+	var hidden bool
+	query := "SELECT EXISTS (SELECT 1 FROM HiddenMessage WHERE userId = $1 AND convId = $2 AND messageId = $3)"
+	err := db.c.QueryRow(query, usrId, convId, msgId).Scan(&hidden)
+	return hidden, err
 }
 
-func (db *appdbimpl) PhotoMessage(picture model.Picture, messageId int64, msgInput model.Message, conversationId int64, userId int64) (err error) {
+func (db *appdbimpl) PhotoMessage(picture model.Picture, messageId int64, msgInput model.Message, conversationId int64, userId int64) (int64, error) {
 	tx, err := db.BeginTx()
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer func() {
 		if err = tx.Rollback(); err != nil {
@@ -381,14 +449,25 @@ func (db *appdbimpl) PhotoMessage(picture model.Picture, messageId int64, msgInp
 		}
 	}()
 
-	if msgInput.Content == "" {
-		msgInput.Content = "📷 Photo"
+	payload, err := json.Marshal(model.PhotoPayload{PhotoId: picture.Id})
+	if err != nil {
+		return 0, err
+	}
+	message := model.MessageInput{
+		Content:       msgInput.Content,
+		RepliedId:     msgInput.RepliedId,
+		RepliedConvId: msgInput.RepliedConvId,
+		Type:          model.MessagePhoto,
+		Payload:       payload,
 	}
 
-	_, err = db.createMessageWithTx(tx, msgInput.Content, picture.Id, userId, conversationId, msgInput.RepliedId, msgInput.RepliedConvId)
+	msgId, err := db.createMessageWithTx(tx, message, picture.Id, userId, conversationId)
 	if err != nil && !errors.Is(err, sql.ErrTxDone) {
-		return err
+		return 0, err
 	}
 
-	return tx.Commit()
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return msgId, nil
 }
@@ -0,0 +1,693 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// migration is a single idempotent schema change. Migrations are applied in order on every start, after the base
+// schema has been created, so databases created by older versions of the application are upgraded in place.
+type migration func(tx *sql.Tx) error
+
+// execMigration returns a migration running the given statement. The statement itself must be idempotent (e.g.
+// CREATE TABLE IF NOT EXISTS).
+func execMigration(stmt string) migration {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(stmt)
+		return err
+	}
+}
+
+// addColumnMigration returns a migration adding the column to the table only if it is not already there, as SQLite
+// has no ADD COLUMN IF NOT EXISTS.
+func addColumnMigration(table string, column string, decl string) migration {
+	return func(tx *sql.Tx) error {
+		var count int
+		q := "SELECT COUNT(*) FROM pragma_table_info($1) WHERE name = $2"
+		err := tx.QueryRow(q, table, column).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE "%s" ADD COLUMN "%s" %s`, table, column, decl))
+		return err
+	}
+}
+
+var migrations = [...]migration{
+	execMigration(`CREATE TABLE IF NOT EXISTS "Session" (
+		"sessionId"	INTEGER NOT NULL CHECK(sessionId > 0) UNIQUE,
+		"tokenHash"	TEXT NOT NULL UNIQUE,
+		"userId"	INTEGER NOT NULL,
+		"createdAt"	INTEGER NOT NULL,
+		"lastUsedAt"	INTEGER NOT NULL,
+		"expiresAt"	INTEGER NOT NULL,
+		PRIMARY KEY("sessionId" AUTOINCREMENT),
+		FOREIGN KEY("userId") REFERENCES "User"("userId") ON DELETE CASCADE
+	)`),
+	execMigration(`CREATE INDEX IF NOT EXISTS Session_userId ON Session (userId)`),
+	// Users created before passwords existed have a NULL hash: they can't log in until a password reset sets it
+	addColumnMigration("User", "passwordHash", "TEXT"),
+	addColumnMigration("Session", "deviceName", "TEXT NOT NULL DEFAULT ''"),
+	addColumnMigration("Session", "userAgent", "TEXT NOT NULL DEFAULT ''"),
+	addColumnMigration("Session", "ipAddress", "TEXT NOT NULL DEFAULT ''"),
+	// editedAt is NULL until the message is edited for the first time
+	addColumnMigration("Message", "editedAt", "INTEGER"),
+	execMigration(`CREATE TABLE IF NOT EXISTS "MessageEdit" (
+		"editId"	INTEGER NOT NULL CHECK(editId > 0) UNIQUE,
+		"messageId"	INTEGER NOT NULL,
+		"convId"	INTEGER NOT NULL,
+		"content"	TEXT NOT NULL,
+		"writtenAt"	INTEGER NOT NULL,
+		"replacedAt"	INTEGER NOT NULL,
+		PRIMARY KEY("editId" AUTOINCREMENT),
+		FOREIGN KEY("messageId","convId") REFERENCES "Message"("messageId","convId") ON DELETE CASCADE
+	)`),
+	execMigration(`CREATE INDEX IF NOT EXISTS MessageEdit_messageId_convId ON MessageEdit (messageId,convId)`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS delete_edits_before_message
+		BEFORE DELETE ON Message
+		FOR EACH ROW
+		BEGIN
+			DELETE FROM MessageEdit
+			WHERE OLD.messageId = MessageEdit.messageId AND OLD.convId = MessageEdit.convId;
+		END`),
+	execMigration(`CREATE TABLE IF NOT EXISTS "Reaction" (
+		"msgId"	INTEGER NOT NULL,
+		"convId"	INTEGER NOT NULL,
+		"userId"	INTEGER NOT NULL,
+		"emoji"	TEXT NOT NULL,
+		"createdAt"	INTEGER NOT NULL,
+		PRIMARY KEY("msgId","convId","userId","emoji"),
+		FOREIGN KEY("msgId","convId") REFERENCES "Message"("messageId","convId") ON DELETE CASCADE,
+		FOREIGN KEY("userId") REFERENCES "User"("userId") ON DELETE CASCADE
+	)`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS delete_reactions_before_message
+		BEFORE DELETE ON Message
+		FOR EACH ROW
+		BEGIN
+			DELETE FROM Reaction
+			WHERE OLD.messageId = Reaction.msgId AND OLD.convId = Reaction.convId;
+		END`),
+	commentsMigration,
+	// A single pending reset per user, identified by the hash of its token
+	execMigration(`CREATE TABLE IF NOT EXISTS PasswordReset (
+		"userId"	INTEGER PRIMARY KEY,
+		"tokenHash"	TEXT NOT NULL UNIQUE,
+		"expiresAt"	INTEGER NOT NULL,
+		FOREIGN KEY("userId") REFERENCES "User"("userId") ON DELETE CASCADE
+	)`),
+	// ChangeLog records, for each user, every change they have to apply to their copy of the data: it is what delta
+	// sync returns. The triggers below write it, so that no mutation path can forget to.
+	execMigration(`CREATE TABLE IF NOT EXISTS "ChangeLog" (
+		"changeId"	INTEGER NOT NULL CHECK(changeId > 0) UNIQUE,
+		"userId"	INTEGER NOT NULL,
+		"type"	TEXT NOT NULL,
+		"convId"	INTEGER,
+		"groupId"	INTEGER,
+		"messageId"	INTEGER,
+		"subjectId"	INTEGER,
+		"emoji"	TEXT,
+		"createdAt"	INTEGER NOT NULL,
+		PRIMARY KEY("changeId" AUTOINCREMENT)
+	)`),
+	execMigration(`CREATE INDEX IF NOT EXISTS ChangeLog_userId_changeId ON ChangeLog (userId,changeId)`),
+	execMigration(`CREATE INDEX IF NOT EXISTS ChangeLog_createdAt ON ChangeLog (createdAt)`),
+	// SyncHorizon holds the last pruned change: clients behind it have to reload everything
+	execMigration(`CREATE TABLE IF NOT EXISTS "SyncHorizon" (
+		"id"	INTEGER NOT NULL CHECK(id = 1),
+		"changeId"	INTEGER NOT NULL,
+		PRIMARY KEY("id")
+	)`),
+	execMigration(`INSERT OR IGNORE INTO SyncHorizon (id,changeId) VALUES(1,0)`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS change_message_insert
+		AFTER INSERT ON Message
+		BEGIN
+			INSERT INTO ChangeLog (userId,type,convId,messageId,subjectId,createdAt)
+			SELECT usrId,'message.created',NEW.convId,NEW.messageId,NEW.usrSenderId,unixepoch()
+			FROM Conv_User WHERE convId = NEW.convId;
+		END`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS change_message_update
+		AFTER UPDATE OF content ON Message
+		BEGIN
+			INSERT INTO ChangeLog (userId,type,convId,messageId,subjectId,createdAt)
+			SELECT usrId,'message.edited',NEW.convId,NEW.messageId,NEW.usrSenderId,unixepoch()
+			FROM Conv_User WHERE convId = NEW.convId;
+		END`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS change_message_delete
+		AFTER DELETE ON Message
+		BEGIN
+			INSERT INTO ChangeLog (userId,type,convId,messageId,subjectId,createdAt)
+			SELECT usrId,'message.deleted',OLD.convId,OLD.messageId,OLD.usrSenderId,unixepoch()
+			FROM Conv_User WHERE convId = OLD.convId;
+		END`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS change_reaction_insert
+		AFTER INSERT ON Reaction
+		BEGIN
+			INSERT INTO ChangeLog (userId,type,convId,messageId,subjectId,emoji,createdAt)
+			SELECT usrId,'reaction.added',NEW.convId,NEW.msgId,NEW.userId,NEW.emoji,unixepoch()
+			FROM Conv_User WHERE convId = NEW.convId;
+		END`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS change_reaction_delete
+		AFTER DELETE ON Reaction
+		BEGIN
+			INSERT INTO ChangeLog (userId,type,convId,messageId,subjectId,emoji,createdAt)
+			SELECT usrId,'reaction.removed',OLD.convId,OLD.msgId,OLD.userId,OLD.emoji,unixepoch()
+			FROM Conv_User WHERE convId = OLD.convId;
+		END`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS change_member_insert
+		AFTER INSERT ON Conv_User
+		BEGIN
+			INSERT INTO ChangeLog (userId,type,convId,groupId,subjectId,createdAt)
+			SELECT usrId,'conversation.member_added',NEW.convId,
+				(SELECT groupId FROM GroupTB WHERE convId = NEW.convId),NEW.usrId,unixepoch()
+			FROM Conv_User WHERE convId = NEW.convId;
+		END`),
+	// The user who left is told too, so that their other devices drop the conversation
+	execMigration(`CREATE TRIGGER IF NOT EXISTS change_member_delete
+		AFTER DELETE ON Conv_User
+		BEGIN
+			INSERT INTO ChangeLog (userId,type,convId,groupId,subjectId,createdAt)
+			SELECT usrId,'conversation.member_left',OLD.convId,
+				(SELECT groupId FROM GroupTB WHERE convId = OLD.convId),OLD.usrId,unixepoch()
+			FROM (SELECT usrId FROM Conv_User WHERE convId = OLD.convId UNION SELECT OLD.usrId);
+		END`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS change_group_update
+		AFTER UPDATE OF Name, Description, photo ON GroupTB
+		BEGIN
+			INSERT INTO ChangeLog (userId,type,convId,groupId,createdAt)
+			SELECT usrId,'group.updated',NEW.convId,NEW.groupId,unixepoch()
+			FROM Conv_User WHERE convId = NEW.convId;
+		END`),
+	// Profiles are seen by everyone sharing a conversation with the user, and by the user's other devices
+	execMigration(`CREATE TRIGGER IF NOT EXISTS change_user_update
+		AFTER UPDATE OF userName, userPhoto ON User
+		BEGIN
+			INSERT INTO ChangeLog (userId,type,subjectId,createdAt)
+			SELECT usrId,'user.updated',NEW.userId,unixepoch()
+			FROM (SELECT usrId FROM Conv_User WHERE convId IN (SELECT convId FROM Conv_User WHERE usrId = NEW.userId)
+				UNION SELECT NEW.userId);
+		END`),
+	// MessageDelivery records when each recipient's client first fetched a message. A read implies a delivery, so
+	// the reads recorded before deliveries existed are copied, before the trigger below starts logging them.
+	execMigration(`CREATE TABLE IF NOT EXISTS "MessageDelivery" (
+		"messageId"	INTEGER NOT NULL,
+		"convId"	INTEGER NOT NULL,
+		"userId"	INTEGER NOT NULL,
+		"deliveredAt"	INTEGER NOT NULL,
+		PRIMARY KEY("messageId","convId","userId"),
+		FOREIGN KEY("messageId","convId") REFERENCES "Message"("messageId","convId") ON DELETE CASCADE,
+		FOREIGN KEY("userId") REFERENCES "User"("userId") ON DELETE CASCADE
+	)`),
+	execMigration(`INSERT OR IGNORE INTO MessageDelivery (messageId,convId,userId,deliveredAt)
+		SELECT messageId,convId,userId,readTime FROM MessageReadStatus`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS delete_deliveries_before_message
+		BEFORE DELETE ON Message
+		FOR EACH ROW
+		BEGIN
+			DELETE FROM MessageDelivery
+			WHERE OLD.messageId = MessageDelivery.messageId AND OLD.convId = MessageDelivery.convId;
+		END`),
+	// Without it deleting a message someone has read violates the foreign key of MessageReadStatus
+	execMigration(`CREATE TRIGGER IF NOT EXISTS delete_read_status_before_message
+		BEFORE DELETE ON Message
+		FOR EACH ROW
+		BEGIN
+			DELETE FROM MessageReadStatus
+			WHERE OLD.messageId = MessageReadStatus.messageId AND OLD.convId = MessageReadStatus.convId;
+		END`),
+	execMigration(`CREATE TABLE IF NOT EXISTS ReadMarker (
+		"convId"	INTEGER NOT NULL,
+		"userId"	INTEGER NOT NULL,
+		"lastReadMessageId"	INTEGER NOT NULL,
+		"lastReadMtime"	INTEGER NOT NULL,
+		"updatedAt"	INTEGER NOT NULL,
+		PRIMARY KEY("convId","userId")
+	)`),
+	execMigration(`CREATE INDEX IF NOT EXISTS Message_conv_mtime ON Message (convId, mtime, messageId)`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS delete_read_marker_on_leave
+		AFTER DELETE ON Conv_User
+		BEGIN
+			DELETE FROM ReadMarker WHERE convId = OLD.convId AND userId = OLD.usrId;
+		END`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS change_conversation_read
+		AFTER INSERT ON ReadMarker
+		BEGIN
+			INSERT INTO ChangeLog (userId,type,convId,messageId,subjectId,createdAt)
+			SELECT usrId,'conversation.read',NEW.convId,NEW.lastReadMessageId,NEW.userId,unixepoch()
+			FROM Conv_User WHERE convId = NEW.convId;
+		END`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS change_conversation_read_update
+		AFTER UPDATE OF lastReadMessageId ON ReadMarker
+		BEGIN
+			INSERT INTO ChangeLog (userId,type,convId,messageId,subjectId,createdAt)
+			SELECT usrId,'conversation.read',NEW.convId,NEW.lastReadMessageId,NEW.userId,unixepoch()
+			FROM Conv_User WHERE convId = NEW.convId;
+		END`),
+	// Reads and deliveries covered by the read marker are already told by a single conversation.read change, instead
+	// of one change per message. The triggers are dropped first as older versions logged every message.
+	execMigration(`DROP TRIGGER IF EXISTS change_message_read`),
+	execMigration(`CREATE TRIGGER change_message_read
+		AFTER INSERT ON MessageReadStatus
+		WHEN NOT EXISTS (SELECT 1 FROM ReadMarker AS K JOIN Message AS M ON M.messageId = NEW.messageId AND M.convId = NEW.convId
+			WHERE K.convId = NEW.convId AND K.userId = NEW.userId AND (M.mtime, M.messageId) <= (K.lastReadMtime, K.lastReadMessageId))
+		BEGIN
+			INSERT INTO ChangeLog (userId,type,convId,messageId,subjectId,createdAt)
+			SELECT usrId,'message.read',NEW.convId,NEW.messageId,NEW.userId,unixepoch()
+			FROM Conv_User WHERE convId = NEW.convId;
+		END`),
+	// Only the sender is told about deliveries, unlike reads which also update the unread state of the reader
+	execMigration(`DROP TRIGGER IF EXISTS change_message_delivered`),
+	execMigration(`CREATE TRIGGER change_message_delivered
+		AFTER INSERT ON MessageDelivery
+		WHEN NOT EXISTS (SELECT 1 FROM ReadMarker AS K JOIN Message AS M ON M.messageId = NEW.messageId AND M.convId = NEW.convId
+			WHERE K.convId = NEW.convId AND K.userId = NEW.userId AND (M.mtime, M.messageId) <= (K.lastReadMtime, K.lastReadMessageId))
+		BEGIN
+			INSERT INTO ChangeLog (userId,type,convId,messageId,subjectId,createdAt)
+			SELECT usrSenderId,'message.delivered',NEW.convId,NEW.messageId,NEW.userId,unixepoch()
+			FROM Message WHERE messageId = NEW.messageId AND convId = NEW.convId;
+		END`),
+	addColumnMigration("Message", "type", "TEXT NOT NULL DEFAULT 'text'"),
+	addColumnMigration("Message", "payload", "TEXT"),
+	// Photo messages used to be text messages with a photo and a placeholder content
+	execMigration(`UPDATE Message SET type = 'photo', payload = json_object('photoId', photoId),
+		content = CASE WHEN content = '📷 Photo' THEN '' ELSE content END
+		WHERE photoId IS NOT NULL AND type = 'text'`),
+	// The question and the options are in the payload of the message, Poll holds what changes
+	execMigration(`CREATE TABLE IF NOT EXISTS Poll (
+		"messageId"	INTEGER NOT NULL,
+		"convId"	INTEGER NOT NULL,
+		"optionCount"	INTEGER NOT NULL,
+		"multipleChoice"	INTEGER NOT NULL DEFAULT 0,
+		"anonymous"	INTEGER NOT NULL DEFAULT 0,
+		"closesAt"	INTEGER,
+		"closedAt"	INTEGER,
+		"version"	INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY("messageId","convId"),
+		FOREIGN KEY("messageId","convId") REFERENCES "Message"("messageId","convId") ON DELETE CASCADE
+	)`),
+	execMigration(`CREATE TABLE IF NOT EXISTS PollVote (
+		"messageId"	INTEGER NOT NULL,
+		"convId"	INTEGER NOT NULL,
+		"userId"	INTEGER NOT NULL,
+		"option"	INTEGER NOT NULL,
+		"votedAt"	INTEGER NOT NULL,
+		PRIMARY KEY("messageId","convId","userId","option"),
+		FOREIGN KEY("messageId","convId") REFERENCES "Poll"("messageId","convId") ON DELETE CASCADE,
+		FOREIGN KEY("userId") REFERENCES "User"("userId") ON DELETE CASCADE
+	)`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS delete_poll_before_message
+		BEFORE DELETE ON Message
+		FOR EACH ROW
+		BEGIN
+			DELETE FROM PollVote WHERE messageId = OLD.messageId AND convId = OLD.convId;
+			DELETE FROM Poll WHERE messageId = OLD.messageId AND convId = OLD.convId;
+		END`),
+	// Every vote and the closing bump the version, so that a vote for several options is a single change
+	execMigration(`CREATE TRIGGER IF NOT EXISTS change_poll_update
+		AFTER UPDATE OF version ON Poll
+		BEGIN
+			INSERT INTO ChangeLog (userId,type,convId,messageId,createdAt)
+			SELECT usrId,'poll.updated',NEW.convId,NEW.messageId,unixepoch()
+			FROM Conv_User WHERE convId = NEW.convId;
+		END`),
+	addColumnMigration("GroupTB", "creatorId", "INTEGER"),
+	// The groups created before, and those their creator has left, are given to the member who joined them first
+	execMigration(`UPDATE GroupTB SET creatorId = (
+			SELECT userId FROM Group_User WHERE groupId = GroupTB.groupId ORDER BY rowid LIMIT 1)
+		WHERE creatorId IS NULL
+			OR creatorId NOT IN (SELECT userId FROM Group_User WHERE groupId = GroupTB.groupId)`),
+	execMigration(`CREATE TABLE IF NOT EXISTS PinnedMessage (
+		"convId"	INTEGER NOT NULL,
+		"messageId"	INTEGER NOT NULL,
+		"pinnedBy"	INTEGER NOT NULL,
+		"pinnedAt"	INTEGER NOT NULL,
+		PRIMARY KEY("convId","messageId"),
+		FOREIGN KEY("messageId","convId") REFERENCES "Message"("messageId","convId") ON DELETE CASCADE
+	)`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS delete_pin_before_message
+		BEFORE DELETE ON Message
+		FOR EACH ROW
+		BEGIN
+			DELETE FROM PinnedMessage WHERE messageId = OLD.messageId AND convId = OLD.convId;
+		END`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS change_message_pinned
+		AFTER INSERT ON PinnedMessage
+		BEGIN
+			INSERT INTO ChangeLog (userId,type,convId,messageId,subjectId,createdAt)
+			SELECT usrId,'message.pinned',NEW.convId,NEW.messageId,NEW.pinnedBy,unixepoch()
+			FROM Conv_User WHERE convId = NEW.convId;
+		END`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS change_message_unpinned
+		AFTER DELETE ON PinnedMessage
+		BEGIN
+			INSERT INTO ChangeLog (userId,type,convId,messageId,createdAt)
+			SELECT usrId,'message.unpinned',OLD.convId,OLD.messageId,unixepoch()
+			FROM Conv_User WHERE convId = OLD.convId;
+		END`),
+	execMigration(`CREATE TABLE IF NOT EXISTS StarredMessage (
+		"starId"	INTEGER PRIMARY KEY,
+		"userId"	INTEGER NOT NULL,
+		"convId"	INTEGER NOT NULL,
+		"messageId"	INTEGER NOT NULL,
+		"starredAt"	INTEGER NOT NULL,
+		UNIQUE("userId","convId","messageId"),
+		FOREIGN KEY("messageId","convId") REFERENCES "Message"("messageId","convId") ON DELETE CASCADE
+	)`),
+	execMigration(`CREATE INDEX IF NOT EXISTS StarredMessage_user_time ON StarredMessage (userId, starredAt, starId)`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS delete_star_before_message
+		BEFORE DELETE ON Message
+		FOR EACH ROW
+		BEGIN
+			DELETE FROM StarredMessage WHERE messageId = OLD.messageId AND convId = OLD.convId;
+		END`),
+	// Users who leave a conversation can no longer see its messages
+	execMigration(`CREATE TRIGGER IF NOT EXISTS delete_stars_on_leave
+		AFTER DELETE ON Conv_User
+		BEGIN
+			DELETE FROM StarredMessage WHERE convId = OLD.convId AND userId = OLD.usrId;
+		END`),
+	// Stars are private: only the devices of the user hear about them
+	execMigration(`CREATE TRIGGER IF NOT EXISTS change_message_starred
+		AFTER INSERT ON StarredMessage
+		BEGIN
+			INSERT INTO ChangeLog (userId,type,convId,messageId,createdAt)
+			VALUES (NEW.userId,'message.starred',NEW.convId,NEW.messageId,unixepoch());
+		END`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS change_message_unstarred
+		AFTER DELETE ON StarredMessage
+		BEGIN
+			INSERT INTO ChangeLog (userId,type,convId,messageId,createdAt)
+			VALUES (OLD.userId,'message.unstarred',OLD.convId,OLD.messageId,unixepoch());
+		END`),
+	execMigration(`CREATE TABLE IF NOT EXISTS ScheduledMessage (
+		"scheduledId"	INTEGER PRIMARY KEY,
+		"userId"	INTEGER NOT NULL,
+		"convId"	INTEGER NOT NULL,
+		"sendAt"	INTEGER NOT NULL,
+		"createdAt"	INTEGER NOT NULL,
+		"content"	TEXT NOT NULL,
+		"type"	TEXT NOT NULL DEFAULT 'text',
+		"payload"	TEXT,
+		"repliedId"	INTEGER,
+		"repliedConvId"	INTEGER,
+		FOREIGN KEY("userId") REFERENCES "User"("userId") ON DELETE CASCADE,
+		FOREIGN KEY("convId") REFERENCES "Conversation"("conversationId") ON DELETE CASCADE
+	)`),
+	execMigration(`CREATE INDEX IF NOT EXISTS ScheduledMessage_send_at ON ScheduledMessage (sendAt)`),
+	execMigration(`CREATE INDEX IF NOT EXISTS ScheduledMessage_user ON ScheduledMessage (userId, sendAt)`),
+	// Users who leave a conversation can no longer send messages to it
+	execMigration(`CREATE TRIGGER IF NOT EXISTS delete_scheduled_on_leave
+		AFTER DELETE ON Conv_User
+		BEGIN
+			DELETE FROM ScheduledMessage WHERE convId = OLD.convId AND userId = OLD.usrId;
+		END`),
+	addColumnMigration("Conversation", "messageLifetime", "INTEGER NOT NULL DEFAULT 0"),
+	addColumnMigration("Message", "expiresAt", "INTEGER"),
+	execMigration(`CREATE INDEX IF NOT EXISTS Message_expires_at ON Message (expiresAt) WHERE expiresAt IS NOT NULL`),
+	// Expired messages are deleted for good, so the ids are taken from a counter that never goes back instead of the
+	// last message: a new message must not get the id of a deleted one, which replies and clients may still refer to.
+	// The counter is only ever moved forward past the existing messages.
+	addColumnMigration("Conversation", "nextMessageId", "INTEGER NOT NULL DEFAULT 1"),
+	execMigration(`UPDATE Conversation SET nextMessageId = (SELECT MAX(messageId) + 1 FROM Message WHERE convId = conversationId)
+		WHERE nextMessageId <= (SELECT MAX(messageId) FROM Message WHERE convId = conversationId)`),
+	// Deleting the last message of a conversation used to delete the conversation, and the group with it. Messages are
+	// now deleted as tombstones, but the conversation must survive even a hard delete.
+	execMigration(`DROP TRIGGER IF EXISTS comprehensive_conversation_cleanup`),
+	addColumnMigration("Message", "deletedAt", "INTEGER"),
+	// A tombstone is not an edit
+	execMigration(`DROP TRIGGER IF EXISTS change_message_update`),
+	execMigration(`CREATE TRIGGER change_message_update
+		AFTER UPDATE OF content ON Message
+		WHEN NEW.deletedAt IS NULL
+		BEGIN
+			INSERT INTO ChangeLog (userId,type,convId,messageId,subjectId,createdAt)
+			SELECT usrId,'message.edited',NEW.convId,NEW.messageId,NEW.usrSenderId,unixepoch()
+			FROM Conv_User WHERE convId = NEW.convId;
+		END`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS change_message_tombstone
+		AFTER UPDATE OF deletedAt ON Message
+		WHEN OLD.deletedAt IS NULL AND NEW.deletedAt IS NOT NULL
+		BEGIN
+			INSERT INTO ChangeLog (userId,type,convId,messageId,subjectId,createdAt)
+			SELECT usrId,'message.deleted',NEW.convId,NEW.messageId,NEW.usrSenderId,unixepoch()
+			FROM Conv_User WHERE convId = NEW.convId;
+		END`),
+	execMigration(`CREATE TABLE IF NOT EXISTS HiddenMessage (
+		"userId"	INTEGER NOT NULL,
+		"convId"	INTEGER NOT NULL,
+		"messageId"	INTEGER NOT NULL,
+		"hiddenAt"	INTEGER NOT NULL,
+		PRIMARY KEY("userId","convId","messageId"),
+		FOREIGN KEY("messageId","convId") REFERENCES "Message"("messageId","convId") ON DELETE CASCADE
+	)`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS delete_hidden_before_message
+		BEFORE DELETE ON Message
+		FOR EACH ROW
+		BEGIN
+			DELETE FROM HiddenMessage WHERE messageId = OLD.messageId AND convId = OLD.convId;
+		END`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS delete_hidden_on_leave
+		AFTER DELETE ON Conv_User
+		BEGIN
+			DELETE FROM HiddenMessage WHERE convId = OLD.convId AND userId = OLD.usrId;
+		END`),
+	// Hiding is private: only the devices of the user hear about it
+	execMigration(`CREATE TRIGGER IF NOT EXISTS change_message_hidden
+		AFTER INSERT ON HiddenMessage
+		BEGIN
+			INSERT INTO ChangeLog (userId,type,convId,messageId,createdAt)
+			VALUES (NEW.userId,'message.hidden',NEW.convId,NEW.messageId,unixepoch());
+		END`),
+	// Whether the others can see when the user was last online
+	addColumnMigration("User", "showLastSeen", "INTEGER NOT NULL DEFAULT 1"),
+	addColumnMigration("Message", "mentions", "TEXT"),
+	execMigration(`CREATE TABLE IF NOT EXISTS Mention (
+		"mentionId"	INTEGER PRIMARY KEY,
+		"userId"	INTEGER NOT NULL,
+		"convId"	INTEGER NOT NULL,
+		"messageId"	INTEGER NOT NULL,
+		"createdAt"	INTEGER NOT NULL,
+		UNIQUE("userId","convId","messageId"),
+		FOREIGN KEY("messageId","convId") REFERENCES "Message"("messageId","convId") ON DELETE CASCADE
+	)`),
+	execMigration(`CREATE INDEX IF NOT EXISTS Mention_user_time ON Mention (userId, createdAt, mentionId)`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS delete_mentions_before_message
+		BEFORE DELETE ON Message
+		FOR EACH ROW
+		BEGIN
+			DELETE FROM Mention WHERE messageId = OLD.messageId AND convId = OLD.convId;
+		END`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS delete_mentions_on_leave
+		AFTER DELETE ON Conv_User
+		BEGIN
+			DELETE FROM Mention WHERE convId = OLD.convId AND userId = OLD.usrId;
+		END`),
+	// The link previewed in the message, if any, whose preview is cached in LinkPreview
+	addColumnMigration("Message", "linkUrl", "TEXT"),
+	execMigration(`CREATE INDEX IF NOT EXISTS Message_linkUrl ON Message (linkUrl) WHERE linkUrl IS NOT NULL`),
+	// A row with ok = 0 records a failed fetch, so that the link is not fetched again too soon
+	execMigration(`CREATE TABLE IF NOT EXISTS LinkPreview (
+		"url"	TEXT NOT NULL,
+		"title"	TEXT NOT NULL DEFAULT '',
+		"description"	TEXT NOT NULL DEFAULT '',
+		"imageUrl"	TEXT NOT NULL DEFAULT '',
+		"siteName"	TEXT NOT NULL DEFAULT '',
+		"fetchedAt"	INTEGER NOT NULL,
+		"ok"	INTEGER NOT NULL,
+		PRIMARY KEY("url")
+	)`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS change_link_preview_insert
+		AFTER INSERT ON LinkPreview
+		WHEN NEW.ok
+		BEGIN
+			INSERT INTO ChangeLog (userId,type,convId,messageId,createdAt)
+			SELECT C.usrId,'message.link_preview',M.convId,M.messageId,unixepoch()
+			FROM Message AS M JOIN Conv_User AS C ON C.convId = M.convId
+			WHERE M.linkUrl = NEW.url AND M.deletedAt IS NULL;
+		END`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS change_link_preview_update
+		AFTER UPDATE ON LinkPreview
+		WHEN NEW.ok AND (NOT OLD.ok OR OLD.title IS NOT NEW.title OR OLD.description IS NOT NEW.description
+			OR OLD.imageUrl IS NOT NEW.imageUrl OR OLD.siteName IS NOT NEW.siteName)
+		BEGIN
+			INSERT INTO ChangeLog (userId,type,convId,messageId,createdAt)
+			SELECT C.usrId,'message.link_preview',M.convId,M.messageId,unixepoch()
+			FROM Message AS M JOIN Conv_User AS C ON C.convId = M.convId
+			WHERE M.linkUrl = NEW.url AND M.deletedAt IS NULL;
+		END`),
+	// The files sent in messages. Name is the original filename, path where the file is stored, under a random name
+	execMigration(`CREATE TABLE IF NOT EXISTS Attachment (
+		"attachmentId"	INTEGER PRIMARY KEY,
+		"uploaderId"	INTEGER NOT NULL,
+		"name"	TEXT NOT NULL,
+		"mimeType"	TEXT NOT NULL,
+		"size"	INTEGER NOT NULL,
+		"path"	TEXT NOT NULL,
+		"createdAt"	INTEGER NOT NULL
+	)`),
+	// The messages referencing a file, to check who can download it
+	execMigration(`CREATE INDEX IF NOT EXISTS Message_fileId ON Message (json_extract(payload,'$.fileId'))
+		WHERE type = 'file'`),
+	// Photo changes used to record the paths of the photos on the server
+	execMigration(`UPDATE Message SET payload = json_remove(payload, '$.oldValue', '$.newValue')
+		WHERE type = 'system' AND json_extract(payload, '$.action') = 'photo_changed'
+		AND (json_extract(payload, '$.oldValue') IS NOT NULL OR json_extract(payload, '$.newValue') IS NOT NULL)`),
+	// The attachment stored in a file, to sweep the files none references
+	execMigration(`CREATE INDEX IF NOT EXISTS Attachment_path ON Attachment (path)`),
+	searchMigration,
+}
+
+// migrate applies every migration inside a single transaction.
+func migrate(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting migration transaction: %w", err)
+	}
+	for i, m := range migrations {
+		if err = m(tx); err != nil {
+			if e := tx.Rollback(); e != nil && !errors.Is(e, sql.ErrTxDone) {
+				return errors.New("error rolling  back the migration:" + e.Error())
+			}
+			return fmt.Errorf("error applying migration %d: %w", i, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// messageKey maps the (messageId, convId) primary key of Message to the rowid of MessageFTS. The implicit rowid of
+// Message cannot be used, as VACUUM may renumber it.
+const messageKey = "(%[1]s.convId * 4294967296 + %[1]s.messageId)"
+
+// ftsContent is the content of a message as indexed: without the characters snippets mark the matches with, so that
+// a message cannot forge a match.
+const ftsContent = "replace(replace(%[1]s.content, char(2), ''), char(3), '')"
+
+// commentsMigration turns the single reaction per user of the Comment table into their first reaction, and drops
+// Comment. The comments that are not a single emoji are lost: they were never checked, and can't be reactions.
+func commentsMigration(tx *sql.Tx) error {
+	var count int
+	err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'Comment'").Scan(&count)
+	if err != nil || count == 0 {
+		return err
+	}
+
+	// The rows are read first, as the same transaction can't write while a query is open
+	type comment struct {
+		msgId, convId, userId int64
+		content               string
+	}
+	rows, err := tx.Query("SELECT msgId,convId,userId,content FROM Comment ORDER BY rowid")
+	if err != nil {
+		return err
+	}
+	var comments []comment
+	for rows.Next() {
+		var c comment
+		if err = rows.Scan(&c.msgId, &c.convId, &c.userId, &c.content); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		if IsSingleEmoji(c.content) {
+			comments = append(comments, c)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		_ = rows.Close()
+		return err
+	}
+	_ = rows.Close()
+
+	for _, c := range comments {
+		_, err = tx.Exec("INSERT OR IGNORE INTO Reaction (msgId,convId,userId,emoji,createdAt) VALUES($1,$2,$3,$4,0)",
+			c.msgId, c.convId, c.userId, c.content)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err = tx.Exec("DROP TRIGGER IF EXISTS delete_comments_before_message"); err != nil {
+		return err
+	}
+	// The trigger cleaning up a conversation whose last message is deleted also deleted its comments
+	var cleanup string
+	err = tx.QueryRow(`SELECT IFNULL((SELECT sql FROM sqlite_master
+		WHERE type = 'trigger' AND name = 'comprehensive_conversation_cleanup'),'')`).Scan(&cleanup)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(cleanup, "DELETE FROM Comment") {
+		if _, err = tx.Exec("DROP TRIGGER comprehensive_conversation_cleanup"); err != nil {
+			return err
+		}
+		cleanup = strings.Replace(cleanup, "DELETE FROM Comment WHERE convId = OLD.convId;", "", 1)
+		if _, err = tx.Exec(cleanup); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("DROP TABLE Comment")
+	return err
+}
+
+// searchMigration creates the MessageFTS full-text index, kept in sync with Message by triggers. Without FTS5 support
+// in SQLite (the sqlite_fts5 build tag) search is disabled and the triggers are dropped, as they would make every write
+// to Message fail; the index is then rebuilt from scratch once FTS5 is available again, as are the indexes built before
+// ftsContent.
+func searchMigration(tx *sql.Tx) error {
+	var fts5 bool
+	err := tx.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5)
+	if err != nil {
+		return err
+	}
+	if !fts5 {
+		for _, trigger := range []string{"message_fts_insert", "message_fts_update", "message_fts_delete"} {
+			if _, err = tx.Exec("DROP TRIGGER IF EXISTS " + trigger); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var trigger string
+	err = tx.QueryRow(`SELECT IFNULL((SELECT sql FROM sqlite_master WHERE type = 'trigger' AND name = 'message_fts_insert'),'')`).Scan(&trigger)
+	if err != nil || strings.Contains(trigger, "char(2)") {
+		return err
+	}
+
+	stmts := []string{
+		"DROP TRIGGER IF EXISTS message_fts_insert",
+		"DROP TRIGGER IF EXISTS message_fts_update",
+		"DROP TRIGGER IF EXISTS message_fts_delete",
+		`CREATE VIRTUAL TABLE IF NOT EXISTS MessageFTS USING fts5(
+			content,
+			messageId UNINDEXED,
+			convId UNINDEXED,
+			tokenize = 'unicode61 remove_diacritics 2'
+		)`,
+		fmt.Sprintf(`CREATE TRIGGER message_fts_insert AFTER INSERT ON Message
+		BEGIN
+			INSERT INTO MessageFTS (rowid, content, messageId, convId)
+			VALUES (%s, %s, NEW.messageId, NEW.convId);
+		END`, fmt.Sprintf(messageKey, "NEW"), fmt.Sprintf(ftsContent, "NEW")),
+		fmt.Sprintf(`CREATE TRIGGER message_fts_update AFTER UPDATE OF content ON Message
+		BEGIN
+			UPDATE MessageFTS SET content = %s WHERE rowid = %s;
+		END`, fmt.Sprintf(ftsContent, "NEW"), fmt.Sprintf(messageKey, "NEW")),
+		fmt.Sprintf(`CREATE TRIGGER message_fts_delete AFTER DELETE ON Message
+		BEGIN
+			DELETE FROM MessageFTS WHERE rowid = %s;
+		END`, fmt.Sprintf(messageKey, "OLD")),
+		// The index may be stale if it was built before the triggers were dropped, or without ftsContent
+		`DELETE FROM MessageFTS`,
+		fmt.Sprintf(`INSERT INTO MessageFTS (rowid, content, messageId, convId)
+			SELECT %s, %s, M.messageId, M.convId FROM Message AS M`, fmt.Sprintf(messageKey, "M"), fmt.Sprintf(ftsContent, "M")),
+	}
+	for _, stmt := range stmts {
+		if _, err = tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
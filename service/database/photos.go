@@ -73,3 +73,13 @@ func (db *appdbimpl) InsertPhoto(pic model.Picture) (val int64, err error) {
 
 	return photoId, nil
 }
+
+// CanSeePhoto tells if the photo was sent in one of the conversations of the user.
+func (db *appdbimpl) CanSeePhoto(photoId int64, usrId int64) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM Message AS M
+		INNER JOIN Conv_User AS C ON C.convId = M.convId
+		WHERE M.photoId = $1 AND C.usrId = $2)`
+	var allowed bool
+	err := db.c.QueryRow(query, photoId, usrId).Scan(&allowed)
+	return allowed, err
+}
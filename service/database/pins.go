@@ -0,0 +1,67 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// ErrTooManyPins is returned by PinMessage when the conversation already has as many pinned messages as allowed.
+var ErrTooManyPins = errors.New("too many pinned messages in the conversation")
+
+// PinMessage pins the message in the conversation, unless there are already limit pinned messages. It returns false
+// if the message was already pinned.
+func (db *appdbimpl) PinMessage(convId int64, msgId int64, usrId int64, limit int, now int64) (bool, error) {
+	tx, err := db.BeginTx()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err = tx.Rollback(); err != nil {
+			if errors.Is(err, sql.ErrTxDone) {
+				err = nil
+			}
+		}
+	}()
+
+	var pinned, count int
+	query := `SELECT COUNT(*) FILTER (WHERE messageId = $1), COUNT(*) FROM PinnedMessage WHERE convId = $2`
+	if err = tx.QueryRow(query, msgId, convId).Scan(&pinned, &count); err != nil {
+		return false, err
+	}
+	if pinned > 0 {
+		return false, tx.Commit()
+	}
+	if count >= limit {
+		return false, ErrTooManyPins
+	}
+
+	query = "INSERT INTO PinnedMessage (convId,messageId,pinnedBy,pinnedAt) VALUES($1,$2,$3,$4)"
+	if _, err = tx.Exec(query, convId, msgId, usrId, now); err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// UnpinMessage unpins the message. It returns the number of messages unpinned, zero if it was not pinned.
+func (db *appdbimpl) UnpinMessage(convId int64, msgId int64) (int64, error) {
+	res, err := db.c.Exec("DELETE FROM PinnedMessage WHERE convId = $1 AND messageId = $2", convId, msgId)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetPins returns the pinned messages of the conversation as (messageId, pinnedBy, pinnedByName, pinnedAt), the most
+// recently pinned first. The messages the user hid are left out.
+func (db *appdbimpl) GetPins(convId int64, usrId int64) (*sql.Rows, error) {
+	query := `SELECT P.messageId, P.pinnedBy, IFNULL(U.userName,''), P.pinnedAt FROM PinnedMessage AS P
+		JOIN Message ON Message.convId = P.convId AND Message.messageId = P.messageId
+		LEFT JOIN User AS U ON U.userId = P.pinnedBy
+		WHERE P.convId = $1 AND ` + notHidden + `
+		ORDER BY P.pinnedAt DESC, P.messageId DESC`
+	return db.c.Query(query, convId, usrId)
+}
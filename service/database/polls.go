@@ -0,0 +1,119 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+)
+
+// ErrPollClosed is returned by VotePoll when the poll no longer accepts votes.
+var ErrPollClosed = errors.New("the poll is closed")
+
+// ErrInvalidVote is returned by VotePoll when the options do not exist, or are more than one for a single choice poll.
+var ErrInvalidVote = errors.New("the vote does not match the options of the poll")
+
+// createPollWithTx records the state of a poll message being created.
+func createPollWithTx(tx *HookedTx, msgId int64, convId int64, payload []byte) error {
+	var poll model.PollPayload
+	if err := json.Unmarshal(payload, &poll); err != nil {
+		return err
+	}
+	query := `INSERT INTO Poll (messageId,convId,optionCount,multipleChoice,anonymous,closesAt)
+		VALUES($1,$2,$3,$4,$5,NULLIF($6,0))`
+	_, err := tx.Exec(query, msgId, convId, len(poll.Options), poll.MultipleChoice, poll.Anonymous, poll.ClosesAt)
+	if errors.Is(err, sql.ErrTxDone) {
+		err = nil
+	}
+	return err
+}
+
+// GetPoll returns when the poll closes and when it was closed, zero if it has no close time or is still open.
+func (db *appdbimpl) GetPoll(msgId int64, convId int64) *sql.Row {
+	query := "SELECT IFNULL(closesAt,0),IFNULL(closedAt,0) FROM Poll WHERE messageId = $1 AND convId = $2"
+	return db.c.QueryRow(query, msgId, convId)
+}
+
+// GetPollVotes returns the votes of the poll as (userId, option), in the order they were cast.
+func (db *appdbimpl) GetPollVotes(msgId int64, convId int64) (*sql.Rows, error) {
+	query := "SELECT userId,option FROM PollVote WHERE messageId = $1 AND convId = $2 ORDER BY votedAt,userId,option"
+	return db.c.Query(query, msgId, convId)
+}
+
+// VotePoll replaces the vote of the user with the options, which are the indexes of the options in the payload of the
+// poll. No options withdraws the vote. It returns whether the poll is anonymous, and sql.ErrNoRows if the message is
+// not a poll.
+func (db *appdbimpl) VotePoll(msgId int64, convId int64, usrId int64, options []int, now int64) (bool, error) {
+	tx, err := db.BeginTx()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err = tx.Rollback(); err != nil {
+			if errors.Is(err, sql.ErrTxDone) {
+				err = nil
+			}
+		}
+	}()
+
+	var optionCount, closesAt, closedAt int64
+	var multipleChoice, anonymous bool
+	query := "SELECT optionCount,multipleChoice,anonymous,IFNULL(closesAt,0),IFNULL(closedAt,0) FROM Poll WHERE messageId = $1 AND convId = $2"
+	err = tx.QueryRow(query, msgId, convId).Scan(&optionCount, &multipleChoice, &anonymous, &closesAt, &closedAt)
+	if err != nil {
+		return false, err
+	}
+	if closedAt != 0 || (closesAt != 0 && now >= closesAt) {
+		return false, ErrPollClosed
+	}
+	if len(options) > 1 && !multipleChoice {
+		return false, ErrInvalidVote
+	}
+	seen := make(map[int]bool, len(options))
+	for _, option := range options {
+		if option < 0 || int64(option) >= optionCount || seen[option] {
+			return false, ErrInvalidVote
+		}
+		seen[option] = true
+	}
+
+	query = "DELETE FROM PollVote WHERE messageId = $1 AND convId = $2 AND userId = $3"
+	if _, err = tx.Exec(query, msgId, convId, usrId); err != nil {
+		return false, err
+	}
+	query = "INSERT INTO PollVote (messageId,convId,userId,option,votedAt) VALUES($1,$2,$3,$4,$5)"
+	for _, option := range options {
+		if _, err = tx.Exec(query, msgId, convId, usrId, option, now); err != nil {
+			return false, err
+		}
+	}
+	query = "UPDATE Poll SET version = version + 1 WHERE messageId = $1 AND convId = $2"
+	if _, err = tx.Exec(query, msgId, convId); err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return anonymous, nil
+}
+
+// ClosePoll stops the poll from accepting votes. It returns false if it was already closed, and sql.ErrNoRows if the
+// message is not a poll.
+func (db *appdbimpl) ClosePoll(msgId int64, convId int64, now int64) (bool, error) {
+	query := `UPDATE Poll SET closedAt = $1, version = version + 1
+		WHERE messageId = $2 AND convId = $3 AND closedAt IS NULL AND (closesAt IS NULL OR closesAt > $1)`
+	res, err := db.c.Exec(query, now, msgId, convId)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n > 0 {
+		return n > 0, err
+	}
+
+	var exists int
+	err = db.c.QueryRow("SELECT 1 FROM Poll WHERE messageId = $1 AND convId = $2", msgId, convId).Scan(&exists)
+	return false, err
+}
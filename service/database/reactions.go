@@ -0,0 +1,69 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// AddReaction adds the emoji to the reactions of the user on the message. Adding the same emoji twice is a no-op. If
+// the user already reacted with limit other emoji, nothing is added and false is returned.
+func (db *appdbimpl) AddReaction(msgId int64, convId int64, usrId int64, emoji string, limit int) (bool, error) {
+	tx, err := db.BeginTx()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err = tx.Rollback(); err != nil {
+			if errors.Is(err, sql.ErrTxDone) {
+				err = nil
+			}
+		}
+	}()
+
+	var count int
+	q := "SELECT COUNT(*) FROM Reaction WHERE msgId = $1 AND convId = $2 AND userId = $3 AND emoji != $4"
+	err = tx.QueryRow(q, msgId, convId, usrId, emoji).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	if count >= limit {
+		return false, nil
+	}
+
+	q = "INSERT OR IGNORE INTO Reaction (msgId,convId,userId,emoji,createdAt) VALUES($1,$2,$3,$4,unixepoch())"
+	_, err = tx.Exec(q, msgId, convId, usrId, emoji)
+	if err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RemoveReaction removes a single emoji from the reactions of the user, returning how many reactions were removed.
+func (db *appdbimpl) RemoveReaction(msgId int64, convId int64, usrId int64, emoji string) (int64, error) {
+	q := "DELETE FROM Reaction WHERE msgId = $1 AND convId = $2 AND userId = $3 AND emoji = $4"
+	res, err := db.c.Exec(q, msgId, convId, usrId, emoji)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetReactionCounts returns, for every emoji on the message, how many users reacted with it and whether usrId did, in
+// the order the emoji were first used.
+func (db *appdbimpl) GetReactionCounts(msgId int64, convId int64, usrId int64) (*sql.Rows, error) {
+	q := `SELECT emoji, COUNT(*), MAX(userId = $1) FROM Reaction
+		WHERE msgId = $2 AND convId = $3
+		GROUP BY emoji
+		ORDER BY MIN(rowid)`
+	return db.c.Query(q, usrId, msgId, convId)
+}
+
+// GetReactions returns every single reaction on the message as (emoji, userId), oldest first.
+func (db *appdbimpl) GetReactions(msgId int64, convId int64) (*sql.Rows, error) {
+	q := "SELECT emoji, userId FROM Reaction WHERE msgId = $1 AND convId = $2 ORDER BY rowid"
+	return db.c.Query(q, msgId, convId)
+}
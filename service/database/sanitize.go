@@ -52,3 +52,92 @@ func isEmoji(r rune) bool {
 		unicode.Is(unicode.So, r) || // Other symbols
 		unicode.Is(unicode.Sk, r) // Symbol modifier
 }
+
+// maxEmojiBytes bounds the length of a single emoji: the longest ZWJ and tag sequences are well below it.
+const maxEmojiBytes = 64
+
+// IsSingleEmoji reports whether s is exactly one emoji as the user perceives it (a grapheme cluster): a pictograph, a
+// flag, a keycap or a ZWJ sequence of pictographs, each optionally followed by a presentation selector, a skin tone
+// modifier or a tag sequence.
+func IsSingleEmoji(s string) bool {
+	if s == "" || len(s) > maxEmojiBytes {
+		return false
+	}
+	runes := []rune(s)
+
+	// Flags are a pair of regional indicators
+	if len(runes) == 2 && isRegionalIndicator(runes[0]) && isRegionalIndicator(runes[1]) {
+		return true
+	}
+
+	// Keycaps are a digit, # or *, an optional presentation selector and the combining enclosing keycap
+	if isKeycap(runes) {
+		return true
+	}
+
+	i := 0
+	for {
+		if i >= len(runes) || !isEmojiBase(runes[i]) {
+			return false
+		}
+		i++
+		if i < len(runes) && runes[i] == 0xFE0F {
+			i++
+		}
+		if i < len(runes) && isSkinTone(runes[i]) {
+			i++
+		}
+		// Tag sequences (e.g. the flag of Scotland) must be closed by the cancel tag
+		if i < len(runes) && runes[i] >= 0xE0020 && runes[i] <= 0xE007E {
+			for i < len(runes) && runes[i] >= 0xE0020 && runes[i] <= 0xE007E {
+				i++
+			}
+			if i >= len(runes) || runes[i] != 0xE007F {
+				return false
+			}
+			i++
+		}
+		if i == len(runes) {
+			return true
+		}
+		// Anything else must join the next pictograph
+		if runes[i] != 0x200D {
+			return false
+		}
+		i++
+	}
+}
+
+// isEmojiBase checks if a rune can stand alone as an emoji, i.e. it is not a modifier or a selector.
+func isEmojiBase(r rune) bool {
+	if r <= 0xFF {
+		// Only © and ® in Latin-1, the other symbols there are not emoji
+		return r == 0xA9 || r == 0xAE
+	}
+	return isEmoji(r) &&
+		!isRegionalIndicator(r) &&
+		!isSkinTone(r) &&
+		!(r >= 0xFE00 && r <= 0xFE0F) &&
+		!unicode.Is(unicode.Sk, r)
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+func isSkinTone(r rune) bool {
+	return r >= 0x1F3FB && r <= 0x1F3FF
+}
+
+func isKeycap(runes []rune) bool {
+	if len(runes) < 2 || len(runes) > 3 {
+		return false
+	}
+	if !(runes[0] >= '0' && runes[0] <= '9') && runes[0] != '#' && runes[0] != '*' {
+		return false
+	}
+	if len(runes) == 3 && runes[1] != 0xFE0F {
+		return false
+	}
+	return runes[len(runes)-1] == 0x20E3
+}
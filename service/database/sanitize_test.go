@@ -0,0 +1,44 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsSingleEmoji(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want bool
+	}{
+		{"pictograph", "😀", true},
+		{"presentation selector", "❤️", true},
+		{"skin tone", "👍🏽", true},
+		{"flag", "🇮🇹", true},
+		{"keycap", "1️⃣", true},
+		{"keycap without selector", "#⃣", true},
+		{"ZWJ sequence", "👩‍💻", true},
+		{"ZWJ sequence with skin tones", "👩🏻‍🤝‍👨🏿", true},
+		{"tag sequence", "🏴\U000E0067\U000E0062\U000E0073\U000E0063\U000E0074\U000E007F", true},
+		{"copyright sign", "©", true},
+
+		{"empty", "", false},
+		{"letters", "ab", false},
+		{"digit", "1", false},
+		{"two emoji", "😀😀", false},
+		{"emoji and text", "😀a", false},
+		{"unterminated tag sequence", "🏴\U000E0067\U000E0062\U000E0073", false},
+		{"lone skin tone", "🏽", false},
+		{"lone selector", "\uFE0F", false},
+		{"lone regional indicator", "🇮", false},
+		{"three regional indicators", "🇮🇹🇮", false},
+		{"trailing ZWJ", "👩\u200D", false},
+		{"leading ZWJ", "\u200D👩", false},
+		{"too long", strings.Repeat("👩\u200D", 12) + "👩", false},
+	}
+	for _, tt := range tests {
+		if got := IsSingleEmoji(tt.in); got != tt.want {
+			t.Errorf("%s: IsSingleEmoji(%q) = %v, want %v", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
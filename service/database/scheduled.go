@@ -0,0 +1,113 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+)
+
+// ScheduleMessage stores the message to be sent by the user in the conversation at sendAt. It returns the id of the
+// scheduled message.
+func (db *appdbimpl) ScheduleMessage(message model.MessageInput, usrId int64, convId int64, sendAt int64, now int64) (int64, error) {
+	if message.Type == "" {
+		message.Type = model.MessageText
+	}
+	query := `INSERT INTO ScheduledMessage (userId,convId,sendAt,createdAt,content,type,payload,repliedId,repliedConvId)
+		VALUES($1,$2,$3,$4,$5,$6,NULLIF($7,''),NULLIF($8,0),NULLIF($9,0))`
+	res, err := db.c.Exec(query, usrId, convId, sendAt, now, message.Content, message.Type, string(message.Payload),
+		message.RepliedId, message.RepliedConvId)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetScheduled returns the messages the user scheduled, the first to be sent first, as (scheduledId, convId, sendAt,
+// createdAt, content, type, payload, repliedId, repliedConvId).
+func (db *appdbimpl) GetScheduled(usrId int64) (*sql.Rows, error) {
+	query := `SELECT scheduledId,convId,sendAt,createdAt,content,type,IFNULL(payload,''),IFNULL(repliedId,0),IFNULL(repliedConvId,0)
+		FROM ScheduledMessage WHERE userId = $1
+		ORDER BY sendAt, scheduledId`
+	return db.c.Query(query, usrId)
+}
+
+// CancelScheduled deletes a message scheduled by the user. It returns the number of messages deleted, zero if there
+// is no such message, or it was already sent.
+func (db *appdbimpl) CancelScheduled(scheduledId int64, usrId int64) (int64, error) {
+	res, err := db.c.Exec("DELETE FROM ScheduledMessage WHERE scheduledId = $1 AND userId = $2", scheduledId, usrId)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RescheduleMessage moves a message scheduled by the user to sendAt. It returns the number of messages updated, zero
+// if there is no such message, or it was already sent.
+func (db *appdbimpl) RescheduleMessage(scheduledId int64, usrId int64, sendAt int64) (int64, error) {
+	query := "UPDATE ScheduledMessage SET sendAt = $1 WHERE scheduledId = $2 AND userId = $3"
+	res, err := db.c.Exec(query, sendAt, scheduledId, usrId)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetDueScheduled returns the ids of up to limit scheduled messages due at now, the longest overdue first.
+func (db *appdbimpl) GetDueScheduled(now int64, limit int) ([]int64, error) {
+	query := "SELECT scheduledId FROM ScheduledMessage WHERE sendAt <= $1 ORDER BY sendAt, scheduledId LIMIT $2"
+	rows, err := db.c.Query(query, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SendScheduledMessage sends a scheduled message, as CreateMessage would, and removes it from the scheduled ones in the
+// same transaction, so that it is sent once. It returns sql.ErrNoRows if the message was cancelled or already sent.
+func (db *appdbimpl) SendScheduledMessage(scheduledId int64) (int64, int64, error) {
+	tx, err := db.BeginTx()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		if err = tx.Rollback(); err != nil {
+			if errors.Is(err, sql.ErrTxDone) {
+				err = nil
+			}
+		}
+	}()
+
+	var message model.MessageInput
+	var usrId, convId int64
+	var payload string
+	query := `SELECT userId,convId,content,type,IFNULL(payload,''),IFNULL(repliedId,0),IFNULL(repliedConvId,0)
+		FROM ScheduledMessage WHERE scheduledId = $1`
+	err = tx.QueryRow(query, scheduledId).Scan(&usrId, &convId, &message.Content, &message.Type, &payload,
+		&message.RepliedId, &message.RepliedConvId)
+	if err != nil {
+		return 0, 0, err
+	}
+	message.Payload = []byte(payload)
+	if _, err = tx.Exec("DELETE FROM ScheduledMessage WHERE scheduledId = $1", scheduledId); err != nil {
+		return 0, 0, err
+	}
+
+	msgId, err := db.createMessageWithTx(tx, message, 0, usrId, convId)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return convId, msgId, nil
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+var ErrSearchUnavailable = errors.New("search is not available: SQLite was built without FTS5")
+
+// Snippets mark the matched terms with these control characters, so that the caller can escape the content before
+// turning them into markup.
+const (
+	SnippetMatchStart = "\x02"
+	SnippetMatchEnd   = "\x03"
+)
+
+// ftsQuery turns the text typed by the user into an FTS5 query: every word must match, the last one as a prefix. Words
+// are quoted, so the FTS5 query syntax (AND, NEAR, column filters...) cannot be injected.
+func ftsQuery(text string) string {
+	words := strings.Fields(text)
+	for i, w := range words {
+		words[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"`
+	}
+	if len(words) > 0 {
+		words[len(words)-1] += "*"
+	}
+	return strings.Join(words, " ")
+}
+
+// SearchMessages returns the messages matching text in the conversations usrId belongs to, best match first, as
+// (convId, messageId, snippet, mtime, usrSenderId). If convId is not zero, only that conversation is searched.
+func (db *appdbimpl) SearchMessages(usrId int64, convId int64, text string, limit int) (*sql.Rows, error) {
+	if !db.searchable {
+		return nil, ErrSearchUnavailable
+	}
+	q := `SELECT F.convId, F.messageId, snippet(MessageFTS, 0, char(2), char(3), '…', 16), M.mtime, M.usrSenderId
+		FROM MessageFTS AS F
+		JOIN Conv_User AS CU ON CU.convId = F.convId
+		JOIN Message AS M ON M.convId = F.convId AND M.messageId = F.messageId
+		WHERE MessageFTS MATCH $1 AND CU.usrId = $2 AND ($3 = 0 OR F.convId = $3) AND M.deletedAt IS NULL
+		AND NOT EXISTS (SELECT 1 FROM HiddenMessage AS H
+			WHERE H.userId = $2 AND H.convId = M.convId AND H.messageId = M.messageId)
+		ORDER BY bm25(MessageFTS)
+		LIMIT $4`
+	return db.c.Query(q, ftsQuery(text), usrId, convId, limit)
+}
@@ -3,6 +3,8 @@ package database
 import (
 	"database/sql"
 	"errors"
+
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
 )
 
 func (db *appdbimpl) DoLogin(usrName string) (res sql.Result, err error) {
@@ -32,3 +34,59 @@ func (db *appdbimpl) DoLogin(usrName string) (res sql.Result, err error) {
 	}
 	return nil, tx.Commit()
 }
+
+func (db *appdbimpl) CreateSession(session model.Session, tokenHash string) (int64, error) {
+	q := `INSERT INTO Session (tokenHash,userId,createdAt,lastUsedAt,expiresAt,deviceName,userAgent,ipAddress)
+		  VALUES($1,$2,$3,$3,$4,$5,$6,$7)`
+	res, err := db.c.Exec(q, tokenHash, session.UserId, session.CreatedAt, session.ExpiresAt,
+		session.DeviceName, session.UserAgent, session.IPAddress)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetSessionByToken returns the session identified by the token hash, as long as it has not expired yet.
+func (db *appdbimpl) GetSessionByToken(tokenHash string, now int64) (model.Session, error) {
+	var s model.Session
+	q := "SELECT sessionId,userId,createdAt,lastUsedAt,expiresAt FROM Session WHERE tokenHash = $1 AND expiresAt > $2"
+	err := db.c.QueryRow(q, tokenHash, now).Scan(&s.Id, &s.UserId, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt)
+	return s, err
+}
+
+// GetSessions returns the active sessions of the user, most recently used first.
+func (db *appdbimpl) GetSessions(usrId int64, now int64) (*sql.Rows, error) {
+	q := `SELECT sessionId,userId,deviceName,userAgent,ipAddress,createdAt,lastUsedAt,expiresAt FROM Session
+		  WHERE userId = $1 AND expiresAt > $2 ORDER BY lastUsedAt DESC`
+	return db.c.Query(q, usrId, now)
+}
+
+// TouchSession records the activity of an authenticated request on the session.
+func (db *appdbimpl) TouchSession(sessionId int64, now int64, ipAddress string, userAgent string) error {
+	q := "UPDATE Session SET lastUsedAt = $1, ipAddress = $2, userAgent = $3 WHERE sessionId = $4"
+	_, err := db.c.Exec(q, now, ipAddress, userAgent, sessionId)
+	return err
+}
+
+func (db *appdbimpl) DeleteSession(sessionId int64) error {
+	q := "DELETE FROM Session WHERE sessionId = $1"
+	_, err := db.c.Exec(q, sessionId)
+	return err
+}
+
+// DeleteUserSession revokes a session of the user, returning how many sessions were deleted.
+func (db *appdbimpl) DeleteUserSession(usrId int64, sessionId int64) (int64, error) {
+	q := "DELETE FROM Session WHERE userId = $1 AND sessionId = $2"
+	res, err := db.c.Exec(q, usrId, sessionId)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteOtherSessions revokes every session of the user except keepSessionId.
+func (db *appdbimpl) DeleteOtherSessions(usrId int64, keepSessionId int64) error {
+	q := "DELETE FROM Session WHERE userId = $1 AND sessionId != $2"
+	_, err := db.c.Exec(q, usrId, keepSessionId)
+	return err
+}
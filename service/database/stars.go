@@ -0,0 +1,35 @@
+package database
+
+import "database/sql"
+
+// StarMessage stars the message for the user. It returns false if it was already starred.
+func (db *appdbimpl) StarMessage(usrId int64, convId int64, msgId int64, now int64) (bool, error) {
+	query := "INSERT OR IGNORE INTO StarredMessage (userId,convId,messageId,starredAt) VALUES($1,$2,$3,$4)"
+	res, err := db.c.Exec(query, usrId, convId, msgId, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// UnstarMessage removes the star of the user from the message. It returns the number of stars removed, zero if it was
+// not starred.
+func (db *appdbimpl) UnstarMessage(usrId int64, convId int64, msgId int64) (int64, error) {
+	query := "DELETE FROM StarredMessage WHERE userId = $1 AND convId = $2 AND messageId = $3"
+	res, err := db.c.Exec(query, usrId, convId, msgId)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetStarred returns up to limit messages starred by the user before the (starredAt, starId) cursor, as (starId,
+// starredAt, convId, messageId), the most recently starred first.
+func (db *appdbimpl) GetStarred(usrId int64, starredAt int64, starId int64, limit int) (*sql.Rows, error) {
+	query := `SELECT starId,starredAt,convId,messageId FROM StarredMessage
+		WHERE userId = $1 AND (starredAt < $2 OR (starredAt = $2 AND starId < $3))
+		ORDER BY starredAt DESC, starId DESC
+		LIMIT $4`
+	return db.c.Query(query, usrId, starredAt, starId, limit)
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"gitlab.com/mycompany8201046/myProject/service/api/model"
+)
+
+// ErrResyncRequired is returned by GetChanges when the changes since the cursor cannot all be returned.
+var ErrResyncRequired = errors.New("the changes are no longer available, a full resync is required")
+
+// GetChanges returns the changes the user has to apply after the cursor since, oldest first, and the cursor for the
+// next call. If some of them were pruned, or they are more than limit, ErrResyncRequired is returned along with the
+// cursor to sync from after reloading everything. Cursors are change ids: they are increasing, but not contiguous as
+// the ids are shared by every user.
+func (db *appdbimpl) GetChanges(usrId int64, since int64, limit int) (changes []model.Change, cursor int64, err error) {
+	// A single transaction sees the log and its bounds consistently, even while it is written or pruned
+	tx, err := db.c.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if e := tx.Rollback(); e != nil && !errors.Is(e, sql.ErrTxDone) && err == nil {
+			err = e
+		}
+	}()
+
+	var horizon int64
+	q := `SELECT H.changeId, IFNULL((SELECT seq FROM sqlite_sequence WHERE name = 'ChangeLog'), 0)
+		FROM SyncHorizon AS H WHERE H.id = 1`
+	if err = tx.QueryRow(q).Scan(&horizon, &cursor); err != nil {
+		return nil, 0, err
+	}
+	// A cursor from the future comes from another database, e.g. after a restore
+	if since < horizon || since > cursor {
+		return nil, cursor, ErrResyncRequired
+	}
+
+	q = `SELECT changeId, type, IFNULL(convId,0), IFNULL(groupId,0), IFNULL(messageId,0), IFNULL(subjectId,0),
+		IFNULL(emoji,''), createdAt
+		FROM ChangeLog AS C WHERE userId = $1 AND changeId > $2 AND changeId <= $3
+		AND (type = 'message.hidden' OR NOT EXISTS (SELECT 1 FROM HiddenMessage AS H
+			WHERE H.userId = $1 AND H.convId = C.convId AND H.messageId = C.messageId))
+		ORDER BY changeId LIMIT $4`
+	rows, err := tx.Query(q, usrId, since, cursor, limit+1)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c model.Change
+		err = rows.Scan(&c.Seq, &c.Type, &c.ConversationId, &c.GroupId, &c.MessageId, &c.UserId, &c.Emoji, &c.Timestamp)
+		if err != nil {
+			return nil, 0, err
+		}
+		changes = append(changes, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	if len(changes) > limit {
+		return nil, cursor, ErrResyncRequired
+	}
+	return changes, cursor, nil
+}
+
+// PruneChanges deletes the changes recorded before the given time. Clients whose cursor is older have to resync.
+func (db *appdbimpl) PruneChanges(before int64) error {
+	tx, err := db.BeginTx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err = tx.Rollback(); err != nil {
+			if errors.Is(err, sql.ErrTxDone) {
+				err = nil
+			}
+		}
+	}()
+
+	q := `UPDATE SyncHorizon SET changeId = MAX(changeId, IFNULL((SELECT MAX(changeId) FROM ChangeLog WHERE createdAt < $1), 0))
+		WHERE id = 1`
+	if _, err = tx.Exec(q, before); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM ChangeLog WHERE changeId <= (SELECT changeId FROM SyncHorizon WHERE id = 1)"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -68,6 +68,126 @@ func (db *appdbimpl) InsertUser(newUsrName string) (sql.Result, error) {
 	return res, err
 }
 
+func (db *appdbimpl) RegisterUser(usrName string, passwordHash string) (int64, error) {
+	query := "INSERT INTO User (userName,passwordHash) VALUES($1,$2);"
+	res, err := db.c.Exec(query, usrName, passwordHash)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetPasswordHash returns the encoded password hash of the user, or an empty string if the user has never set one.
+func (db *appdbimpl) GetPasswordHash(usrId int64) (string, error) {
+	query := "SELECT IFNULL(passwordHash,'') FROM User WHERE userId = $1"
+	var hash string
+	err := db.c.QueryRow(query, usrId).Scan(&hash)
+	return hash, err
+}
+
+func (db *appdbimpl) SetPasswordHash(usrId int64, passwordHash string) error {
+	query := "UPDATE User SET passwordHash = $1 WHERE userId = $2"
+	_, err := db.c.Exec(query, passwordHash, usrId)
+	return err
+}
+
+// CreatePasswordReset stores the hash of a token letting the user set their password until expiresAt, replacing the
+// reset pending for the user if any.
+func (db *appdbimpl) CreatePasswordReset(usrId int64, tokenHash string, expiresAt int64) error {
+	query := `INSERT INTO PasswordReset (userId,tokenHash,expiresAt) VALUES($1,$2,$3)
+		ON CONFLICT(userId) DO UPDATE SET tokenHash = excluded.tokenHash, expiresAt = excluded.expiresAt`
+	_, err := db.c.Exec(query, usrId, tokenHash, expiresAt)
+	return err
+}
+
+// ResetPassword uses up the reset token to set the password of its user, and revokes every session of the user. It
+// returns the user, or sql.ErrNoRows if the token is unknown or has expired.
+func (db *appdbimpl) ResetPassword(tokenHash string, passwordHash string, now int64) (int64, error) {
+	tx, err := db.BeginTx()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err = tx.Rollback(); err != nil {
+			if errors.Is(err, sql.ErrTxDone) {
+				err = nil
+			}
+		}
+	}()
+
+	var usrId int64
+	q := "DELETE FROM PasswordReset WHERE tokenHash = $1 AND expiresAt > $2 RETURNING userId"
+	if err = tx.QueryRow(q, tokenHash, now).Scan(&usrId); err != nil {
+		return 0, err
+	}
+	q = "UPDATE User SET passwordHash = $1 WHERE userId = $2"
+	if _, err = tx.Exec(q, passwordHash, usrId); err != nil {
+		return 0, err
+	}
+	q = "DELETE FROM Session WHERE userId = $1"
+	if _, err = tx.Exec(q, usrId); err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return usrId, nil
+}
+
+// GetShowLastSeen tells if the user lets the others see when they were last online.
+func (db *appdbimpl) GetShowLastSeen(usrId int64) (bool, error) {
+	query := "SELECT showLastSeen FROM User WHERE userId = $1"
+	var show bool
+	err := db.c.QueryRow(query, usrId).Scan(&show)
+	return show, err
+}
+
+func (db *appdbimpl) SetShowLastSeen(usrId int64, show bool) error {
+	query := "UPDATE User SET showLastSeen = $1 WHERE userId = $2"
+	_, err := db.c.Exec(query, show, usrId)
+	return err
+}
+
+// GetLastSeen returns the last time any session of the user was used, zero if none was.
+func (db *appdbimpl) GetLastSeen(usrId int64) (int64, error) {
+	query := "SELECT IFNULL(MAX(lastUsedAt),0) FROM Session WHERE userId = $1"
+	var lastSeen int64
+	err := db.c.QueryRow(query, usrId).Scan(&lastSeen)
+	return lastSeen, err
+}
+
+// GetContactIds returns the users sharing at least one conversation with the user.
+func (db *appdbimpl) GetContactIds(usrId int64) ([]int64, error) {
+	query := `SELECT DISTINCT B.usrId FROM Conv_User AS A
+		INNER JOIN Conv_User AS B ON B.convId = A.convId
+		WHERE A.usrId = $1 AND B.usrId != $1`
+	rows, err := db.c.Query(query, usrId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SharesConversation tells if both users are participants of a same conversation.
+func (db *appdbimpl) SharesConversation(usrId int64, otherId int64) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM Conv_User AS A
+		INNER JOIN Conv_User AS B ON B.convId = A.convId
+		WHERE A.usrId = $1 AND B.usrId = $2)`
+	var shares bool
+	err := db.c.QueryRow(query, usrId, otherId).Scan(&shares)
+	return shares, err
+}
+
 func (db *appdbimpl) SetUserPhoto(pic model.Picture, usrId int64) (sql.Result, error) {
 	q := "UPDATE User SET userPhoto =$1 WHERE userId = $2"
 	return db.c.Exec(q, pic.Path, usrId)
@@ -31,6 +31,11 @@ export const errorManager = {
   }
 }
 
+// The token of the current session, sent as "Authorization: Bearer <token>"
+export function sessionToken() {
+  return localStorage.getItem("token") || sessionStorage.getItem("token")
+}
+
 const instance = axios.create({
 	baseURL: __API_URL__,
 	timeout: 1000 * 5